@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/internal/control"
+	"github.com/bingoohuang/bssh/misc"
+	sshcmd "github.com/bingoohuang/bssh/ssh"
+	"github.com/bingoohuang/ngg/ss"
+	"github.com/bingoohuang/ngg/ver"
+	"github.com/urfave/cli"
+)
+
+// Lcontrol manages the control masters of connection multiplexing (control_master = true).
+func Lcontrol() (app *cli.App) {
+	app = cli.NewApp()
+	app.Name = "bssh control"
+	app.Usage = "check and exit the control master of connection multiplexing (control_master = true)."
+	app.Copyright = misc.Copyright
+	app.Version = ver.Version()
+	app.HideHelp = true
+
+	envHosts := cli.StringSlice(strings.Split(os.Getenv("HOST"), ","))
+	app.Flags = []cli.Flag{
+		cli.StringSliceFlag{Name: "host,H", Usage: "connect server names", Value: &envHosts},
+		cli.StringFlag{
+			Name: "cnf,c", Value: ss.ExpandHome("~/.bssh.toml"),
+			Usage: "config file path",
+		},
+	}
+	app.Commands = []cli.Command{
+		{Name: "check", Usage: "check the control master is running.", ArgsUsage: "<host>...", Action: controlCheckAction},
+		{Name: "exit", Usage: "exit the control master.", ArgsUsage: "<host>...", Action: controlExitAction},
+		{Name: "master", Usage: "run the control master.", ArgsUsage: "<host>", Action: controlMasterAction, Hidden: true},
+	}
+
+	return app
+}
+
+// controlHosts returns the config and the servers of args, or -H option.
+func controlHosts(c *cli.Context) (conf.Config, []string) {
+	data := conf.ReadConf(c.GlobalString("cnf"))
+
+	hosts := c.Args()
+	if len(hosts) == 0 {
+		for _, h := range c.GlobalStringSlice("host") {
+			if h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		_ = cli.ShowCommandHelp(c, c.Command.Name)
+		os.Exit(1)
+	}
+
+	for _, host := range hosts {
+		if _, ok := data.Server[host]; !ok {
+			fmt.Fprintf(os.Stderr, "Error: not found server %s\n", host)
+			os.Exit(1)
+		}
+	}
+
+	return data, hosts
+}
+
+func controlCheckAction(c *cli.Context) error {
+	data, hosts := controlHosts(c)
+
+	code := 0
+
+	for _, host := range hosts {
+		info, err := control.Check(sshcmd.ControlSock(data.Server[host], host))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: no control master running\n", host)
+			code = 1
+
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: master running (pid=%d, clients %d, started %s)\n",
+			host, info.Pid, info.Clients, info.Started.Format("2006-01-02 15:04:05"))
+	}
+
+	os.Exit(code)
+
+	return nil
+}
+
+func controlExitAction(c *cli.Context) error {
+	data, hosts := controlHosts(c)
+
+	code := 0
+
+	for _, host := range hosts {
+		if err := control.Exit(sshcmd.ControlSock(data.Server[host], host)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: no control master running\n", host)
+			code = 1
+
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: exit request sent\n", host)
+	}
+
+	os.Exit(code)
+
+	return nil
+}
+
+func controlMasterAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		_ = cli.ShowCommandHelp(c, "master")
+		os.Exit(1)
+	}
+
+	confpath := c.GlobalString("cnf")
+
+	r := sshcmd.NewRun(confpath)
+	r.Conf = conf.ReadConf(confpath)
+
+	if err := r.ControlMaster(c.Args()[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bingoohuang/bssh/internal/forwards"
+	"github.com/bingoohuang/bssh/misc"
+	"github.com/bingoohuang/ngg/ver"
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/urfave/cli"
+)
+
+// Lforwards manages background port forwarding started by `bssh -f -N`.
+func Lforwards() (app *cli.App) {
+	app = cli.NewApp()
+	app.Name = "bssh forwards"
+	app.Usage = "list and stop background port forwarding (bssh -f -N)."
+	app.Copyright = misc.Copyright
+	app.Version = ver.Version()
+	app.HideHelp = true
+	app.Commands = []cli.Command{
+		{Name: "ls", Usage: "list background port forwarding.", Action: forwardsLsAction},
+		{Name: "stop", Usage: "stop background port forwarding.", ArgsUsage: "<id>...", Action: forwardsStopAction},
+	}
+
+	return app
+}
+
+func forwardsLsAction(c *cli.Context) error {
+	infos, err := forwards.List()
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"ID", "PID", "Server", "Forwards", "Started"})
+
+	for _, info := range infos {
+		t.AppendRow(table.Row{
+			info.ID, info.Pid, info.Server,
+			strings.Join(info.Forwards, "\n"), info.Started.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	t.Render()
+
+	return nil
+}
+
+func forwardsStopAction(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		_ = cli.ShowCommandHelp(c, "stop")
+		os.Exit(1)
+	}
+
+	code := 0
+
+	for _, id := range c.Args() {
+		if err := forwards.Stop(id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			code = 1
+
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "stopped %s\n", id)
+	}
+
+	os.Exit(code)
+
+	return nil
+}
@@ -63,8 +63,16 @@ func Lscp() (app *cli.App) {
 			Name: "cnf,c", Value: ss.ExpandHome("~/.bssh.toml"),
 			Usage: "config file path",
 		},
-		cli.BoolFlag{Name: "help,h", Usage: "print this help"},
+		cli.BoolFlag{Name: "resume", Usage: "continue the transfer from the size of the destination file"},
+		cli.BoolFlag{Name: "verify", Usage: "compare the sha256 of the source and destination files after the transfer"},
+		cli.BoolFlag{Name: "sync", Usage: "copy only the changed files by size and mtime, keeping mtime and permission"},
+		cli.BoolFlag{Name: "checksum", Usage: "compare the sha256 instead of mtime (use with --sync)"},
+		cli.BoolFlag{Name: "delete", Usage: "delete the destination files not in the source directories (use with --sync)"},
+		cli.BoolFlag{Name: "dry-run", Usage: "print the plan of --sync only"},
+		cli.BoolFlag{Name: "direct", Usage: "copy from the source server to the targets directly in REMOTE to REMOTE copy"},
 	}
+	app.Flags = append(app.Flags, common.FilterFlags()...)
+	app.Flags = append(app.Flags, cli.BoolFlag{Name: "help,h", Usage: "print this help"})
 	app.EnableBashCompletion = true
 	app.HideHelp = true
 	app.Action = lscpAction
@@ -100,6 +108,7 @@ func lscpAction(c *cli.Context) error {
 
 	// Check from and to Type
 	check.TypeError(isFromInRemote, isFromInLocal, isToRemote, len(hosts))
+	checkCopyOptions(c, isFromInRemote && isToRemote)
 
 	toServer, fromServer := parseFromToServer(hosts, names, isFromInRemote, isToRemote, data)
 
@@ -122,14 +131,70 @@ func lscpAction(c *cli.Context) error {
 	scpService.To.Server = toServer
 
 	scpService.Config = data
+	scpService.Resume = c.Bool("resume")
+	scpService.Verify = c.Bool("verify")
+	scpService.Sync = c.Bool("sync")
+	scpService.Checksum = c.Bool("checksum")
+	scpService.Delete = c.Bool("delete")
+	scpService.DryRun = c.Bool("dry-run")
+	scpService.Direct = c.Bool("direct")
+
+	filter, err := common.ContextPathFilter(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	scpService.Filter = filter
 
 	printFromTo(isFromInRemote, scpService, isToRemote)
 
 	scpService.Start(confpath)
 
+	if scpService.ExitCode != 0 {
+		os.Exit(scpService.ExitCode)
+	}
+
 	return nil
 }
 
+// checkCopyOptions exits if --direct is set except remote to remote copy or with --resume,
+// --checksum, --delete or --dry-run is set without --sync,
+// or --sync is set in remote to remote copy or with --resume.
+func checkCopyOptions(c *cli.Context, isRemoteToRemote bool) {
+	if c.Bool("direct") && !isRemoteToRemote {
+		fmt.Fprintln(os.Stderr, "--direct is only for REMOTE to REMOTE copy.")
+		os.Exit(1)
+	}
+
+	if c.Bool("direct") && c.Bool("resume") {
+		fmt.Fprintln(os.Stderr, "--direct does not correspond to --resume.")
+		os.Exit(1)
+	}
+
+	// --sync copies the changed files from the start, resuming appends to the stale destination.
+	if c.Bool("sync") && c.Bool("resume") {
+		fmt.Fprintln(os.Stderr, "--sync does not correspond to --resume.")
+		os.Exit(1)
+	}
+
+	if !c.Bool("sync") {
+		for _, name := range []string{"checksum", "delete", "dry-run"} {
+			if c.Bool(name) {
+				fmt.Fprintf(os.Stderr, "--%s needs --sync.\n", name)
+				os.Exit(1)
+			}
+		}
+
+		return
+	}
+
+	if isRemoteToRemote {
+		fmt.Fprintln(os.Stderr, "In the case of REMOTE to REMOTE copy, it does not correspond to --sync.")
+		os.Exit(1)
+	}
+}
+
 func printFromTo(isFromInRemote bool, scp *scp.Scp, isToRemote bool) {
 	// print from
 	if !isFromInRemote {
@@ -3,12 +3,15 @@ package app
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/bingoohuang/bssh/common"
 	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/internal/forwards"
 	"github.com/bingoohuang/bssh/list"
 	"github.com/bingoohuang/bssh/misc"
+	"github.com/bingoohuang/bssh/output"
 	sshcmd "github.com/bingoohuang/bssh/ssh"
 	"github.com/bingoohuang/ngg/ss"
 	"github.com/bingoohuang/ngg/ver"
@@ -42,8 +45,19 @@ USAGE:
     # parallel run command in select server over ssh
     {{.Name}} -p command...
 
+    # run command in rolling batches of 10% servers, 5 servers at a time, halt if 3 servers failed.
+    {{.Name}} --batch 10% --concurrency 5 --max-fail 3 command...
+
+    # parallel run command, print results as ndjson (or json) for jq.
+    {{.Name}} -p --output ndjson command...
+
     # parallel run command in select server over ssh, do it interactively.
     {{.Name}} -s
+
+    # port forwarding in background, list and stop them.
+    {{.Name}} -f -N -L 8080:localhost:80
+    {{.Name}} forwards ls
+    {{.Name}} forwards stop <id>
 `
 
 // Lssh ssh ...
@@ -58,11 +72,6 @@ func Lssh() (app *cli.App) {
 	app.Version = ver.Version()
 
 	// TDXX(blacknon): オプションの追加
-	//     -f       ... バックグラウンドでの接続(X11接続やport forwardingをバックグラウンドで実行する場合など)。
-	//                  「ssh -f」と同じ。 (v0.6.1)
-	//                  (https://github.com/sevlyar/go-daemon)
-	//     -a       ... 自動接続モード(接続が切れてしまった場合、自動的に再接続を試みる)。autossh的なoptionとして追加。  (v0.6.1)
-	//     -A <num> ... 自動接続モード(接続が切れてしまった場合、自動的に再接続を試みる)。再試行の回数指定(デフォルトは3回?)。  (v0.6.1)
 	//     -w       ... コマンド実行時にサーバ名ヘッダの表示をする (v0.6.0)
 	//     -W       ... コマンド実行時にサーバ名ヘッダの表示をしない (v0.6.0)
 	//     --read_profile
@@ -81,9 +90,9 @@ func Lssh() (app *cli.App) {
 		},
 
 		// port forward option
-		cli.StringFlag{Name: "L", Usage: "Local port forward mode.Specify a `[bind_address:]port:remote_addr:port`."},
-		cli.StringFlag{Name: "R", Usage: "Remote port forward mode.Specify a `[bind_address:]port:remote_addr:port`."},
-		cli.StringFlag{Name: "D", Usage: "Dynamic port forward mode(Socks5). Specify a `port`."},
+		cli.StringSliceFlag{Name: "L", Usage: "Local port forward mode.Specify a `[bind_address:]port:remote_addr:port`. repeatable."},
+		cli.StringSliceFlag{Name: "R", Usage: "Remote port forward mode.Specify a `[bind_address:]port:remote_addr:port`. repeatable. Only `[bind_address:]port` is reverse dynamic port forward mode(Socks5 at remote)."},
+		cli.StringSliceFlag{Name: "D", Usage: "Dynamic port forward mode(Socks5). Specify a `[bind_address:]port`. repeatable."},
 		// cli.StringFlag{Name: "portforward-local", Usage: "port forwarding parameter,
 		//			`address:port`. use local-forward or reverse-forward. (local port(ex. 127.0.0.1:8080))."},
 		// cli.StringFlag{Name: "portforward-remote", Usage: "port forwarding parameter,
@@ -92,7 +101,23 @@ func Lssh() (app *cli.App) {
 		// Other bool
 		cli.BoolFlag{Name: "w", Usage: "Displays the server header when in command execution mode."},
 		cli.BoolFlag{Name: "W", Usage: "Not displays the server header when in command execution mode."},
+		cli.IntFlag{Name: "concurrency", Usage: "connect and run command on max `num` servers at a time in command execution mode (0 is unlimited)."},
+		cli.StringFlag{Name: "batch", Usage: "run command in rolling batches of `N|P%` servers in command execution mode."},
+		cli.DurationFlag{Name: "batch-pause", Usage: "pause `duration` between batches."},
+		cli.StringFlag{Name: "batch-check", Usage: "local health check `command` between batches, halts further batches if it fails."},
+		cli.StringFlag{Name: "max-fail", Usage: "halt further batches when `N|P%` servers failed."},
+		cli.DurationFlag{Name: "timeout", Usage: "timeout `duration` of command on each server in command execution mode and pshell, SIGTERM then close."},
+		cli.DurationFlag{Name: "total-timeout", Usage: "timeout `duration` of command on all servers in command execution mode."},
+		cli.BoolFlag{Name: "fail-fast", Usage: "cancel the remaining servers after the first failure in command execution mode."},
+		cli.BoolFlag{Name: "group-output,g", Usage: "print each distinct output once with the servers that produced it (like dshbak -c) in command execution mode."},
+		cli.StringFlag{Name: "output,o", Value: output.FormatText, Usage: "output `format` in command execution mode, in text|json|ndjson."},
+		cli.StringFlag{Name: "script", Usage: "upload and run local script `file` on servers in command execution mode, the commands are the arguments of it."},
+		cli.StringSliceFlag{Name: "script-file", Usage: "extra `file` uploaded with --script, in the directory of ${BSSH_SCRIPT_DIR}. repeatable."},
+		cli.StringFlag{Name: "interpreter", Usage: "`command` to run --script (default: shebang of the script, or sh)."},
 		cli.BoolFlag{Name: "not-execute,N", Usage: "not execute remote command and shell."},
+		cli.BoolFlag{Name: "background,f", Usage: "run port forwarding in background (use with -N). manage by `bssh forwards`."},
+		cli.BoolFlag{Name: "auto-reconnect,a", Usage: "auto reconnect shell and port forwarding when the connection is lost (like autossh)."},
+		cli.IntFlag{Name: "A", Usage: "auto reconnect mode, with max `num` of retries at a time (0 is unlimited)."},
 		cli.BoolFlag{Name: "x11,X", Usage: "x11 forwarding(forward to ${DISPLAY})."},
 		cli.BoolFlag{Name: "term,t", Usage: "run specified command at terminal."},
 		cli.BoolFlag{Name: "parallel,p", Usage: "run command parallel node(tail -c etc...)."},
@@ -130,11 +155,16 @@ func lsshAction(c *cli.Context) error {
 	r.Conf = data
 	r.Mode = parseMode(c)
 	r.ExecCmd = c.Args() // exec command
+	r.Script = c.String("script")
+	r.ScriptFiles = c.StringSlice("script-file")
+	r.ScriptInterpreter = c.String("interpreter")
 	r.IsParallel = c.Bool("parallel")
 	r.X11 = c.Bool("x11")          // x11 forwarding
 	r.IsTerm = c.Bool("term")      // is tty
 	r.IsBashrc = c.Bool("localrc") // local bashrc use
 	r.IsNotBashrc = c.Bool("not-localrc")
+	r.AutoReconnect = c.Bool("auto-reconnect") || c.IsSet("A")
+	r.AutoReconnectMax = c.Int("A")
 
 	// set w/W flag
 	if c.Bool("w") {
@@ -147,44 +177,135 @@ func lsshAction(c *cli.Context) error {
 		r.DisableHeader = true
 	}
 
+	format, err := output.NormalizeFormat(c.String("output"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	r.OutputFormat = format
+	r.FailFast = c.Bool("fail-fast")
+	r.GroupOutput = c.Bool("group-output")
+	r.Timeout = c.Duration("timeout")
+	r.TotalTimeout = c.Duration("total-timeout")
+
+	if err := dealBatch(c, r); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
 	if err := dealPortForward(c, r); err != nil {
 		fmt.Printf("Error: %s \n", err)
 	}
 
 	// is not execute
 	r.IsNone = c.Bool("not-execute")
-	// Dynamic port forwarding port
-	r.DynamicPortForward = c.String("D")
+	// set in the re-executed background process
+	r.ForwardID = os.Getenv(forwards.EnvID)
+
+	if c.Bool("background") {
+		return startBackground(c, r, confpath)
+	}
+
 	r.Start()
+
+	if r.ExitCode != 0 {
+		os.Exit(r.ExitCode)
+	}
+
 	return nil
 }
 
-func dealPortForward(c *cli.Context, r *sshcmd.Run) error {
-	var err error
+// startBackground re-executes bssh with the selected server and port forwarding options
+// as a background process, like `ssh -f -N`.
+func startBackground(c *cli.Context, r *sshcmd.Run, confpath string) error {
+	if !r.IsNone || len(r.ServerList) != 1 {
+		fmt.Fprintln(os.Stderr, "Error: background mode(-f) needs -N and a single server.")
+		os.Exit(1)
+	}
 
-	switch {
-	case c.String("L") != "":
-		r.PortForwardMode = "L"
-		r.PortForwardLocal, r.PortForwardRemote, err = common.ParseForwardPort(c.String("L"))
+	// authenticate in the background process, no terminal to ask.
+	if prompts := r.AuthPrompts(); len(prompts) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: background mode(-f) can not ask the %s, set it in the config or use ssh-agent.\n",
+			strings.Join(prompts, ", "))
+		os.Exit(1)
+	}
 
-	case c.String("R") != "":
-		r.PortForwardMode = "R"
-		r.PortForwardLocal, r.PortForwardRemote, err = common.ParseForwardPort(c.String("R"))
+	args := []string{"-c", confpath, "-H", r.ServerList[0], "-N"}
+	for _, name := range []string{"L", "R", "D"} {
+		for _, v := range c.StringSlice(name) {
+			args = append(args, "-"+name, v)
+		}
+	}
 
-	case c.String("L") != "" && c.String("R") != "":
-		r.PortForwardMode = "R"
-		r.PortForwardLocal, r.PortForwardRemote, err = common.ParseForwardPort(c.String("R"))
+	if c.Bool("auto-reconnect") {
+		args = append(args, "-a")
+	}
 
-	default:
-		r.PortForwardMode = ""
+	if c.IsSet("A") {
+		args = append(args, "-A", strconv.Itoa(c.Int("A")))
 	}
 
-	return err
+	info, err := forwards.Start(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Background    :id %s, pid %d, log %s\n", info.ID, info.Pid, info.Log)
+
+	return nil
+}
+
+// dealBatch sets the concurrency and rolling batch options to r.
+func dealBatch(c *cli.Context, r *sshcmd.Run) (err error) {
+	r.Concurrency = c.Int("concurrency")
+	r.BatchPause = c.Duration("batch-pause")
+	r.BatchCheck = c.String("batch-check")
+
+	if v := c.String("batch"); v != "" {
+		if r.BatchSize, err = common.ParseCount(v, len(r.ServerList)); err != nil {
+			return fmt.Errorf("--batch %s: %w", v, err)
+		}
+	}
+
+	if v := c.String("max-fail"); v != "" {
+		if r.MaxFail, err = common.ParseCount(v, len(r.ServerList)); err != nil {
+			return fmt.Errorf("--max-fail %s: %w", v, err)
+		}
+	}
+
+	return nil
+}
+
+// dealPortForward sets all -L/-R/-D options to r.PortForwards.
+// -R with only `[bind_address:]port` is reverse dynamic port forward, same as OpenSSH.
+func dealPortForward(c *cli.Context, r *sshcmd.Run) error {
+	for _, mode := range []string{conf.PortForwardModeLocal, conf.PortForwardModeRemote} {
+		for _, v := range c.StringSlice(mode) {
+			if mode == conf.PortForwardModeRemote && strings.Count(v, ":") <= 1 {
+				r.PortForwards = append(r.PortForwards, conf.PortForwardConfig{Mode: conf.PortForwardModeReverseDynamic, Local: v})
+				continue
+			}
+
+			local, remote, err := common.ParseForwardPort(v)
+			if err != nil {
+				return fmt.Errorf("-%s %s: %w", mode, v, err)
+			}
+
+			r.PortForwards = append(r.PortForwards, conf.PortForwardConfig{Mode: mode, Local: local, Remote: remote})
+		}
+	}
+
+	for _, v := range c.StringSlice("D") {
+		r.PortForwards = append(r.PortForwards, conf.PortForwardConfig{Mode: conf.PortForwardModeDynamic, Local: v})
+	}
+
+	return nil
 }
 
 func parseMultiFlag(c *cli.Context) bool {
 	// Set `exec command` or `shell` flag
-	return (len(c.Args()) > 0 || c.Bool("pshell")) && !c.Bool("not-execute")
+	return (len(c.Args()) > 0 || c.Bool("pshell") || c.String("script") != "") && !c.Bool("not-execute")
 }
 
 func processListFlag(c *cli.Context, names []string, cnf conf.Config) {
@@ -200,7 +321,7 @@ func parseMode(c *cli.Context) string {
 	switch {
 	case c.Bool("pshell") && !c.Bool("not-execute"):
 		return "pshell"
-	case len(c.Args()) > 0 && !c.Bool("not-execute"):
+	case (len(c.Args()) > 0 || c.String("script") != "") && !c.Bool("not-execute"):
 		// Becomes a shell when not-execute is given.
 		return "cmd"
 	default:
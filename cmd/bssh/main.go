@@ -40,6 +40,14 @@ func main() {
 			args = append(os.Args[0:1], os.Args[1:i]...)
 			args = append(args, flagSet.Args()[1:]...)
 			ap = app.Lsftp()
+		case "forwards":
+			args = append(os.Args[0:1], os.Args[1:i]...)
+			args = append(args, flagSet.Args()[1:]...)
+			ap = app.Lforwards()
+		case "control":
+			args = append(os.Args[0:1], os.Args[1:i]...)
+			args = append(args, flagSet.Args()[1:]...)
+			ap = app.Lcontrol()
 		case misc.SSH:
 			args = append(os.Args[0:1], os.Args[1:i]...)
 			args = append(args, flagSet.Args()[1:]...)
@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -361,6 +362,37 @@ func ParseForwardPort(value string) (local, remote string, err error) {
 	return
 }
 
+// ParseCount return count from `N` or `P%` of total.
+// The percentage is rounded up, and at least 1.
+//
+// ex.)
+//   - `10` => 10
+//   - `25%` of 10 => 3
+func ParseCount(value string, total int) (int, error) {
+	value = strings.TrimSpace(value)
+
+	if p, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid percentage %q", value)
+		}
+
+		count := int(math.Ceil(float64(total) * percent / 100))
+		if count < 1 {
+			count = 1
+		}
+
+		return count, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid count %q", value)
+	}
+
+	return count, nil
+}
+
 var (
 	optionReg = regexp.MustCompile("^-")
 	parseReg  = regexp.MustCompile("^-[^-]{2,}")
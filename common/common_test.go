@@ -5,6 +5,7 @@
 package common_test
 
 import (
+	"os"
 	"testing"
 
 	"github.com/bingoohuang/bssh/common"
@@ -160,3 +161,72 @@ func TestGetMaxLength(t *testing.T) {
 		assert.Equal(t, v.expect, got, v.desc)
 	}
 }
+
+func TestParseCount(t *testing.T) {
+	type TestData struct {
+		desc   string
+		value  string
+		total  int
+		expect int
+		err    bool
+	}
+
+	tds := []TestData{
+		{desc: "Count", value: "10", total: 300, expect: 10},
+		{desc: "Zero", value: "0", total: 300, expect: 0},
+		{desc: "Percentage", value: "10%", total: 300, expect: 30},
+		{desc: "Percentage round up", value: "25%", total: 10, expect: 3},
+		{desc: "Percentage at least 1", value: "1%", total: 0, expect: 1},
+		{desc: "Negative", value: "-1", total: 10, err: true},
+		{desc: "Over 100%", value: "101%", total: 10, err: true},
+		{desc: "Not a number", value: "abc", total: 10, err: true},
+	}
+
+	for _, v := range tds {
+		got, err := common.ParseCount(v.value, v.total)
+		assert.Equal(t, v.err, err != nil, v.desc)
+		assert.Equal(t, v.expect, got, v.desc)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	type TestData struct {
+		desc             string
+		excludes         []string
+		includes         []string
+		gitIgnore        map[string]string
+		path             string
+		isDir, isExclude bool
+	}
+
+	tds := []TestData{
+		{desc: "No patterns", path: "a.log", isExclude: false},
+		{desc: "File name at any depth", excludes: []string{"*.log"}, path: "a/b/c.log", isExclude: true},
+		{desc: "Not matched", excludes: []string{"*.log"}, path: "a/b/c.txt", isExclude: false},
+		{desc: "Directory name", excludes: []string{"node_modules"}, path: "a/node_modules", isDir: true, isExclude: true},
+		{desc: "Directory only pattern on file", excludes: []string{"build/"}, path: "build", isExclude: false},
+		{desc: "Directory only pattern on dir", excludes: []string{"build/"}, path: "build", isDir: true, isExclude: true},
+		{desc: "Anchored path", excludes: []string{"/a/*.txt"}, path: "a/b.txt", isExclude: true},
+		{desc: "Anchored path not at depth", excludes: []string{"/a/*.txt"}, path: "x/a/b.txt", isExclude: false},
+		{desc: "Double star", excludes: []string{"**/tmp/*.o"}, path: "x/y/tmp/z.o", isExclude: true},
+		{desc: "Include overrides exclude", excludes: []string{"*.log"}, includes: []string{"keep.log"}, path: "d/keep.log", isExclude: false},
+		{desc: ".git with gitignore", gitIgnore: map[string]string{}, path: "sub/.git", isDir: true, isExclude: true},
+		{desc: ".gitignore pattern", gitIgnore: map[string]string{"/root/.gitignore": "*.o\n"}, path: "a/b.o", isExclude: true},
+		{desc: ".gitignore in subdirectory", gitIgnore: map[string]string{"/root/a/.gitignore": "/b.o\n"}, path: "a/b.o", isExclude: true},
+		{desc: ".gitignore negation", gitIgnore: map[string]string{"/root/.gitignore": "*.o\n", "/root/a/.gitignore": "!b.o\n"}, path: "a/b.o", isExclude: false},
+	}
+
+	for _, v := range tds {
+		filter, err := common.NewPathFilter(v.excludes, v.includes, nil, v.gitIgnore != nil)
+		assert.Nil(t, err, v.desc)
+
+		matcher := filter.Matcher("/root", func(name string) ([]byte, error) {
+			if data, ok := v.gitIgnore[name]; ok {
+				return []byte(data), nil
+			}
+
+			return nil, os.ErrNotExist
+		})
+		assert.Equal(t, v.isExclude, matcher.Excluded("/root/"+v.path, v.isDir), v.desc)
+	}
+}
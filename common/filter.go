@@ -0,0 +1,297 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// PathFilter selects the files to copy by the glob patterns of --exclude, --include and --exclude-from,
+// and the .gitignore files in the copied directories.
+// The nil filter selects all files.
+//
+// Like .gitignore, the last matched pattern decides, in the order of .gitignore (parent directory first),
+// --exclude-from, --exclude and --include. So --include overrides the others.
+// The pattern without slash matches the file name at any depth, the others match the path from the copied directory.
+// The pattern ends with slash matches only directories, and `**` matches any directories.
+// The files in an excluded directory are not walked, so they can not be included again.
+type PathFilter struct {
+	rules     []filterRule
+	gitIgnore bool
+}
+
+// filterRule is a pattern of PathFilter.
+type filterRule struct {
+	re       *regexp.Regexp
+	anchored bool // match the path, or the file name
+	dirOnly  bool
+	include  bool
+}
+
+// gitIgnoreFile is the file name of ignore patterns in a directory.
+const gitIgnoreFile = ".gitignore"
+
+// NewPathFilter returns the filter of patterns, nil if no patterns and not gitIgnore.
+// excludeFrom are the files of exclude patterns, one per line, `#` comment and `!` include like .gitignore.
+func NewPathFilter(excludes, includes, excludeFrom []string, gitIgnore bool) (*PathFilter, error) {
+	f := &PathFilter{gitIgnore: gitIgnore}
+
+	// .git is never copied with .gitignore, like git.
+	if gitIgnore {
+		f.rules = append(f.rules, parseFilterRule(".git/", false))
+	}
+
+	for _, file := range excludeFrom {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("--exclude-from %s: %w", file, err)
+		}
+
+		f.rules = append(f.rules, parseFilterRules(data)...)
+	}
+
+	for _, p := range excludes {
+		f.rules = append(f.rules, parseFilterRule(p, false))
+	}
+
+	for _, p := range includes {
+		f.rules = append(f.rules, parseFilterRule(p, true))
+	}
+
+	if len(f.rules) == 0 {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// FilterFlags returns the flags of PathFilter, for scp and sftp put/get.
+func FilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{Name: "exclude", Usage: "exclude the files matched by the glob pattern, repeatable"},
+		cli.StringSliceFlag{Name: "include", Usage: "include the files matched by the glob pattern even if excluded, repeatable"},
+		cli.StringSliceFlag{Name: "exclude-from", Usage: "read the exclude patterns from the file (.gitignore format), repeatable"},
+		cli.BoolFlag{Name: "gitignore", Usage: "exclude the files by .gitignore in the source directories, and .git"},
+	}
+}
+
+// ContextPathFilter returns the PathFilter of FilterFlags.
+func ContextPathFilter(c *cli.Context) (*PathFilter, error) {
+	return NewPathFilter(c.StringSlice("exclude"), c.StringSlice("include"),
+		c.StringSlice("exclude-from"), c.Bool("gitignore"))
+}
+
+// parseFilterRules parses the lines of .gitignore format.
+func parseFilterRules(data []byte) (rules []filterRule) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			rules = append(rules, parseFilterRule(line[1:], true))
+		} else {
+			rules = append(rules, parseFilterRule(strings.TrimPrefix(line, `\`), false))
+		}
+	}
+
+	return rules
+}
+
+// parseFilterRule parses the glob pattern.
+func parseFilterRule(pattern string, include bool) filterRule {
+	r := filterRule{include: include}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if strings.Contains(pattern, "/") {
+		r.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	r.re = regexp.MustCompile("^" + globRegexp(pattern) + "$")
+
+	return r
+}
+
+// globRegexp converts the glob pattern to regexp, `*` and `?` do not match slash, `**` matches any directories.
+func globRegexp(pattern string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				b.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			if j := strings.IndexByte(pattern[i:], ']'); j > 1 {
+				class := pattern[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+
+				b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+				i += j
+
+				continue
+			}
+
+			b.WriteString(`\[`)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
+
+// match returns true if the rule matches rel, the slash separated path from the directory of the rule.
+func (r filterRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+
+	return r.re.MatchString(rel)
+}
+
+// PathMatcher matches the files under a copied directory by PathFilter.
+// It reads and caches the .gitignore files of the directories, it is not safe for concurrent use.
+type PathMatcher struct {
+	filter   *PathFilter
+	root     string
+	readFile func(name string) ([]byte, error)
+	ignores  map[string][]filterRule
+}
+
+// Matcher returns the matcher of the files under the copied directory root.
+// The .gitignore files are read by readFile, os.ReadFile for local files or by sftp for remote files.
+func (f *PathFilter) Matcher(root string, readFile func(name string) ([]byte, error)) *PathMatcher {
+	if f == nil {
+		return nil
+	}
+
+	return &PathMatcher{filter: f, root: root, readFile: readFile, ignores: map[string][]filterRule{}}
+}
+
+// Excluded returns true if p under the copied directory is excluded.
+// The copied directory itself is never excluded.
+func (m *PathMatcher) Excluded(p string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(m.root, p)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	excluded := false
+
+	// .gitignore of the directories, from the copied directory to the parent.
+	if m.filter.gitIgnore {
+		dir := ""
+
+		for _, name := range strings.Split(rel, "/") {
+			for _, r := range m.gitIgnoreRules(dir) {
+				if r.match(strings.TrimPrefix(rel, dir), isDir) {
+					excluded = !r.include
+				}
+			}
+
+			dir += name + "/"
+		}
+	}
+
+	for _, r := range m.filter.rules {
+		if r.match(rel, isDir) {
+			excluded = !r.include
+		}
+	}
+
+	return excluded
+}
+
+// gitIgnoreRules returns the rules of .gitignore in the directory dir (slash separated, relative to root).
+func (m *PathMatcher) gitIgnoreRules(dir string) []filterRule {
+	rules, ok := m.ignores[dir]
+	if !ok {
+		if data, err := m.readFile(path.Join(filepath.ToSlash(m.root), dir, gitIgnoreFile)); err == nil {
+			rules = parseFilterRules(data)
+		}
+
+		m.ignores[dir] = rules
+	}
+
+	return rules
+}
+
+// WalkDirFilter is WalkDir with the filter, the excluded directories are not walked.
+func WalkDirFilter(dir string, filter *PathFilter) (files []string, err error) {
+	_, err = os.Lstat(dir)
+	if err != nil {
+		return
+	}
+
+	m := filter.Matcher(dir, os.ReadFile)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if IsHidden(dir, path) {
+			return nil // ignore hidden files
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if m.Excluded(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			path += "/"
+		}
+
+		files = append(files, path)
+
+		return nil
+	})
+
+	return
+}
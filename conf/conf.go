@@ -102,6 +102,13 @@ type ShellConfig struct {
 	// pre | post command setting
 	PreCmd  string `toml:"pre_cmd"`
 	PostCmd string `toml:"post_cmd"`
+
+	// options of parallel shell, changed by `%set` in the shell.
+	HeaderInPipe  bool   `toml:"header_in_pipe"`  // add OPROMPT to the remote output to pipe
+	NoRecordLocal bool   `toml:"no_record_local"` // do not record the local command output to history
+	NoComplete    bool   `toml:"no_complete"`     // disable the completion of remote commands and paths
+	Timeout       string `toml:"timeout"`         // timeout of remote command, e.g. "30s"
+	Group         bool   `toml:"group"`           // print each distinct output once with the servers
 }
 
 // IncludeConfig specify the configuration file to include (ServerConfig only).
@@ -177,7 +184,18 @@ type ServerConfig struct {
 
 	// Dynamic Port Forwarding setting
 	DynamicPortForward string `toml:"dynamic_port_forward"` // ex.) "11080"
-	Note               string
+
+	// Reverse Dynamic Port Forwarding setting, Socks5 at remote and dial from local
+	ReverseDynamicPortForward string `toml:"reverse_dynamic_port_forward"` // ex.) "11080"
+	Note                      string
+
+	// Multiple port forwarding setting ([[server.X.forward]])
+	PortForwards []PortForwardConfig `toml:"forward"`
+
+	// Host key checking policy, like OpenSSH StrictHostKeyChecking.
+	// `yes`|`accept-new`|`ask`|`no` (default: no)
+	StrictHostKeyChecking string   `toml:"strict_host_key_checking"`
+	KnownHostsFiles       []string `toml:"known_hosts_files"` // default: ["~/.ssh/known_hosts"]
 
 	// Connection Timeout second
 	ConnectTimeout int `toml:"connect_timeout"`
@@ -186,6 +204,11 @@ type ServerConfig struct {
 	ServerAliveCountMax      int `toml:"alive_max"`
 	ServerAliveCountInterval int `toml:"alive_interval"`
 
+	// Connection multiplexing by the control socket across bssh invocations, like OpenSSH ControlMaster.
+	ControlMaster  bool   `toml:"control_master"`
+	ControlPersist string `toml:"control_persist"` // time to keep the connection without clients, "0" is unlimited. default: "10m"
+	ControlPath    string `toml:"control_path"`    // directory of control sockets. default: "~/.bssh.d/control"
+
 	InitialCmd      string       `toml:"initial_cmd"`
 	InitialCmdSleep TomlDuration `toml:"initial_cmd_sleep"`
 	WebPort         int          `toml:"web_port"` // <= 0 disable the web port
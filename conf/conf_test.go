@@ -5,6 +5,8 @@
 package conf_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bingoohuang/bssh/conf"
@@ -169,3 +171,85 @@ func TestGetNameList(t *testing.T) {
 		assert.Equal(t, v.expect, got, v.desc)
 	}
 }
+
+func TestGetPortForwards(t *testing.T) {
+	c := conf.ServerConfig{
+		PortForwardMode:    "local",
+		PortForwardLocal:   "localhost:8080",
+		PortForwardRemote:  "localhost:80",
+		DynamicPortForward: "11080",
+		PortForwards: []conf.PortForwardConfig{
+			{Mode: "r", Local: "localhost:22", Remote: "localhost:2222"},
+			{Mode: "D", Local: "127.0.0.1:11081"},
+			{Mode: "L", Local: "localhost:9090"}, // no remote, skipped
+		},
+	}
+
+	expect := []conf.PortForwardConfig{
+		{Mode: "L", Local: "localhost:8080", Remote: "localhost:80"},
+		{Mode: "D", Local: "11080"},
+		{Mode: "R", Local: "localhost:22", Remote: "localhost:2222"},
+		{Mode: "D", Local: "127.0.0.1:11081"},
+	}
+	assert.Equal(t, expect, c.GetPortForwards())
+
+	addr, port := expect[1].DynamicAddr()
+	assert.Equal(t, "localhost:11080", addr+":"+port)
+	assert.Equal(t, "D 127.0.0.1:11081", expect[3].String())
+}
+
+func TestSetShellKey(t *testing.T) {
+	type TestData struct {
+		desc       string
+		content    string
+		key, value string
+		expect     string
+	}
+
+	tds := []TestData{
+		{
+			desc:    "Replace the key",
+			content: "[shell]\nPROMPT = \"> \"\ngroup = false # comment\n\n[server.a]\ngroup = 1\n",
+			key:     "group", value: "true",
+			expect: "[shell]\nPROMPT = \"> \"\ngroup = true\n\n[server.a]\ngroup = 1\n",
+		},
+		{
+			desc:    "Add the key after the header",
+			content: "[shell]\nPROMPT = \"> \"\n\n[server.a]\ntimeout = 1\n",
+			key:     "timeout", value: `"30s"`,
+			expect: "[shell]\ntimeout = \"30s\"\nPROMPT = \"> \"\n\n[server.a]\ntimeout = 1\n",
+		},
+		{
+			desc:    "Add the table",
+			content: "[server.a]\naddr = \"1.2.3.4\"",
+			key:     "no_complete", value: "true",
+			expect: "[server.a]\naddr = \"1.2.3.4\"\n\n[shell]\nno_complete = true\n",
+		},
+		{
+			desc:    "Not the key with the same prefix",
+			content: "[shell]\ngroup_x = 1\n",
+			key:     "group", value: "true",
+			expect: "[shell]\ngroup = true\ngroup_x = 1\n",
+		},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, conf.SetShellKey(v.content, v.key, v.value), v.desc)
+	}
+}
+
+func TestSetShellOption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bssh.toml")
+	assert.Nil(t, os.WriteFile(path, []byte("# my config\n[shell]\nPROMPT = \"> \"\n"), 0o600))
+
+	assert.Nil(t, conf.SetShellOption(path, "timeout", `"30s"`))
+	assert.NotNil(t, conf.SetShellOption(path, "timeout", "30s"), "invalid toml is not written")
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "# my config\n[shell]\ntimeout = \"30s\"\nPROMPT = \"> \"\n", string(data))
+
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), stat.Mode().Perm())
+}
@@ -0,0 +1,132 @@
+package conf
+
+import (
+	"net"
+	"strings"
+)
+
+// Port forwarding mode.
+const (
+	PortForwardModeLocal          = "L"
+	PortForwardModeRemote         = "R"
+	PortForwardModeDynamic        = "D"
+	PortForwardModeReverseDynamic = "RD"
+)
+
+// PortForwardConfig is a port forwarding setting.
+// A server can have multiple of them, written as an array of tables:
+//
+//	[[server.db.forward]]
+//	mode = "L"
+//	local = "localhost:15432"
+//	remote = "localhost:5432"
+//
+//	[[server.db.forward]]
+//	mode = "D"
+//	local = "11080"
+type PortForwardConfig struct {
+	// Mode in [`L`,`l`,`LOCAL`,`local`]|[`R`,`r`,`REMOTE`,`remote`]|[`D`,`d`,`DYNAMIC`,`dynamic`]|
+	// [`RD`,`rd`,`REVERSE_DYNAMIC`,`reverse_dynamic`]
+	Mode string `toml:"mode"`
+
+	// Local is "host:port" at local. In dynamic mode, it is the listen "[host:]port" of Socks5.
+	// In reverse dynamic mode, it is the listen "[host:]port" of Socks5 at remote.
+	Local string `toml:"local"`
+
+	// Remote is "host:port" at remote. Not used in dynamic mode.
+	Remote string `toml:"remote"`
+}
+
+// NormalizePortForwardMode returns `L`, `R` or `D` from port forward mode string.
+// Empty mode is local port forward.
+func NormalizePortForwardMode(mode string) string {
+	switch strings.ToLower(mode) {
+	case "", "l", "local":
+		return PortForwardModeLocal
+	case "r", "remote":
+		return PortForwardModeRemote
+	case "d", "dynamic":
+		return PortForwardModeDynamic
+	case "rd", "reverse_dynamic":
+		return PortForwardModeReverseDynamic
+	default:
+		return mode
+	}
+}
+
+// IsDynamic tells the port forward is dynamic or reverse dynamic (Socks5).
+func (p PortForwardConfig) IsDynamic() bool {
+	return p.Mode == PortForwardModeDynamic || p.Mode == PortForwardModeReverseDynamic
+}
+
+// DynamicAddr returns listen address and port of the dynamic or reverse dynamic port forward.
+func (p PortForwardConfig) DynamicAddr() (address, port string) {
+	if host, port, err := net.SplitHostPort(p.Local); err == nil {
+		if host == "" {
+			host = "localhost"
+		}
+
+		return host, port
+	}
+
+	return "localhost", p.Local
+}
+
+// String returns port forwarding description, ex.) `L localhost:8080 => localhost:80`.
+func (p PortForwardConfig) String() string {
+	switch p.Mode {
+	case PortForwardModeRemote:
+		return "R " + p.Local + " <= " + p.Remote
+	case PortForwardModeDynamic:
+		return "D " + net.JoinHostPort(p.DynamicAddr())
+	case PortForwardModeReverseDynamic:
+		return "RD " + net.JoinHostPort(p.DynamicAddr())
+	default:
+		return "L " + p.Local + " => " + p.Remote
+	}
+}
+
+// GetPortForwards returns all port forwarding settings of the server config.
+// The single `port_forward*`, `dynamic_port_forward` and `reverse_dynamic_port_forward` settings are placed first.
+// Settings without address are skipped.
+func (c ServerConfig) GetPortForwards() (forwards []PortForwardConfig) {
+	if c.PortForwardLocal != "" && c.PortForwardRemote != "" {
+		forwards = append(forwards, PortForwardConfig{
+			Mode: c.PortForwardMode, Local: c.PortForwardLocal, Remote: c.PortForwardRemote,
+		})
+	}
+
+	if c.DynamicPortForward != "" {
+		forwards = append(forwards, PortForwardConfig{Mode: PortForwardModeDynamic, Local: c.DynamicPortForward})
+	}
+
+	if c.ReverseDynamicPortForward != "" {
+		forwards = append(forwards, PortForwardConfig{Mode: PortForwardModeReverseDynamic, Local: c.ReverseDynamicPortForward})
+	}
+
+	forwards = append(forwards, c.PortForwards...)
+
+	result := make([]PortForwardConfig, 0, len(forwards))
+
+	for _, f := range forwards {
+		f.Mode = NormalizePortForwardMode(f.Mode)
+
+		switch {
+		case f.Local == "":
+			continue
+		case !f.IsDynamic() && f.Remote == "":
+			continue
+		}
+
+		result = append(result, f)
+	}
+
+	return result
+}
+
+// SetPortForwards replaces all port forwarding settings of the server config.
+func (c *ServerConfig) SetPortForwards(forwards []PortForwardConfig) {
+	c.PortForwardMode, c.PortForwardLocal, c.PortForwardRemote = "", "", ""
+	c.DynamicPortForward, c.ReverseDynamicPortForward = "", ""
+	c.PortForwards = forwards
+}
@@ -102,14 +102,24 @@ func createServerConfig(host string, ele string) (string, ServerConfig) {
 		serverConfig.X11 = true
 	}
 
-	parseLocalPortForwarding(host, &serverConfig)
+	// Port forwarding (Local/Remote/Dynamic forward), every line is used.
+	for _, v := range ssh_config.GetAll(host, "LocalForward") {
+		if f, ok := parseOpenSSHPortForward(PortForwardModeLocal, v); ok {
+			serverConfig.PortForwards = append(serverConfig.PortForwards, f)
+		}
+	}
 
-	parseRemotePortForwarding(host, &serverConfig)
+	for _, v := range ssh_config.GetAll(host, "RemoteForward") {
+		if f, ok := parseOpenSSHPortForward(PortForwardModeRemote, v); ok {
+			serverConfig.PortForwards = append(serverConfig.PortForwards, f)
+		}
+	}
 
-	// Port forwarding (Dynamic forward)
-	dynamicForward := ssh_config.Get(host, "DynamicForward")
-	if dynamicForward != "" {
-		serverConfig.DynamicPortForward = dynamicForward
+	for _, v := range ssh_config.GetAll(host, "DynamicForward") {
+		if v != "" {
+			serverConfig.PortForwards = append(serverConfig.PortForwards,
+				PortForwardConfig{Mode: PortForwardModeDynamic, Local: v})
+		}
 	}
 
 	serverName := ele + ":" + host
@@ -117,64 +127,38 @@ func createServerConfig(host string, ele string) (string, ServerConfig) {
 	return serverName, serverConfig
 }
 
-func parseRemotePortForwarding(host string, serverConfig *ServerConfig) {
-	// Port forwarding (Remote forward)
-	remoteForward := ssh_config.Get(host, "RemoteForward")
-	if remoteForward == "" {
-		return
-	}
-
-	array := strings.SplitN(remoteForward, " ", 2)
+// parseOpenSSHPortForward parses `LocalForward`/`RemoteForward` value, ex.) `8080 localhost:80`.
+// The 1st column is the listen address, it is at remote side in `RemoteForward`.
+// `RemoteForward` with only the listen address is reverse dynamic forward.
+func parseOpenSSHPortForward(mode, value string) (f PortForwardConfig, ok bool) {
+	array := strings.Fields(value)
 
-	if len(array) <= 1 {
-		return
+	if len(array) == 1 && mode == PortForwardModeRemote {
+		return PortForwardConfig{Mode: PortForwardModeReverseDynamic, Local: array[0]}, true
 	}
 
-	var e error
-
-	_, e = strconv.Atoi(array[0])
-	if e != nil { // localhost:8080
-		serverConfig.PortForwardLocal = array[0]
-	} else { // 8080
-		serverConfig.PortForwardLocal = "localhost:" + array[0]
+	if len(array) != 2 {
+		return f, false
 	}
 
-	_, e = strconv.Atoi(array[1])
-	if e != nil { // localhost:8080
-		serverConfig.PortForwardRemote = array[1]
-	} else { // 8080
-		serverConfig.PortForwardRemote = "localhost:" + array[1]
-	}
-}
+	f.Mode = mode
+	f.Local = openSSHForwardAddr(array[0])
+	f.Remote = openSSHForwardAddr(array[1])
 
-func parseLocalPortForwarding(host string, serverConfig *ServerConfig) {
-	// Port forwarding (Local forward)
-	localForward := ssh_config.Get(host, "LocalForward")
-	if localForward == "" {
-		return
+	if mode == PortForwardModeRemote {
+		f.Local, f.Remote = f.Remote, f.Local
 	}
 
-	array := strings.SplitN(localForward, " ", 2)
-
-	if len(array) <= 1 {
-		return
-	}
-
-	var e error
+	return f, true
+}
 
-	_, e = strconv.Atoi(array[0])
-	if e != nil { // localhost:8080
-		serverConfig.PortForwardLocal = array[0]
-	} else { // 8080
-		serverConfig.PortForwardLocal = "localhost:" + array[0]
+// openSSHForwardAddr returns "host:port" from `port` or `host:port`.
+func openSSHForwardAddr(value string) string {
+	if _, e := strconv.Atoi(value); e != nil { // localhost:8080
+		return value
 	}
 
-	_, e = strconv.Atoi(array[1])
-	if e != nil { // localhost:8080
-		serverConfig.PortForwardRemote = array[1]
-	} else { // 8080
-		serverConfig.PortForwardRemote = "localhost:" + array[1]
-	}
+	return "localhost:" + value // 8080
 }
 
 func createHostList(cfg *ssh_config.Config) []string {
@@ -0,0 +1,104 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bingoohuang/ngg/ss"
+)
+
+var (
+	shellTableRegexp = regexp.MustCompile(`^\s*\[\s*shell\s*\]\s*(#.*)?$`)
+	tableRegexp      = regexp.MustCompile(`^\s*\[`)
+)
+
+// SetShellOption writes `key = value` (value in TOML) to the [shell] table of the config file,
+// replacing the key if it exists. The other lines and comments are kept as they are.
+func SetShellOption(confPath, key, value string) error {
+	confPath = ss.ExpandHome(confPath)
+
+	stat, err := os.Stat(confPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+
+	content := SetShellKey(string(data), key, value)
+
+	var config Config
+	if _, err := toml.Decode(content, &config); err != nil {
+		return fmt.Errorf("%s = %s: %w", key, value, err)
+	}
+
+	// write a temporary file and rename it, not to break the config on error.
+	tmp, err := os.CreateTemp(filepath.Dir(confPath), filepath.Base(confPath)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), stat.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), confPath)
+}
+
+// SetShellKey returns content of the config with `key = value` in the [shell] table.
+// The line of key is replaced, or added after the table header. The table is added at the end if not exists.
+func SetShellKey(content, key, value string) string {
+	line := key + " = " + value
+	keyRegexp := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(key) + `\s*=`)
+
+	lines := strings.Split(content, "\n")
+	header := -1
+
+	for i, l := range lines {
+		if header < 0 {
+			if shellTableRegexp.MatchString(l) {
+				header = i
+			}
+
+			continue
+		}
+
+		if tableRegexp.MatchString(l) {
+			break
+		}
+
+		if keyRegexp.MatchString(l) {
+			lines[i] = line
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	if header < 0 {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+
+		return content + "\n[shell]\n" + line + "\n"
+	}
+
+	lines = append(lines[:header+1], append([]string{line}, lines[header+1:]...)...)
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,519 @@
+// Package control multiplexes the ssh connection of a server across bssh invocations, like OpenSSH ControlMaster.
+//
+// The master process keeps the ssh.Client of the server, and serves the ssh protocol on a unix socket
+// (the control socket). The channels and global requests of the clients on the socket are forwarded
+// to the server, so the clients open new sessions without dialing the proxy route and authenticating again.
+package control
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/ngg/ss"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultDir is the default directory of control sockets.
+	DefaultDir = "~/.bssh.d/control"
+
+	// DefaultPersist is the default time to keep the master without clients.
+	DefaultPersist = 10 * time.Minute
+
+	reqCheck = "bssh-control-check@bssh"
+	reqExit  = "bssh-control-exit@bssh"
+
+	// startTimeout is the wait time for the master process to connect and listen the control socket.
+	startTimeout = 60 * time.Second
+	dialTimeout  = 1 * time.Second
+)
+
+// Info is the status of a master process.
+type Info struct {
+	Pid     int       `json:"pid"`
+	Server  string    `json:"server"`
+	Started time.Time `json:"started"`
+	Clients int       `json:"clients"`
+}
+
+// SockPath returns the control socket in dir of the connection key (server name, user, address and port).
+// The name is hashed to keep the path short, unix socket path is limited to about 100 bytes.
+func SockPath(dir, key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(ss.ExpandHome(dir), hex.EncodeToString(sum[:8])+".sock")
+}
+
+func logFile(sock string) string { return strings.TrimSuffix(sock, ".sock") + ".log" }
+
+// Dial connects to the master on sock, and returns the client to open sessions and channels over it.
+// The socket is protected by the permission of the directory, the host key of master is not checked.
+func Dial(sock string) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("unix", sock, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{User: "bssh", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: dialTimeout}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, sock, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Check returns the info of the master on sock.
+func Check(sock string) (*Info, error) {
+	client, err := Dial(sock)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(reqCheck, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errors.New("check request refused")
+	}
+
+	var info Info
+	if err := json.Unmarshal(reply, &info); err != nil {
+		return nil, err
+	}
+
+	// this connection is not counted.
+	info.Clients--
+
+	return &info, nil
+}
+
+// Exit stops the master on sock, the connections over it are closed.
+func Exit(sock string) error {
+	client, err := Dial(sock)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, _, err = client.SendRequest(reqExit, true, nil)
+
+	return err
+}
+
+// Start re-executes the current program with args as a detached master process,
+// and waits until it is connected and serving sock.
+func Start(sock string, args []string) error {
+	if err := prepareDir(filepath.Dir(sock)); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	logPath := logFile(sock)
+
+	out, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	for deadline := time.Now().Add(startTimeout); time.Now().Before(deadline); {
+		if _, err := Check(sock); err == nil {
+			return nil
+		}
+
+		select {
+		case <-exited:
+			// another master may win the socket.
+			if _, err := Check(sock); err == nil {
+				return nil
+			}
+
+			return fmt.Errorf("control master exited, see log %s", logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return fmt.Errorf("control master not ready in %s, see log %s", startTimeout, logPath)
+}
+
+// prepareDir creates the directory of control sockets, and checks it is private to the user.
+// The clients on the socket are not authenticated, anyone who can connect it uses the connection of the server.
+func prepareDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("control path %s is not a directory", dir)
+	}
+
+	return checkPrivate(dir, info)
+}
+
+// master serves the connection of a server on the control socket.
+type master struct {
+	remote *ssh.Client
+	config *ssh.ServerConfig
+	info   Info
+
+	mu      sync.Mutex
+	clients map[*ssh.ServerConn]bool
+	idle    *time.Timer
+	persist time.Duration
+
+	// forwards are the clients requested the remote port forwarding, by the bind address.
+	forwards map[string]*ssh.ServerConn
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Serve serves the connection remote of server on sock, until no clients for persist (0 is unlimited),
+// `Exit` is requested or the connection is lost.
+// It returns an error if sock is served by another master already.
+func Serve(remote *ssh.Client, server, sock string, persist time.Duration) error {
+	if err := prepareDir(filepath.Dir(sock)); err != nil {
+		return err
+	}
+
+	if info, err := Check(sock); err == nil {
+		return fmt.Errorf("control master of %s is running already, pid %d", info.Server, info.Pid)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+
+	m := &master{
+		remote:   remote,
+		config:   &ssh.ServerConfig{NoClientAuth: true},
+		info:     Info{Pid: os.Getpid(), Server: server, Started: time.Now()},
+		clients:  map[*ssh.ServerConn]bool{},
+		persist:  persist,
+		forwards: map[string]*ssh.ServerConn{},
+		stop:     make(chan struct{}),
+	}
+	m.config.AddHostKey(signer)
+
+	_ = os.Remove(sock)
+
+	ln, err := listenPrivate(sock)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(sock)
+	defer ln.Close()
+
+	if err := os.Chmod(sock, 0o600); err != nil {
+		return err
+	}
+
+	go m.forwardedChannels(remote.HandleChannelOpen("forwarded-tcpip"))
+
+	go func() {
+		_ = remote.Wait()
+		m.close()
+	}()
+
+	m.mu.Lock()
+	m.resetIdle()
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				m.close()
+				return
+			}
+
+			go m.serveConn(conn)
+		}
+	}()
+
+	<-m.stop
+
+	m.mu.Lock()
+	for c := range m.clients {
+		_ = c.Close()
+	}
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *master) close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// resetIdle starts the idle timer if no clients, or stops it. m.mu is held.
+func (m *master) resetIdle() {
+	if m.idle != nil {
+		m.idle.Stop()
+		m.idle = nil
+	}
+
+	if len(m.clients) == 0 && m.persist > 0 {
+		m.idle = time.AfterFunc(m.persist, m.close)
+	}
+}
+
+// serveConn serves a client connection, until it is closed.
+func (m *master) serveConn(conn net.Conn) {
+	c, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	m.mu.Lock()
+	m.clients[c] = true
+	m.resetIdle()
+	m.mu.Unlock()
+
+	go m.globalRequests(c, reqs)
+
+	for ch := range chans {
+		go m.openChannel(ch)
+	}
+
+	_ = c.Wait()
+
+	m.mu.Lock()
+	delete(m.clients, c)
+
+	for addr, fc := range m.forwards {
+		if fc == c {
+			delete(m.forwards, addr)
+		}
+	}
+
+	m.resetIdle()
+	m.mu.Unlock()
+}
+
+// tcpipForward is the payload of `tcpip-forward` request and `forwarded-tcpip` channel (RFC 4254 7.1, 7.2).
+type tcpipForward struct {
+	Addr string
+	Port uint32
+}
+
+// globalRequests serves the global requests of client c, the others than control requests are forwarded to the server.
+func (m *master) globalRequests(c *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case reqCheck:
+			m.mu.Lock()
+			info := m.info
+			info.Clients = len(m.clients)
+			m.mu.Unlock()
+
+			data, _ := json.Marshal(info)
+			_ = req.Reply(true, data)
+		case reqExit:
+			_ = req.Reply(true, nil)
+			m.close()
+		default:
+			ok, reply, err := m.remote.SendRequest(req.Type, req.WantReply, req.Payload)
+			if err != nil {
+				ok = false
+			}
+
+			if ok {
+				m.registerForward(c, req, reply)
+			}
+
+			_ = req.Reply(ok, reply)
+		}
+	}
+}
+
+// registerForward keeps the client of the remote port forwarding, to route the `forwarded-tcpip` channels to it.
+func (m *master) registerForward(c *ssh.ServerConn, req *ssh.Request, reply []byte) {
+	var f tcpipForward
+	if req.Type != "tcpip-forward" && req.Type != "cancel-tcpip-forward" || ssh.Unmarshal(req.Payload, &f) != nil {
+		return
+	}
+
+	// the port allocated by the server.
+	if f.Port == 0 && len(reply) >= 4 {
+		var r struct{ Port uint32 }
+		if ssh.Unmarshal(reply, &r) == nil {
+			f.Port = r.Port
+		}
+	}
+
+	addr := net.JoinHostPort(f.Addr, strconv.Itoa(int(f.Port)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req.Type == "tcpip-forward" {
+		m.forwards[addr] = c
+	} else {
+		delete(m.forwards, addr)
+	}
+}
+
+// forwardedChannels routes the `forwarded-tcpip` channels from the server to the clients requested them.
+// The bind address may be reported differently by the server, then the client is found by the port.
+func (m *master) forwardedChannels(chans <-chan ssh.NewChannel) {
+	for ch := range chans {
+		var f tcpipForward
+		if err := ssh.Unmarshal(ch.ExtraData(), &f); err != nil {
+			_ = ch.Reject(ssh.ConnectionFailed, "invalid payload")
+			continue
+		}
+
+		m.mu.Lock()
+		c := m.forwards[net.JoinHostPort(f.Addr, strconv.Itoa(int(f.Port)))]
+		if c == nil {
+			for addr, fc := range m.forwards {
+				if _, port, _ := net.SplitHostPort(addr); port == strconv.Itoa(int(f.Port)) {
+					c = fc
+					break
+				}
+			}
+		}
+		m.mu.Unlock()
+
+		if c == nil {
+			_ = ch.Reject(ssh.Prohibited, "no forward for address")
+			continue
+		}
+
+		go bridge(ch, c)
+	}
+}
+
+// openChannel opens the channel of a client on the server.
+func (m *master) openChannel(ch ssh.NewChannel) {
+	bridge(ch, m.remote)
+}
+
+// opener opens channels, the server connection or a client connection.
+type opener interface {
+	OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error)
+}
+
+// bridge opens the same channel as ch by to, and copies the data and requests of them both ways.
+func bridge(ch ssh.NewChannel, to opener) {
+	dst, dstReqs, err := to.OpenChannel(ch.ChannelType(), ch.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			_ = ch.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+		}
+
+		return
+	}
+
+	src, srcReqs, err := ch.Accept()
+	if err != nil {
+		_ = dst.Close()
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	// a side closed closes the other side, after the data and requests of it are copied.
+	go func() {
+		defer wg.Done()
+		pipe(src, dst, srcReqs)
+		_ = dst.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		pipe(dst, src, dstReqs)
+		_ = src.Close()
+	}()
+
+	wg.Wait()
+}
+
+// pipe copies the data, stderr and requests of channel from to the channel to, and closes the write of to at EOF.
+// It returns when the requests of from are done, after the channel is closed.
+func pipe(from, to ssh.Channel, reqs <-chan *ssh.Request) {
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(to, from)
+	}()
+
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(to.Stderr(), from.Stderr())
+	}()
+
+	go func() {
+		wg.Wait()
+		_ = to.CloseWrite()
+	}()
+
+	for req := range reqs {
+		ok, err := to.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			_ = req.Reply(ok && err == nil, nil)
+		}
+	}
+
+	// the request channel is closed after the data is received.
+	wg.Wait()
+}
@@ -0,0 +1,196 @@
+package control
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSockPath(t *testing.T) {
+	a := SockPath("/tmp/control", "a u@127.0.0.1:22")
+	b := SockPath("/tmp/control", "b u@127.0.0.1:22")
+
+	assert.Equal(t, a, SockPath("/tmp/control", "a u@127.0.0.1:22"), "same key same socket")
+	assert.NotEqual(t, a, b, "different key different socket")
+	assert.Equal(t, "/tmp/control", filepath.Dir(a), "in the directory")
+}
+
+func TestPrepareDir(t *testing.T) {
+	type TestData struct {
+		desc string
+		mode os.FileMode
+		err  bool
+	}
+
+	tds := []TestData{
+		{desc: "Private", mode: 0o700, err: false},
+		{desc: "Readable by others", mode: 0o755, err: false},
+		{desc: "Writable by group", mode: 0o770, err: true},
+		{desc: "Writable by others, like /tmp", mode: 0o1777, err: true},
+	}
+
+	for _, v := range tds {
+		dir := filepath.Join(t.TempDir(), "control")
+		require.Nil(t, os.Mkdir(dir, 0o700))
+		require.Nil(t, os.Chmod(dir, v.mode))
+
+		err := prepareDir(dir)
+		assert.Equal(t, v.err, err != nil, v.desc)
+	}
+
+	// created if not exists.
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	assert.Nil(t, prepareDir(dir))
+
+	info, err := os.Stat(dir)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+}
+
+func TestServe(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "c.sock")
+
+	remote := newTestRemote(t)
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(remote, "a", sock, 0) }()
+
+	var info *Info
+
+	require.Eventually(t, func() bool {
+		var err error
+		info, err = Check(sock)
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "a", info.Server)
+	assert.Equal(t, os.Getpid(), info.Pid)
+	assert.Equal(t, 0, info.Clients)
+
+	stat, err := os.Stat(sock)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0), stat.Mode().Perm()&0o077, "socket is private")
+
+	// the session is opened on the remote over the control socket.
+	client, err := Dial(sock)
+	require.Nil(t, err)
+
+	session, err := client.NewSession()
+	require.Nil(t, err)
+
+	out, err := session.Output("echo")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(out))
+
+	_ = client.Close()
+
+	assert.Nil(t, Exit(sock))
+
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("master not exited")
+	}
+
+	_, err = Check(sock)
+	assert.NotNil(t, err, "no master after exit")
+}
+
+func TestRegisterForward(t *testing.T) {
+	m := &master{forwards: map[string]*ssh.ServerConn{}}
+	c := &ssh.ServerConn{}
+
+	forward := func(typ, addr string, port uint32, reply []byte) {
+		payload := ssh.Marshal(tcpipForward{Addr: addr, Port: port})
+		m.registerForward(c, &ssh.Request{Type: typ, Payload: payload}, reply)
+	}
+
+	forward("tcpip-forward", "127.0.0.1", 8080, nil)
+	assert.Equal(t, c, m.forwards["127.0.0.1:8080"], "registered by the bind address")
+
+	forward("tcpip-forward", "0.0.0.0", 0, ssh.Marshal(struct{ Port uint32 }{9090}))
+	assert.Equal(t, c, m.forwards["0.0.0.0:9090"], "registered by the port allocated by the server")
+
+	forward("cancel-tcpip-forward", "127.0.0.1", 8080, nil)
+	_, ok := m.forwards["127.0.0.1:8080"]
+	assert.False(t, ok, "canceled")
+
+	forward("keepalive@openssh.com", "", 0, nil)
+	assert.Len(t, m.forwards, 1, "other requests are ignored")
+}
+
+// newTestRemote returns the client of an in-memory ssh server, which outputs hello for exec.
+func newTestRemote(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.Nil(t, err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// net.Pipe is not buffered, both sides of ssh write the version first.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+
+		go ssh.DiscardRequests(reqs)
+
+		for ch := range chans {
+			go serveTestSession(ch)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, "remote",
+		&ssh.ClientConfig{User: "u", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	require.Nil(t, err)
+
+	return ssh.NewClient(c, chans, reqs)
+}
+
+func serveTestSession(ch ssh.NewChannel) {
+	c, reqs, err := ch.Accept()
+	if err != nil {
+		return
+	}
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+		_, _ = c.Write([]byte("hello"))
+		_, _ = c.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPrivate returns an error if the directory of control sockets is not owned by the user,
+// or is writable by group or others, they could replace the socket.
+func checkPrivate(dir string, info os.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("control path %s is not owned by the user", dir)
+	}
+
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("control path %s is writable by group or others, `chmod go-w` it", dir)
+	}
+
+	return nil
+}
+
+// listenPrivate listens the unix socket sock created with 0600, no one else can connect it before chmod.
+func listenPrivate(sock string) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", sock)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// checkPrivate does nothing on windows, the directory is protected by the ACL of the user profile.
+func checkPrivate(string, os.FileInfo) error { return nil }
+
+// listenPrivate listens the unix socket sock.
+func listenPrivate(sock string) (net.Listener, error) { return net.Listen("unix", sock) }
@@ -0,0 +1,16 @@
+//go:build windows
+
+package forwards
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachedProcess is DETACHED_PROCESS process creation flag.
+const detachedProcess = 0x00000008
+
+// detach starts cmd without console, so it is not killed with the terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: detachedProcess, HideWindow: true}
+}
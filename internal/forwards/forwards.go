@@ -0,0 +1,305 @@
+// Package forwards keeps the registry of background port forwarding processes (bssh -f -N).
+//
+// Each background process has three files in Dir():
+//   - <id>.pid  ... process id
+//   - <id>.json ... Info
+//   - <id>.sock ... status socket, accepts `status` and `stop` line commands
+package forwards
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bingoohuang/bssh/common"
+	"github.com/bingoohuang/bssh/internal/tmpjson"
+	"github.com/bingoohuang/ngg/ss"
+)
+
+// EnvID is the environment variable that tells the re-executed process its background id.
+const EnvID = "BSSH_FORWARD_ID"
+
+const (
+	cmdStatus = "status"
+	cmdStop   = "stop"
+
+	// startTimeout is the wait time for the background process to connect and open its status socket.
+	startTimeout = 60 * time.Second
+	dialTimeout  = 1 * time.Second
+)
+
+// Info is the status of a background port forwarding process.
+type Info struct {
+	ID       string    `json:"id"`
+	Pid      int       `json:"pid"`
+	Server   string    `json:"server"`
+	Forwards []string  `json:"forwards"`
+	Started  time.Time `json:"started"`
+	Log      string    `json:"log"`
+}
+
+// Dir returns the registry directory.
+func Dir() string {
+	return ss.ExpandHome("~/.bssh.d/forwards")
+}
+
+func pidFile(id string) string  { return filepath.Join(Dir(), id+".pid") }
+func infoFile(id string) string { return filepath.Join(Dir(), id+".json") }
+func sockFile(id string) string { return filepath.Join(Dir(), id+".sock") }
+func logFile(id string) string  { return filepath.Join(Dir(), id+".log") }
+
+// Start re-executes the current program with args as a detached background process,
+// and waits until it is connected and serving its status socket.
+func Start(args []string) (*Info, error) {
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	id := common.NewSHA1Hash()[:8]
+	logPath := logFile(id)
+
+	out, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), EnvID+"="+id)
+	cmd.Stdout, cmd.Stderr = out, out
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	for deadline := time.Now().Add(startTimeout); time.Now().Before(deadline); {
+		if info, err := Status(id); err == nil {
+			return info, nil
+		}
+
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("background process exited: %s, see log %s", lastLine(logPath), logPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	return nil, fmt.Errorf("background process not ready in %s, see log %s", startTimeout, logPath)
+}
+
+// Serve registers the current process as background process info.ID,
+// and serves the status socket. stop is closed when `stop` is requested or the process is signaled.
+// cleanup removes the registry files.
+func Serve(info Info) (stop <-chan struct{}, cleanup func(), err error) {
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return nil, nil, err
+	}
+
+	info.Pid = os.Getpid()
+	info.Log = logFile(info.ID)
+
+	_ = os.Remove(sockFile(info.ID))
+	ln, err := net.Listen("unix", sockFile(info.ID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := os.WriteFile(pidFile(info.ID), []byte(strconv.Itoa(info.Pid)), 0o600); err != nil {
+		_ = ln.Close()
+		return nil, nil, err
+	}
+
+	if err := tmpjson.WriteJSONFile(infoFile(info.ID), info); err != nil {
+		_ = ln.Close()
+		return nil, nil, err
+	}
+
+	stopCh := make(chan struct{})
+	stopOnce := func() {
+		select {
+		case <-stopCh:
+		default:
+			close(stopCh)
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigCh:
+			stopOnce()
+		case <-stopCh:
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			if handle(conn, info) == cmdStop {
+				stopOnce()
+			}
+		}
+	}()
+
+	cleanup = func() {
+		signal.Stop(sigCh)
+		_ = ln.Close()
+		_ = os.Remove(sockFile(info.ID))
+		_ = os.Remove(pidFile(info.ID))
+		_ = os.Remove(infoFile(info.ID))
+	}
+
+	return stopCh, cleanup, nil
+}
+
+// handle serves one status socket request and returns the command.
+func handle(conn net.Conn, info Info) string {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	cmd := strings.TrimSpace(line)
+
+	switch cmd {
+	case cmdStatus:
+		_ = json.NewEncoder(conn).Encode(info)
+	case cmdStop:
+		_, _ = fmt.Fprintln(conn, "ok")
+	default:
+		_, _ = fmt.Fprintf(conn, "unknown command %q\n", cmd)
+	}
+
+	return cmd
+}
+
+// request sends cmd to the status socket of background process id, returns the response.
+func request(id, cmd string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", sockFile(id), dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(conn).ReadBytes('\n')
+}
+
+// Status returns the info of a running background process id.
+func Status(id string) (*Info, error) {
+	resp, err := request(id, cmdStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(resp, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// List returns the infos of running background processes, sorted by started time.
+// Registry files of dead processes are removed.
+func List() ([]Info, error) {
+	files, err := filepath.Glob(filepath.Join(Dir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), ".json")
+
+		info, err := Status(id)
+		if err != nil {
+			removeStale(id)
+			continue
+		}
+
+		infos = append(infos, *info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+
+	return infos, nil
+}
+
+// Stop stops background process id. If its status socket is dead, the process is killed by pid.
+func Stop(id string) error {
+	if _, err := request(id, cmdStop); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(pidFile(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("background forwarding %s not found", id)
+		}
+		return err
+	}
+
+	defer removeStale(id)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %w", pidFile(id), err)
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return p.Kill()
+}
+
+// lastLine returns the last non-empty line of the log, the reason of the exited background process.
+func lastLine(path string) string {
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func removeStale(id string) {
+	_ = os.Remove(sockFile(id))
+	_ = os.Remove(pidFile(id))
+	_ = os.Remove(infoFile(id))
+}
@@ -0,0 +1,96 @@
+package forwards
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain uses a temporary home for the registry, Dir() is cached by the first call.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "forwards")
+	if err != nil {
+		panic(err)
+	}
+
+	_ = os.Setenv("HOME", home)
+
+	code := m.Run()
+
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func TestRegistry(t *testing.T) {
+	started := time.Now().Truncate(time.Second)
+	info := Info{ID: "t1", Server: "web1", Forwards: []string{"L localhost:8080 => localhost:80"}, Started: started}
+
+	stop, cleanup, err := Serve(info)
+	require.Nil(t, err)
+
+	status, err := Status("t1")
+	require.Nil(t, err)
+	assert.Equal(t, os.Getpid(), status.Pid)
+	assert.Equal(t, logFile("t1"), status.Log)
+	assert.Equal(t, info.Forwards, status.Forwards)
+	assert.True(t, started.Equal(status.Started))
+
+	// the registry files of the dead process are removed by List.
+	require.Nil(t, os.WriteFile(infoFile("dead"), []byte("{}"), 0o600))
+	require.Nil(t, os.WriteFile(pidFile("dead"), []byte("0"), 0o600))
+
+	infos, err := List()
+	require.Nil(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "t1", infos[0].ID)
+	assert.NoFileExists(t, infoFile("dead"))
+	assert.NoFileExists(t, pidFile("dead"))
+
+	require.Nil(t, Stop("t1"))
+
+	select {
+	case <-stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+
+	cleanup()
+
+	infos, err = List()
+	require.Nil(t, err)
+	assert.Empty(t, infos)
+
+	assert.EqualError(t, Stop("t1"), "background forwarding t1 not found")
+}
+
+func TestStartExited(t *testing.T) {
+	// the test binary runs no test and exits.
+	_, err := Start([]string{"-test.run=^$"})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "background process exited: PASS, see log ")
+}
+
+func TestLastLine(t *testing.T) {
+	type TestData struct {
+		desc   string
+		log    string
+		expect string
+	}
+
+	tds := []TestData{
+		{desc: "Last line", log: "Select Server :web1\nError: connection refused\n\n", expect: "Error: connection refused"},
+		{desc: "One line", log: "connection refused", expect: "connection refused"},
+		{desc: "Empty", log: "", expect: ""},
+	}
+
+	for _, v := range tds {
+		path := filepath.Join(t.TempDir(), "log")
+		require.Nil(t, os.WriteFile(path, []byte(v.log), 0o600))
+
+		assert.Equal(t, v.expect, lastLine(path), v.desc)
+	}
+}
@@ -0,0 +1,162 @@
+package output
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Group is the servers that produced the same output.
+type Group struct {
+	Servers []string
+	Output  string
+}
+
+// GroupOutputs groups servers by the hash of output, like dshbak -c.
+// Groups are sorted by the first server name, servers in a group are sorted by name.
+func GroupOutputs(outputs map[string]string) []Group {
+	index := map[[sha256.Size]byte]int{}
+
+	var groups []Group
+
+	servers := make([]string, 0, len(outputs))
+	for server := range outputs {
+		servers = append(servers, server)
+	}
+
+	sort.Strings(servers)
+
+	for _, server := range servers {
+		out := outputs[server]
+		sum := sha256.Sum256([]byte(out))
+
+		if i, ok := index[sum]; ok {
+			groups[i].Servers = append(groups[i].Servers, server)
+			continue
+		}
+
+		index[sum] = len(groups)
+		groups = append(groups, Group{Servers: []string{server}, Output: out})
+	}
+
+	return groups
+}
+
+// PrintGroups prints each distinct output once, with the servers that produced it.
+func PrintGroups(w io.Writer, groups []Group) {
+	for _, g := range groups {
+		header := fmt.Sprintf("%s (%d)", strings.Join(g.Servers, ","), len(g.Servers))
+		line := strings.Repeat("-", len(header))
+
+		fmt.Fprintf(w, "%s\n%s\n%s\n", line, header, line)
+
+		fmt.Fprint(w, g.Output)
+		if g.Output != "" && !strings.HasSuffix(g.Output, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// Buffer is a goroutine safe bytes.Buffer, to buffer stdout and stderr of a server together.
+type Buffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends p to the buffer.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// String returns the buffered output.
+func (b *Buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// Baseline returns the group of the baseline server, or the group of most servers (majority) if server is empty.
+// The other groups are the deviations from the baseline.
+func Baseline(groups []Group, server string) (baseline Group, deviations []Group, err error) {
+	index := -1
+
+	for i, g := range groups {
+		if server == "" {
+			if index < 0 || len(g.Servers) > len(groups[index].Servers) {
+				index = i
+			}
+
+			continue
+		}
+
+		for _, s := range g.Servers {
+			if s == server {
+				index = i
+			}
+		}
+	}
+
+	if index < 0 {
+		return Group{}, nil, fmt.Errorf("no output of %q", server)
+	}
+
+	deviations = append(deviations, groups[:index]...)
+	deviations = append(deviations, groups[index+1:]...)
+
+	return groups[index], deviations, nil
+}
+
+// PrintDiffs prints the unified diff from the baseline to each deviation,
+// with the summary of servers identical to the baseline.
+func PrintDiffs(w io.Writer, baseline Group, deviations []Group) {
+	fmt.Fprintf(w, "identical : %s (%d)\n", strings.Join(baseline.Servers, ","), len(baseline.Servers))
+
+	var differ []string
+	for _, g := range deviations {
+		differ = append(differ, g.Servers...)
+	}
+
+	if len(differ) > 0 {
+		sort.Strings(differ)
+		fmt.Fprintf(w, "differ    : %s (%d)\n", strings.Join(differ, ","), len(differ))
+	}
+
+	for _, g := range deviations {
+		diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(baseline.Output),
+			B:        splitLines(g.Output),
+			FromFile: strings.Join(baseline.Servers, ","),
+			ToFile:   strings.Join(g.Servers, ","),
+			Context:  3,
+		})
+
+		fmt.Fprint(w, diff)
+	}
+}
+
+// splitLines splits s into lines ending with "\n", the last line without "\n" gets one.
+// difflib.SplitLines adds an empty line after the last "\n".
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+
+	return lines
+}
@@ -0,0 +1,114 @@
+package output_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bingoohuang/bssh/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupOutputs(t *testing.T) {
+	type TestData struct {
+		desc    string
+		outputs map[string]string
+		expect  []output.Group
+	}
+
+	tds := []TestData{
+		{desc: "No outputs", outputs: map[string]string{}, expect: nil},
+		{
+			desc:    "All same",
+			outputs: map[string]string{"b": "ok\n", "a": "ok\n", "c": "ok\n"},
+			expect:  []output.Group{{Servers: []string{"a", "b", "c"}, Output: "ok\n"}},
+		},
+		{
+			desc:    "Sorted by the first server",
+			outputs: map[string]string{"a": "x\n", "b": "y\n", "c": "x\n", "d": ""},
+			expect: []output.Group{
+				{Servers: []string{"a", "c"}, Output: "x\n"},
+				{Servers: []string{"b"}, Output: "y\n"},
+				{Servers: []string{"d"}, Output: ""},
+			},
+		},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, output.GroupOutputs(v.outputs), v.desc)
+	}
+}
+
+func TestPrintGroups(t *testing.T) {
+	var b bytes.Buffer
+
+	output.PrintGroups(&b, []output.Group{
+		{Servers: []string{"a", "c"}, Output: "x\n"},
+		{Servers: []string{"b"}, Output: "no newline"},
+		{Servers: []string{"d"}, Output: ""},
+	})
+
+	assert.Equal(t, "-------\na,c (2)\n-------\nx\n"+
+		"-----\nb (1)\n-----\nno newline\n"+
+		"-----\nd (1)\n-----\n", b.String())
+}
+
+func TestBaseline(t *testing.T) {
+	groups := []output.Group{
+		{Servers: []string{"a"}, Output: "x\n"},
+		{Servers: []string{"b", "c"}, Output: "y\n"},
+		{Servers: []string{"d"}, Output: "z\n"},
+	}
+
+	type TestData struct {
+		desc       string
+		groups     []output.Group
+		server     string
+		baseline   output.Group
+		deviations []output.Group
+		err        bool
+	}
+
+	tds := []TestData{
+		{desc: "Majority", groups: groups, baseline: groups[1], deviations: []output.Group{groups[0], groups[2]}},
+		{desc: "Server", groups: groups, server: "d", baseline: groups[2], deviations: []output.Group{groups[0], groups[1]}},
+		{desc: "Server in group", groups: groups, server: "c", baseline: groups[1], deviations: []output.Group{groups[0], groups[2]}},
+		{desc: "Tie is the first group", groups: groups[:1:1], baseline: groups[0]},
+		{
+			desc:     "Tie of groups",
+			groups:   []output.Group{groups[2], groups[0]},
+			baseline: groups[2], deviations: []output.Group{groups[0]},
+		},
+		{desc: "No output of server", groups: groups, server: "e", err: true},
+		{desc: "No groups", groups: nil, err: true},
+	}
+
+	for _, v := range tds {
+		baseline, deviations, err := output.Baseline(v.groups, v.server)
+		assert.Equal(t, v.err, err != nil, v.desc)
+		assert.Equal(t, v.baseline, baseline, v.desc)
+		assert.Equal(t, v.deviations, deviations, v.desc)
+	}
+}
+
+func TestPrintDiffs(t *testing.T) {
+	var b bytes.Buffer
+
+	output.PrintDiffs(&b, output.Group{Servers: []string{"a", "c"}, Output: "1\n2\n3\n"}, []output.Group{
+		{Servers: []string{"d"}, Output: "1\n2\n4\n"},
+		{Servers: []string{"b"}, Output: "1\n3\n"},
+	})
+
+	assert.Equal(t, "identical : a,c (2)\n"+
+		"differ    : b,d (2)\n"+
+		"--- a,c\n+++ d\n@@ -1,3 +1,3 @@\n 1\n 2\n-3\n+4\n"+
+		"--- a,c\n+++ b\n@@ -1,3 +1,2 @@\n 1\n-2\n 3\n", b.String())
+
+	b.Reset()
+	output.PrintDiffs(&b, output.Group{Servers: []string{"a"}, Output: "1\n2"}, []output.Group{{Servers: []string{"b"}}})
+	assert.Equal(t, "identical : a (1)\ndiffer    : b (1)\n--- a\n+++ b\n@@ -1,2 +0,0 @@\n-1\n-2\n", b.String(),
+		"no newline at the end, empty output")
+
+	b.Reset()
+	output.PrintDiffs(&b, output.Group{Servers: []string{"a", "b"}, Output: "1\n"}, nil)
+	assert.Equal(t, "identical : a,b (2)\n", b.String(), "no deviations")
+}
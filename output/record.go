@@ -0,0 +1,127 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output format of command execute mode (--output option).
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Record type.
+const (
+	// RecordLine is a line of the stdout/stderr of a server.
+	RecordLine = "line"
+	// RecordResult is the final record of a server, with exit status and duration.
+	RecordResult = "result"
+)
+
+// NormalizeFormat returns the output format, error if unknown.
+func NormalizeFormat(format string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON, FormatNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q, use json|ndjson|text", format)
+	}
+}
+
+// Record is a structured output of command execute mode.
+type Record struct {
+	Type   string    `json:"type"`
+	Server string    `json:"server"`
+	Addr   string    `json:"addr"`
+	Time   time.Time `json:"time"`
+
+	// line record
+	Stream string `json:"stream,omitempty"` // stdout or stderr
+	Line   string `json:"line,omitempty"`
+
+	// result record, status is ok, failed, unreachable, canceled or timeout.
+	Status     string `json:"status,omitempty"`
+	ExitStatus *int   `json:"exit_status,omitempty"`
+	Signal     string `json:"signal,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RecordPrinter prints Record as json or ndjson.
+// ndjson prints a record per line as it comes, json prints an array of all records at Flush.
+type RecordPrinter struct {
+	Format string
+
+	mu      sync.Mutex
+	w       io.Writer
+	records []Record
+}
+
+// NewRecordPrinter returns RecordPrinter that prints to w.
+func NewRecordPrinter(format string, w io.Writer) *RecordPrinter {
+	return &RecordPrinter{Format: format, w: w, records: []Record{}}
+}
+
+// Print prints or buffers the record.
+func (p *RecordPrinter) Print(rec Record) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Format == FormatJSON {
+		p.records = append(p.records, rec)
+		return
+	}
+
+	data, _ := json.Marshal(rec)
+	fmt.Fprintf(p.w, "%s\n", data)
+}
+
+// Flush prints the buffered records in json format.
+func (p *RecordPrinter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Format != FormatJSON {
+		return
+	}
+
+	data, _ := json.MarshalIndent(p.records, "", "  ")
+	fmt.Fprintf(p.w, "%s\n", data)
+	p.records = p.records[:0]
+}
+
+// NewRecordWriter return io.WriteCloser that prints each line as a Record of stream.
+// done is closed when the writer is closed and all lines are printed.
+func (o *Output) NewRecordWriter(p *RecordPrinter, stream string) (writer *io.PipeWriter, done <-chan struct{}) {
+	r, w := io.Pipe()
+	ch := make(chan struct{})
+
+	go func() {
+		defer close(ch)
+
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+		for sc.Scan() {
+			p.Print(Record{Type: RecordLine, Server: o.Server, Addr: o.Conf.Addr, Stream: stream, Line: sc.Text()})
+		}
+
+		// drain the rest, not to block the writer.
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	return w, ch
+}
@@ -0,0 +1,96 @@
+package output_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/bssh/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordPrinter(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	zero := 0
+	records := []output.Record{
+		{Type: output.RecordLine, Server: "web1", Addr: "10.0.0.1", Time: at, Stream: "stdout", Line: "hello"},
+		{Type: output.RecordResult, Server: "web1", Addr: "10.0.0.1", Time: at, Status: "ok", ExitStatus: &zero, Duration: "1s"},
+	}
+
+	ndjson := `{"type":"line","server":"web1","addr":"10.0.0.1","time":"2024-01-02T03:04:05Z","stream":"stdout","line":"hello"}` + "\n" +
+		`{"type":"result","server":"web1","addr":"10.0.0.1","time":"2024-01-02T03:04:05Z","status":"ok","exit_status":0,"duration":"1s"}` + "\n"
+
+	type TestData struct {
+		desc    string
+		format  string
+		printed string
+		expect  string
+	}
+
+	tds := []TestData{
+		{
+			desc:    "ndjson prints a record per line as it comes",
+			format:  output.FormatNDJSON,
+			printed: ndjson,
+			expect:  ndjson,
+		},
+		{
+			desc:    "json prints an array at Flush",
+			format:  output.FormatJSON,
+			printed: "",
+			expect: `[
+  {
+    "type": "line",
+    "server": "web1",
+    "addr": "10.0.0.1",
+    "time": "2024-01-02T03:04:05Z",
+    "stream": "stdout",
+    "line": "hello"
+  },
+  {
+    "type": "result",
+    "server": "web1",
+    "addr": "10.0.0.1",
+    "time": "2024-01-02T03:04:05Z",
+    "status": "ok",
+    "exit_status": 0,
+    "duration": "1s"
+  }
+]
+`,
+		},
+	}
+
+	for _, v := range tds {
+		var buf bytes.Buffer
+
+		p := output.NewRecordPrinter(v.format, &buf)
+		for _, rec := range records {
+			p.Print(rec)
+		}
+
+		assert.Equal(t, v.printed, buf.String(), v.desc)
+
+		p.Flush()
+		assert.Equal(t, v.expect, buf.String(), v.desc)
+	}
+}
+
+func TestRecordPrinterEmptyJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	output.NewRecordPrinter(output.FormatJSON, &buf).Flush()
+	assert.Equal(t, "[]\n", buf.String(), "no records is an empty array, not null")
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	for in, expect := range map[string]string{"": "text", "TEXT": "text", " json ": "json", "ndjson": "ndjson"} {
+		f, err := output.NormalizeFormat(in)
+		require.Nil(t, err, in)
+		assert.Equal(t, expect, f, in)
+	}
+
+	_, err := output.NormalizeFormat("yaml")
+	assert.EqualError(t, err, `unknown output format "yaml", use json|ndjson|text`)
+}
@@ -0,0 +1,739 @@
+package scp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bingoohuang/bssh/common"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// directSSHOptions are the options of ssh on the source server to the targets, without prompts.
+// The host keys are checked by the known_hosts file of directCopy and the source's own.
+const directSSHOptions = "-o BatchMode=yes -o StrictHostKeyChecking=yes -o CheckHostIP=no " +
+	"-o LogLevel=ERROR -o ConnectTimeout=5"
+
+// sourceKnownHosts is the known_hosts file of the user on the source server.
+const sourceKnownHosts = "~/.ssh/known_hosts"
+
+// authorizedKeys is the authorized_keys file of the targets, relative to the home directory.
+const authorizedKeys = ".ssh/authorized_keys"
+
+// directKeyExpiry is how long the temporary key is accepted by the targets, the copy logs in again for each path.
+const directKeyExpiry = time.Hour
+
+// directCopy copies the files from the source server to the targets by tar and ssh on the source server
+// (--direct option), so the bytes are not relayed through local.
+// The source logs in the targets by a temporary key, which is removed from both sides by close, or by SIGINT and SIGTERM.
+type directCopy struct {
+	cp     *Scp
+	source *Connect
+
+	name       string // `bssh-direct-<id>`
+	key        string // private key file on the source, relative to the home directory
+	knownHosts string // known_hosts file of the host keys of the targets verified by bssh, on the source
+	pubKey     string // public key in authorized_keys, ends with the comment name
+	from       string // `from=` patterns of the addresses of the source
+
+	// authorized are the targets with the public key, targets are reachable from the source.
+	authorized []*Connect
+	targets    []*Connect
+
+	closeOnce  sync.Once
+	stopSignal func()
+}
+
+// keyMu guards authorized_keys of the targets, they may share the home directory.
+var keyMu sync.Mutex
+
+// newDirectCopy puts the temporary key on the source and targets, and checks the targets are reachable from the source.
+// It returns the targets to relay through local, all targets if the key is not available.
+func (cp *Scp) newDirectCopy(source *Connect, targets []*Connect) (*directCopy, []*Connect) {
+	source.Output.Create(source.Server)
+
+	d, err := newDirectKey(cp, source, targets)
+	if err != nil {
+		fmt.Fprintf(source.Output.NewWriter(), "direct: temporary key error %v, relay through local\n", err)
+		return nil, targets
+	}
+
+	d.stopSignal = d.closeOnSignal()
+
+	var (
+		relay []*Connect
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+	)
+
+	for _, t := range targets {
+		tc := t
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			tc.Output.Create(tc.Server)
+
+			err := d.check(tc)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				fmt.Fprintf(tc.Output.NewWriter(), "direct: %s is unreachable from %s (%v), relay through local\n",
+					tc.Server, source.Server, err)
+
+				relay = append(relay, tc)
+
+				return
+			}
+
+			d.targets = append(d.targets, tc)
+		}()
+	}
+
+	wg.Wait()
+
+	return d, relay
+}
+
+// newDirectKey generates the temporary key, and puts the private key on the source,
+// with the known_hosts of the host keys of the targets.
+func newDirectKey(cp *Scp, source *Connect, targets []*Connect) (*directCopy, error) {
+	if source.Client == nil {
+		return nil, fmt.Errorf("no ssh client of %s", source.Server)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	// the targets accept the key only from the addresses of the source.
+	addrs, err := runOutput(source.Client, `echo "$SSH_CONNECTION"; hostname -I 2>/dev/null || true`)
+	if err != nil {
+		return nil, err
+	}
+
+	from := sourcePatterns(addrs)
+	if from == "" {
+		return nil, fmt.Errorf("no address of %s", source.Server)
+	}
+
+	name := "bssh-direct-" + hex.EncodeToString(id)
+	d := &directCopy{
+		cp: cp, source: source, name: name, key: "." + name, knownHosts: "." + name + ".known_hosts",
+		pubKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + name, from: from,
+	}
+
+	if err := writePrivate(source, d.key, pem.EncodeToMemory(block)); err != nil {
+		return nil, err
+	}
+
+	if err := writePrivate(source, d.knownHosts, []byte(d.knownHostsLines(targets))); err != nil {
+		_ = source.Connect.Remove(d.key)
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// sourcePatterns returns the `from=` patterns of the addresses of the source, by the output of
+// `echo "$SSH_CONNECTION"; hostname -I` on the source: the address bssh connected to and the addresses
+// of the interfaces, with the loopback addresses for the targets on the same host.
+func sourcePatterns(out string) string {
+	lines := strings.SplitN(out, "\n", 2)
+
+	var addrs []string
+
+	if fields := strings.Fields(lines[0]); len(fields) >= 3 {
+		addrs = append(addrs, fields[2])
+	}
+
+	if len(lines) > 1 {
+		addrs = append(addrs, strings.Fields(lines[1])...)
+	}
+
+	if len(addrs) == 0 {
+		return ""
+	}
+
+	var patterns []string
+
+	seen := map[string]bool{}
+
+	for _, a := range append(addrs, "127.0.0.1", "::1") {
+		if ip := net.ParseIP(a); ip != nil && !seen[ip.String()] {
+			seen[ip.String()] = true
+			patterns = append(patterns, ip.String())
+		}
+	}
+
+	return strings.Join(patterns, ",")
+}
+
+// authorizedLine returns the line of the temporary key in authorized_keys of the target: no pty or forwarding,
+// only from the source, and expired after directKeyExpiry, by the time zone of the target (`date +%z`).
+func (d *directCopy) authorizedLine(zone string, now time.Time) (string, error) {
+	z, err := time.Parse("-0700", zone)
+	if err != nil {
+		return "", fmt.Errorf("time zone %q: %w", zone, err)
+	}
+
+	expiry := now.Add(directKeyExpiry).In(z.Location()).Format("200601021504")
+
+	return fmt.Sprintf(`restrict,from="%s",expiry-time="%s" %s`, d.from, expiry, d.pubKey), nil
+}
+
+// runOutput runs cmd on the server and returns the output, with the error output in the error.
+func runOutput(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	defer session.Close()
+
+	var stderr bytes.Buffer
+
+	session.Stderr = &stderr
+
+	out, err := session.Output(cmd)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// closeOnSignal removes the temporary key by SIGINT or SIGTERM and exits, not to leave it authorized.
+// The returned function stops it.
+func (d *directCopy) closeOnSignal() func() {
+	sigC := make(chan os.Signal, 1)
+	stopC := make(chan struct{})
+
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigC:
+			d.close()
+			os.Exit(1)
+		case <-stopC:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigC)
+		close(stopC)
+	}
+}
+
+// knownHostsLines returns the known_hosts lines of the host keys of the targets, as ssh on the source names them.
+// The targets connected by the control master have no host key, they are checked by the source's known_hosts.
+func (d *directCopy) knownHostsLines(targets []*Connect) string {
+	var b strings.Builder
+
+	for _, tc := range targets {
+		if tc.HostKey == nil {
+			continue
+		}
+
+		config := d.cp.Config.Server[tc.Server]
+
+		port := config.Port
+		if port == "" {
+			port = "22"
+		}
+
+		b.WriteString(knownhosts.Line([]string{net.JoinHostPort(config.Addr, port)}, tc.HostKey) + "\n")
+	}
+
+	return b.String()
+}
+
+// writePrivate writes data to the new file path on the server, created with mode 0600 by umask.
+// It fails if path exists.
+func writePrivate(c *Connect, path string, data []byte) error {
+	session, err := c.Client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	session.Stdin = bytes.NewReader(data)
+
+	if out, err := session.CombinedOutput("umask 077 && set -C && cat > " + shellQuote(path)); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// check authorizes the temporary key on the target, and logs in the target from the source by ssh.
+func (d *directCopy) check(tc *Connect) error {
+	if err := d.authorize(tc); err != nil {
+		return err
+	}
+
+	session, err := d.source.Client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	if out, err := session.CombinedOutput(d.sshCommand(tc, "true")); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// sshCommand returns the ssh command line on the source to run cmd on the target.
+func (d *directCopy) sshCommand(tc *Connect, cmd string) string {
+	config := d.cp.Config.Server[tc.Server]
+
+	port := ""
+	if config.Port != "" {
+		port = " -p " + shellQuote(config.Port)
+	}
+
+	knownHosts := shellQuote("UserKnownHostsFile=" + d.knownHosts + " " + sourceKnownHosts)
+
+	return fmt.Sprintf("ssh -i %s %s -o %s%s %s %s", shellQuote(d.key), directSSHOptions, knownHosts, port,
+		shellQuote(config.User+"@"+config.Addr), shellQuote(cmd))
+}
+
+// authorize appends the temporary public key to authorized_keys of the target.
+func (d *directCopy) authorize(tc *Connect) error {
+	zone, err := runOutput(tc.Client, "date +%z")
+	if err != nil {
+		return err
+	}
+
+	pubLine, err := d.authorizedLine(strings.TrimSpace(zone), time.Now())
+	if err != nil {
+		return err
+	}
+
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	ftp := tc.Connect
+
+	// keep the permission of existing ~/.ssh.
+	dir := filepath.Dir(authorizedKeys)
+	if _, err := ftp.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := ftp.Mkdir(dir); err != nil {
+			return err
+		}
+
+		if err := ftp.Chmod(dir, 0o700); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	f, err := ftp.OpenFile(authorizedKeys, os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// keep the permission of existing authorized_keys, and the last line without newline.
+	line := pubLine + "\n"
+	last := make([]byte, 1)
+	size := stat.Size()
+
+	if size == 0 {
+		_ = f.Chmod(0o600)
+	} else if _, err := f.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+		line = "\n" + line
+	}
+
+	d.authorized = append(d.authorized, tc)
+
+	// write at the end, not all sftp servers support O_APPEND.
+	_, err = f.WriteAt([]byte(line), size)
+
+	return err
+}
+
+// close removes the temporary key from the targets and the source, once.
+func (d *directCopy) close() {
+	d.closeOnce.Do(d.remove)
+}
+
+func (d *directCopy) remove() {
+	if d.stopSignal != nil {
+		d.stopSignal()
+	}
+
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	for _, tc := range d.authorized {
+		if err := removeLines(tc, authorizedKeys, " "+d.name, authorizedKeys+"."+d.name); err != nil {
+			fmt.Fprintf(tc.Output.NewWriter(), "direct: WARNING the temporary key %s is left in ~/%s of %s, "+
+				"remove it by hand: %v\n", d.name, authorizedKeys, tc.Server, err)
+		}
+	}
+
+	for _, f := range []string{d.key, d.knownHosts} {
+		if err := d.source.Connect.Remove(f); err != nil {
+			fmt.Fprintf(d.source.Output.NewWriter(), "direct: remove the temporary file %s error %v\n", f, err)
+		}
+	}
+}
+
+// removeLines removes the lines ending with suffix from the remote file.
+// The file is replaced by the temporary file tmp with the same permission, not to be truncated on error.
+// A symlinked file is replaced at the link target, and the file is rewritten in place
+// if the server has no posix-rename extension.
+func removeLines(tc *Connect, path, suffix, tmp string) error {
+	ftp := tc.Connect
+
+	path, err := resolveLink(ftp, path)
+	if err != nil {
+		return err
+	}
+
+	tmp = filepath.Join(filepath.Dir(path), filepath.Base(tmp))
+
+	f, err := ftp.Open(path)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.HasSuffix(l, suffix) {
+			lines = append(lines, l)
+		}
+	}
+
+	content := []byte(strings.Join(lines, "\n"))
+
+	f, err = ftp.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	if err = f.Chmod(stat.Mode().Perm()); err == nil {
+		_, err = f.Write(content)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = ftp.PosixRename(tmp, path)
+	}
+
+	if err != nil {
+		_ = ftp.Remove(tmp)
+		return rewriteFile(ftp, path, content)
+	}
+
+	return nil
+}
+
+// resolveLink returns the path of the file linked by the symlinks from path, or path if it is not a symlink.
+func resolveLink(ftp *sftp.Client, path string) (string, error) {
+	for i := 0; i < 8; i++ {
+		stat, err := ftp.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		link, err := ftp.ReadLink(path)
+		if err != nil {
+			return "", err
+		}
+
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+
+		path = link
+	}
+
+	return "", fmt.Errorf("too many links of %s", path)
+}
+
+// rewriteFile truncates the remote file and writes data.
+func rewriteFile(ftp *sftp.Client, path string, data []byte) error {
+	f, err := ftp.OpenFile(path, os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// pushPath copies the path on the source to the same path on the targets, in parallel.
+// It returns the targets failed to copy, to relay through local.
+func (d *directCopy) pushPath(path string) (failed []*Connect) {
+	base := filepath.Dir(path)
+	names, sizes, total := d.walk(path)
+
+	// the checksum of each source file is computed once for all targets.
+	sums := map[string]func() (string, error){}
+
+	for _, name := range names {
+		p := filepath.Join(base, name)
+
+		var (
+			once sync.Once
+			sum  string
+			err  error
+		)
+
+		sums[p] = func() (string, error) {
+			once.Do(func() { sum, err = remoteSum(d.source, p) })
+			return sum, err
+		}
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, t := range d.targets {
+		tc := t
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ow := tc.Output.NewWriter()
+
+			if err := d.copy(tc, base, names, sizes, total, path); err != nil {
+				fmt.Fprintf(ow, "direct: copy %s from %s error %v, relay through local\n", path, d.source.Server, err)
+
+				mu.Lock()
+				failed = append(failed, tc)
+				mu.Unlock()
+
+				return
+			}
+
+			if !d.cp.Verify {
+				return
+			}
+
+			for _, name := range names {
+				if p := filepath.Join(base, name); sizes[name] >= 0 {
+					d.cp.verify(ow, tc.Server, p, sums[p], func() (string, error) { return remoteSum(tc, p) })
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failed
+}
+
+// walk returns the names of the files under path (relative to the parent of path) selected like relay,
+// and the size of the files (-1 for directories) and the total size.
+func (d *directCopy) walk(path string) (names []string, sizes map[string]int64, total int64) {
+	ftp := d.source.Connect
+	base := filepath.Dir(path)
+	sizes = map[string]int64{}
+
+	walker := ftp.Walk(path)
+	matcher := d.cp.Filter.Matcher(path, sftpReadFile(ftp))
+	fow := d.source.Output.NewWriter()
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			fmt.Fprintf(fow, "Error: %v\n", err)
+			continue
+		}
+
+		p := walker.Path()
+		if common.IsHidden(path, p) {
+			continue // ignore hidden files.
+		}
+
+		stat := walker.Stat()
+		if skipExcluded(walker.SkipDir, matcher, p, stat) {
+			continue
+		}
+
+		name, _ := filepath.Rel(base, p)
+		names = append(names, name)
+
+		if stat.IsDir() {
+			sizes[name] = -1
+		} else {
+			sizes[name] = stat.Size()
+			total += stat.Size()
+		}
+	}
+
+	return names, sizes, total
+}
+
+// copy runs tar on the source piped by ssh to tar on the target, the progress is by the files extracted on the target.
+func (d *directCopy) copy(tc *Connect, base string, names []string, sizes map[string]int64, total int64,
+	path string,
+) error {
+	session, err := d.source.Client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// the file list is NUL separated, --no-recursion to keep the excluded files.
+	session.Stdin = strings.NewReader(strings.Join(names, "\x00") + "\x00")
+
+	var stderr bytes.Buffer
+
+	session.Stderr = &stderr
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	extract := fmt.Sprintf("mkdir -p %s && tar -C %s -xpvf -", shellQuote(base), shellQuote(base))
+	cmd := fmt.Sprintf("tar -C %s --null --no-recursion -T - -cf - | %s", shellQuote(base), d.sshCommand(tc, extract))
+
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	d.cp.ProgressWG.Add(1)
+	_ = tc.Output.ProgressPrinter(total, &fileProgressReader{sc: bufio.NewScanner(stdout), sizes: sizes}, path)
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := session.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// fileProgressReader reads the names of the files extracted by `tar -v`, as the bytes of their sizes,
+// for the progress bar of Output.ProgressPrinter. The contents of the read bytes are meaningless.
+type fileProgressReader struct {
+	sc      *bufio.Scanner
+	sizes   map[string]int64
+	pending int64
+}
+
+func (r *fileProgressReader) Read(b []byte) (int, error) {
+	for r.pending <= 0 {
+		if !r.sc.Scan() {
+			if err := r.sc.Err(); err != nil {
+				return 0, err
+			}
+
+			return 0, io.EOF
+		}
+
+		r.pending = r.sizes[strings.TrimSuffix(r.sc.Text(), "/")]
+	}
+
+	n := int64(len(b))
+	if n > r.pending {
+		n = r.pending
+	}
+
+	r.pending -= n
+
+	return int(n), nil
+}
+
+// shellQuote quotes s by single quotes for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
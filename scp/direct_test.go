@@ -0,0 +1,135 @@
+package scp
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFileProgressReader(t *testing.T) {
+	type TestData struct {
+		desc   string
+		lines  string
+		sizes  map[string]int64
+		expect int64
+	}
+
+	tds := []TestData{
+		{desc: "Files", lines: "d/\nd/a\nd/b\n", sizes: map[string]int64{"d": -1, "d/a": 3, "d/b": 5000}, expect: 5003},
+		{desc: "Empty file", lines: "d/e\nd/a\n", sizes: map[string]int64{"d/e": 0, "d/a": 3}, expect: 3},
+		{desc: "Unknown name", lines: "d/x\nd/a\n", sizes: map[string]int64{"d/a": 3}, expect: 3},
+		{desc: "No files", lines: "", sizes: map[string]int64{}, expect: 0},
+	}
+
+	for _, v := range tds {
+		r := &fileProgressReader{sc: bufio.NewScanner(strings.NewReader(v.lines)), sizes: v.sizes}
+
+		n, err := io.CopyBuffer(io.Discard, r, make([]byte, 1024))
+		assert.Nil(t, err, v.desc)
+		assert.Equal(t, v.expect, n, v.desc)
+	}
+}
+
+func TestDirectSSHCommand(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	require.Nil(t, err)
+
+	d := &directCopy{
+		cp: &Scp{Config: conf.Config{Server: map[string]conf.ServerConfig{
+			"a": {Addr: "10.0.0.1", User: "u"},
+			"b": {Addr: "10.0.0.2", Port: "2222", User: "it's"},
+			"c": {Addr: "10.0.0.3", User: "u"},
+		}}},
+		key: ".bssh-direct-1", knownHosts: ".bssh-direct-1.known_hosts",
+	}
+
+	lines := d.knownHostsLines([]*Connect{{Server: "a", HostKey: key}, {Server: "b", HostKey: key}, {Server: "c"}})
+	keyText := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	assert.Equal(t, "10.0.0.1 "+keyText+"\n[10.0.0.2]:2222 "+keyText+"\n", lines,
+		"pinned by the names of ssh, no line without host key")
+
+	assert.Equal(t, `ssh -i '.bssh-direct-1' `+directSSHOptions+
+		` -o 'UserKnownHostsFile=.bssh-direct-1.known_hosts ~/.ssh/known_hosts' -p '2222' 'it'\''s@10.0.0.2' 'true'`,
+		d.sshCommand(&Connect{Server: "b"}, "true"))
+}
+
+func TestSourcePatterns(t *testing.T) {
+	type TestData struct {
+		desc   string
+		out    string
+		expect string
+	}
+
+	tds := []TestData{
+		{desc: "Interfaces", out: "10.0.0.9 50000 10.0.0.1 22\n10.0.0.1 192.168.1.5 fe80::1 \n",
+			expect: "10.0.0.1,192.168.1.5,fe80::1,127.0.0.1,::1"},
+		{desc: "No hostname -I", out: "10.0.0.9 50000 10.0.0.1 22\n", expect: "10.0.0.1,127.0.0.1,::1"},
+		{desc: "No address", out: "\n", expect: ""},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, sourcePatterns(v.out), v.desc)
+	}
+}
+
+func TestAuthorizedLine(t *testing.T) {
+	d := &directCopy{pubKey: "ssh-ed25519 AAAA bssh-direct-1", from: "10.0.0.1,127.0.0.1"}
+	now := time.Date(2024, 1, 2, 23, 30, 0, 0, time.UTC)
+
+	line, err := d.authorizedLine("+0800", now)
+	require.Nil(t, err)
+	assert.Equal(t, `restrict,from="10.0.0.1,127.0.0.1",expiry-time="202401030830" ssh-ed25519 AAAA bssh-direct-1`, line,
+		"expired in an hour by the time zone of the target")
+
+	_, err = d.authorizedLine("JST", now)
+	assert.NotNil(t, err)
+}
+
+func TestRemoveLines(t *testing.T) {
+	client := newSyncClient(t)
+	dir := t.TempDir()
+
+	keys := "ssh-ed25519 AAAA user\nrestrict ssh-ed25519 BBBB bssh-direct-1\nssh-rsa CCCC other"
+
+	file := filepath.Join(dir, "authorized_keys")
+	require.Nil(t, os.WriteFile(file, []byte(keys), 0o640))
+	require.Nil(t, removeLines(client, file, " bssh-direct-1", file+".bssh-direct-1"))
+
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAA user\nssh-rsa CCCC other", string(data))
+
+	stat, err := os.Stat(file)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o640), stat.Mode().Perm(), "keep the permission")
+	assert.NoFileExists(t, file+".bssh-direct-1")
+
+	// the symlink is kept, the link target is replaced.
+	target := filepath.Join(dir, "keys")
+	link := filepath.Join(dir, "link")
+	require.Nil(t, os.WriteFile(target, []byte(keys), 0o600))
+	require.Nil(t, os.Symlink("keys", link))
+	require.Nil(t, removeLines(client, link, " bssh-direct-1", link+".bssh-direct-1"))
+
+	lstat, err := os.Lstat(link)
+	require.Nil(t, err)
+	assert.NotZero(t, lstat.Mode()&os.ModeSymlink, "symlink")
+
+	data, err = os.ReadFile(target)
+	require.Nil(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAA user\nssh-rsa CCCC other", string(data))
+}
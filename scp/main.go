@@ -46,6 +46,34 @@ type Scp struct {
 	// progress bar
 	Progress   *mpb.Progress
 	ProgressWG *sync.WaitGroup
+
+	// Resume continues the transfer from the size of the destination file (--resume option).
+	// Verify compares the sha256 of the source and destination files after the transfer (--verify option).
+	Resume bool
+	Verify bool
+
+	// Sync copies only the changed files by size and mtime, keeping them (--sync option).
+	// Checksum compares the sha256 instead of mtime (--checksum option), Delete deletes the files
+	// not in the source (--delete option), DryRun prints the plan only (--dry-run option).
+	Sync     bool
+	Checksum bool
+	Delete   bool
+	DryRun   bool
+
+	// Direct copies the files from the source server to the targets directly in remote to remote copy,
+	// relaying through local only for the targets unreachable from the source (--direct option).
+	Direct bool
+
+	// Filter selects the files to copy by --exclude, --include, --exclude-from and --gitignore options,
+	// nil for all files.
+	Filter *common.PathFilter
+
+	// ExitCode is 1 if any file is not verified, set after Start().
+	ExitCode int
+
+	// verifyFailed is the `server:path` of the files not verified, verifyMu guards it.
+	verifyFailed []string
+	verifyMu     sync.Mutex
 }
 
 // Info ...
@@ -68,6 +96,12 @@ type Connect struct {
 	// ssh connect
 	Connect *sftp.Client
 
+	// ssh client, to run the checksum command on the server
+	Client *ssh.Client
+
+	// HostKey is the host key of the server, nil if connected by the control master.
+	HostKey ssh.PublicKey
+
 	// Output
 	Output *output.Output
 }
@@ -104,6 +138,8 @@ func (cp *Scp) Start(confpath string) {
 	case !cp.From.IsRemote && cp.To.IsRemote:
 		cp.push()
 	}
+
+	cp.printVerifyFailed()
 }
 
 // push data from local to remote machine.
@@ -125,9 +161,9 @@ func (cp *Scp) push() {
 	pathset := make([]PathSet, len(cp.From.Path))
 
 	for i, p := range cp.From.Path {
-		data, err := common.WalkDir(p)
+		data, err := common.WalkDirFilter(p, cp.Filter)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "common.WalkDir error %v\n", err)
+			fmt.Fprintf(os.Stderr, "common.WalkDirFilter error %v\n", err)
 			continue
 		}
 
@@ -158,19 +194,25 @@ func pushByClient(exit chan bool, client *Connect, pathset []PathSet, cp *Scp) {
 
 	client.Output.Create(client.Server)
 	ow := client.Output.NewWriter()
-	ftp := client.Connect
+
+	if cp.Sync {
+		cp.syncPush(client, ow, pathset)
+		return
+	}
 
 	// push path
 	for _, p := range pathset {
 		for _, path := range p.PathSlice {
-			if err := cp.pushPath(ftp, ow, client.Output, p.Base, path); err != nil {
+			if err := cp.pushPath(client, ow, p.Base, path); err != nil {
 				fmt.Fprintf(os.Stderr, "cp.pushPath error %v\n", err)
 			}
 		}
 	}
 }
 
-func (cp *Scp) pushPath(ftp *sftp.Client, ow io.Writer, output *output.Output, base, path string) (err error) {
+func (cp *Scp) pushPath(client *Connect, ow io.Writer, base, path string) (err error) {
+	ftp := client.Connect
+
 	// get rel path
 	relpath, _ := filepath.Rel(base, path)
 	rpath := filepath.Join(cp.To.Path[0], relpath)
@@ -195,17 +237,24 @@ func (cp *Scp) pushPath(ftp *sftp.Client, ow io.Writer, output *output.Output, b
 		lstat, _ := os.Lstat(path)
 		size := lstat.Size()
 
-		if err := cp.pushFile(lf, ftp, output, rpath, size); err != nil {
+		if err := cp.pushFile(lf, ftp, client.Output, rpath, size); err != nil {
 			fmt.Fprintf(ow, "cp.pushFile %s->%s error %v\n", path, rpath, err)
 			return err
 		}
+
+		if cp.Verify {
+			cp.verify(ow, client.Server, rpath,
+				func() (string, error) { return localSum(path) },
+				func() (string, error) { return remoteSum(client, rpath) })
+		}
 	}
 
 	return ftp.Chmod(rpath, fInfo.Mode())
 }
 
 // pushfile put file to path.
-func (cp *Scp) pushFile(lf io.Reader, ftp *sftp.Client, output *output.Output, path string, size int64) (err error) {
+// With --resume, it continues from the size of the remote file.
+func (cp *Scp) pushFile(lf io.ReadSeeker, ftp *sftp.Client, output *output.Output, path string, size int64) (err error) {
 	ow := output.NewWriter()
 
 	dir := filepath.Dir(path)
@@ -215,7 +264,15 @@ func (cp *Scp) pushFile(lf io.Reader, ftp *sftp.Client, output *output.Output, p
 		return err
 	}
 
-	rf, err := ftp.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	flag, offset := os.O_RDWR|os.O_CREATE|os.O_TRUNC, int64(0)
+	if cp.Resume {
+		stat, err := ftp.Stat(path)
+		if offset = resumeOffset(stat, err, size); offset > 0 {
+			flag = os.O_RDWR | os.O_CREATE
+		}
+	}
+
+	rf, err := ftp.OpenFile(path, flag)
 	if err != nil {
 		fmt.Fprintf(ow, "ftp.OpenFile error %v\n", err)
 
@@ -224,11 +281,21 @@ func (cp *Scp) pushFile(lf io.Reader, ftp *sftp.Client, output *output.Output, p
 
 	defer rf.Close()
 
+	if offset > 0 {
+		if err := seekBoth(lf, rf, offset); err != nil {
+			fmt.Fprintf(ow, "resume %s error %v\n", path, err)
+
+			return err
+		}
+
+		fmt.Fprintf(ow, "resume %s from %d/%d bytes\n", path, offset, size)
+	}
+
 	rd := io.TeeReader(common.CreateRateLimit(lf), rf)
 
 	// copy to data
 	cp.ProgressWG.Add(1)
-	return output.ProgressPrinter(size, rd, path)
+	return output.ProgressPrinter(size-offset, rd, path)
 }
 
 func (cp *Scp) viaPush() {
@@ -243,9 +310,27 @@ func (cp *Scp) viaPush() {
 		return
 	}
 
+	relay := tclient
+
+	var direct *directCopy
+	if cp.Direct {
+		direct, relay = cp.newDirectCopy(fclient[0], tclient)
+	}
+
 	// pull and push data
 	for _, path := range cp.From.Path {
-		cp.viaPushPath(path, fclient[0], tclient)
+		targets := relay
+		if direct != nil {
+			targets = append(direct.pushPath(path), relay...)
+		}
+
+		if len(targets) > 0 {
+			cp.viaPushPath(path, fclient[0], targets)
+		}
+	}
+
+	if direct != nil {
+		direct.close()
 	}
 
 	// wait 0.3 sec
@@ -261,6 +346,7 @@ func (cp *Scp) viaPushPath(path string, fclient *Connect, tclients []*Connect) {
 
 	// create from sftp walker
 	walker := ftp.Walk(path)
+	matcher := cp.Filter.Matcher(path, sftpReadFile(ftp))
 
 	// get from sftp output writer
 	fclient.Output.Create(fclient.Server)
@@ -279,40 +365,58 @@ func (cp *Scp) viaPushPath(path string, fclient *Connect, tclients []*Connect) {
 		}
 
 		stat := walker.Stat()
+		if skipExcluded(walker.SkipDir, matcher, p, stat) {
+			continue
+		}
 
 		if stat.IsDir() { // is directory
 			for _, tc := range tclients {
 				_ = tc.Connect.Mkdir(p)
 			}
 		} else { // is file
-			func() {
-				// open from server file
-				file, err := ftp.Open(p)
-				if err != nil {
-					fmt.Fprintf(fow, "ftp.Open Error: %v\n", err)
-					return
-				}
-				defer file.Close()
+			size := stat.Size()
+
+			// the checksum of the source file is computed once for all targets.
+			var (
+				sumOnce sync.Once
+				sum     string
+				sumErr  error
+			)
+
+			srcSum := func() (string, error) {
+				sumOnce.Do(func() { sum, sumErr = remoteSum(fclient, p) })
+				return sum, sumErr
+			}
 
-				size := stat.Size()
+			exit := make(chan bool)
+			for _, tc := range tclients {
+				tclient := tc
 
-				exit := make(chan bool)
-				for _, tc := range tclients {
-					tclient := tc
+				go func() {
+					defer func() { exit <- true }()
 
-					go func() {
-						defer func() { exit <- true }()
+					tclient.Output.Create(tclient.Server)
 
-						tclient.Output.Create(tclient.Server)
+					// open from server file, for each target to read from its own offset.
+					file, err := ftp.Open(p)
+					if err != nil {
+						fmt.Fprintf(fow, "ftp.Open Error: %v\n", err)
+						return
+					}
+					defer file.Close()
 
-						_ = cp.pushFile(file, tclient.Connect, tclient.Output, p, size)
-					}()
-				}
+					if err := cp.pushFile(file, tclient.Connect, tclient.Output, p, size); err != nil || !cp.Verify {
+						return
+					}
 
-				for range tclients {
-					<-exit
-				}
-			}()
+					cp.verify(tclient.Output.NewWriter(), tclient.Server, p, srcSum,
+						func() (string, error) { return remoteSum(tclient, p) })
+				}()
+			}
+
+			for range tclients {
+				<-exit
+			}
 		}
 	}
 }
@@ -373,6 +477,11 @@ func (cp *Scp) pullPath(client *Connect) {
 
 	baseDir, _ = filepath.Abs(baseDir)
 
+	if cp.Sync {
+		cp.syncPull(client, ow, baseDir)
+		return
+	}
+
 	// walk remote path
 	for _, path := range cp.From.Path {
 		globpath, err := tryEvalPath(ftp, path, ow)
@@ -383,6 +492,7 @@ func (cp *Scp) pullPath(client *Connect) {
 		for _, gp := range globpath {
 			remoteBase := filepath.Dir(gp) // basedir
 			walker := ftp.Walk(gp)
+			matcher := cp.Filter.Matcher(gp, sftpReadFile(ftp))
 
 			for walker.Step() {
 				if err := walker.Err(); err != nil {
@@ -395,14 +505,18 @@ func (cp *Scp) pullPath(client *Connect) {
 					continue // ignore hidden files.
 				}
 
+				stat := walker.Stat()
+				if skipExcluded(walker.SkipDir, matcher, p, stat) {
+					continue
+				}
+
 				rp, _ := filepath.Rel(remoteBase, p)
 				lpath := filepath.Join(baseDir, rp)
 
-				stat := walker.Stat()
 				if stat.IsDir() { // create dir
 					_ = os.MkdirAll(lpath, 0o755)
 				} else { // create file
-					cp.createFile(stat, p, ow, lpath, client)
+					_ = cp.createFile(stat, p, ow, lpath, client)
 				}
 
 				_ = os.Chmod(lpath, stat.Mode())
@@ -411,7 +525,34 @@ func (cp *Scp) pullPath(client *Connect) {
 	}
 }
 
-func tryEvalPath(ftp *sftp.Client, path string, ow *io.PipeWriter) ([]string, error) {
+// sftpReadFile returns the function to read the remote file, for the .gitignore of Filter.
+func sftpReadFile(ftp *sftp.Client) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		f, err := ftp.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return io.ReadAll(f)
+	}
+}
+
+// skipExcluded returns true if the walked path p is excluded by matcher, and skips the excluded directory by skipDir.
+func skipExcluded(skipDir func(), matcher *common.PathMatcher, p string, stat os.FileInfo) bool {
+	if !matcher.Excluded(p, stat.IsDir()) {
+		return false
+	}
+
+	if stat.IsDir() {
+		skipDir()
+	}
+
+	return true
+}
+
+func tryEvalPath(ftp *sftp.Client, path string, ow io.Writer) ([]string, error) {
 	if _, err := ftp.Stat(path); err == nil {
 		return []string{path}, nil
 	}
@@ -429,7 +570,7 @@ func tryEvalPath(ftp *sftp.Client, path string, ow *io.PipeWriter) ([]string, er
 	}
 }
 
-func (cp *Scp) createFile(stat os.FileInfo, p string, ow io.Writer, lpath string, client *Connect) {
+func (cp *Scp) createFile(stat os.FileInfo, p string, ow io.Writer, lpath string, client *Connect) error {
 	size := stat.Size()
 	ftp := client.Connect
 
@@ -437,26 +578,53 @@ func (cp *Scp) createFile(stat os.FileInfo, p string, ow io.Writer, lpath string
 	rf, err := ftp.Open(p)
 	if err != nil {
 		fmt.Fprintf(ow, "ftp.Open Error: %v\n", err)
-		return
+		return err
 	}
 
 	defer rf.Close()
 
+	// With --resume, it continues from the size of the local file.
+	flag, offset := os.O_RDWR|os.O_CREATE|os.O_TRUNC, int64(0)
+	if cp.Resume {
+		lstat, err := os.Stat(lpath)
+		if offset = resumeOffset(lstat, err, size); offset > 0 {
+			flag = os.O_RDWR | os.O_CREATE
+		}
+	}
+
 	// open local file
-	lf, err := os.OpenFile(lpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	lf, err := os.OpenFile(lpath, flag, 0o644)
 	if err != nil {
 		fmt.Fprintf(ow, "os.OpenFile Error: %v\n", err)
-		return
+		return err
 	}
 
 	defer lf.Close()
 
+	if offset > 0 {
+		if err := seekBoth(rf, lf, offset); err != nil {
+			fmt.Fprintf(ow, "resume %s error %v\n", lpath, err)
+			return err
+		}
+
+		fmt.Fprintf(ow, "resume %s from %d/%d bytes\n", lpath, offset, size)
+	}
+
 	rd := io.TeeReader(common.CreateRateLimit(rf), lf)
 
 	cp.ProgressWG.Add(1)
-	if err := client.Output.ProgressPrinter(size, rd, p); err != nil {
+	if err := client.Output.ProgressPrinter(size-offset, rd, p); err != nil {
 		fmt.Fprintf(ow, "Error: %v\n", err)
+		return err
+	}
+
+	if cp.Verify {
+		cp.verify(ow, client.Server, p,
+			func() (string, error) { return remoteSum(client, p) },
+			func() (string, error) { return localSum(lpath) })
 	}
+
+	return nil
 }
 
 // createScpConnects return []*ScpConnect.
@@ -495,7 +663,7 @@ func (cp *Scp) createScpConnects(targets []string) (result []*Connect) {
 			}
 
 			// create ScpConnect
-			scpCon := &Connect{Server: server, Connect: ftp, Output: o}
+			scpCon := &Connect{Server: server, Connect: ftp, Client: conn.Client, HostKey: conn.HostKey, Output: o}
 
 			// append result
 			m.Lock()
@@ -0,0 +1,446 @@
+package scp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bingoohuang/bssh/common"
+)
+
+// Sync actions of --sync mode.
+const (
+	syncCopy   = "copy"
+	syncMkdir  = "mkdir"
+	syncChmod  = "chmod"
+	syncDelete = "delete"
+)
+
+// syncStat counts the actions of --sync mode on a host.
+type syncStat struct {
+	copied, created, changed, deleted, upToDate, failed int
+}
+
+func (s *syncStat) add(action string) {
+	switch action {
+	case syncCopy:
+		s.copied++
+	case syncMkdir:
+		s.created++
+	case syncChmod:
+		s.changed++
+	case syncDelete:
+		s.deleted++
+	default:
+		s.upToDate++
+	}
+}
+
+// print prints the summary of the actions, the plan in --dry-run.
+func (s *syncStat) print(ow io.Writer, dryRun bool) {
+	format := "sync: %d copied, %d mkdir, %d chmod, %d deleted, %d up to date, %d failed\n"
+	if dryRun {
+		format = "dry-run: %d to copy, %d to mkdir, %d to chmod, %d to delete, %d up to date, %d failed\n"
+	}
+
+	fmt.Fprintf(ow, format, s.copied, s.created, s.changed, s.deleted, s.upToDate, s.failed)
+}
+
+// syncReason returns why the destination dst (stat error dstErr) is changed from the source src,
+// empty if it is up to date. sums returns the sha256 of the source and destination with --checksum.
+func (cp *Scp) syncReason(src, dst os.FileInfo, dstErr error, sums func() (string, string, error)) string {
+	switch {
+	case dstErr != nil:
+		return "new"
+	case src.IsDir() != dst.IsDir():
+		return "type"
+	case src.IsDir():
+		return ""
+	case src.Size() != dst.Size():
+		return "size"
+	case cp.Checksum:
+		srcSum, dstSum, err := sums()
+		if err != nil || srcSum != dstSum {
+			return "checksum"
+		}
+
+		return ""
+	case !src.ModTime().Truncate(time.Second).Equal(dst.ModTime().Truncate(time.Second)):
+		return "mtime"
+	}
+
+	return ""
+}
+
+// syncAction returns the action of the source src to the destination, by the reason of syncReason.
+func syncAction(src, dst os.FileInfo, reason string) string {
+	switch {
+	case reason == "" && src.Mode().Perm() != dst.Mode().Perm():
+		return syncChmod
+	case reason == "":
+		return ""
+	case src.IsDir():
+		return syncMkdir
+	default:
+		return syncCopy
+	}
+}
+
+// printSyncAction prints the action of path on the host, with dry-run prefix.
+func (cp *Scp) printSyncAction(ow io.Writer, action, path, reason string) {
+	prefix := ""
+	if cp.DryRun {
+		prefix = "dry-run: "
+	}
+
+	if reason != "" {
+		fmt.Fprintf(ow, "%s%s %s (%s)\n", prefix, action, path, reason)
+	} else {
+		fmt.Fprintf(ow, "%s%s %s\n", prefix, action, path)
+	}
+}
+
+// syncPush copies the changed files of pathset to the host, keeping the mtime and permission,
+// and deletes the files not in pathset under the directories of pathset with --delete.
+func (cp *Scp) syncPush(client *Connect, ow io.Writer, pathset []PathSet) {
+	ftp := client.Connect
+
+	var st syncStat
+
+	keep := map[string]bool{}
+
+	var roots []string
+
+	for i, p := range pathset {
+		if len(p.PathSlice) == 0 {
+			continue
+		}
+
+		if rel, err := filepath.Rel(p.Base, cp.From.Path[i]); err == nil {
+			roots = append(roots, filepath.Join(cp.To.Path[0], rel))
+		}
+
+		for _, path := range p.PathSlice {
+			rel, _ := filepath.Rel(p.Base, path)
+			rpath := filepath.Join(cp.To.Path[0], rel)
+			keep[rpath] = true
+
+			// follow the symlink like ftp.Stat of the destination, the content of the link target is copied.
+			src, err := os.Stat(path)
+			if err != nil {
+				fmt.Fprintf(ow, "os.Stat %s error %v\n", path, err)
+				st.failed++
+
+				continue
+			}
+
+			dst, dstErr := ftp.Stat(rpath)
+			reason := cp.syncReason(src, dst, dstErr, func() (string, string, error) {
+				return sumPair(func() (string, error) { return localSum(path) },
+					func() (string, error) { return remoteSum(client, rpath) })
+			})
+
+			if reason == "type" {
+				fmt.Fprintf(ow, "sync %s error: file type is different from %s\n", rpath, path)
+				st.failed++
+
+				continue
+			}
+
+			action := syncAction(src, dst, reason)
+			if action == "" {
+				st.add(action)
+				continue
+			}
+
+			cp.printSyncAction(ow, action, rpath, reason)
+
+			if !cp.DryRun {
+				if err := cp.syncPushPath(client, ow, action, path, rpath, src); err != nil {
+					st.failed++
+					continue
+				}
+			}
+
+			st.add(action)
+		}
+	}
+
+	if cp.Delete {
+		for _, root := range roots {
+			cp.syncDeleteRemote(client, ow, root, keep, &st)
+		}
+	}
+
+	st.print(ow, cp.DryRun)
+}
+
+// syncPushPath runs the action of local path to remote rpath.
+func (cp *Scp) syncPushPath(client *Connect, ow io.Writer, action, path, rpath string, src os.FileInfo) error {
+	ftp := client.Connect
+
+	switch action {
+	case syncMkdir:
+		if err := ftp.MkdirAll(rpath); err != nil {
+			fmt.Fprintf(ow, "ftp.MkdirAll rpath %s error %v\n", rpath, err)
+			return err
+		}
+	case syncCopy:
+		lf, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(ow, "os.Open path %s error %v\n", path, err)
+			return err
+		}
+
+		defer lf.Close()
+
+		if err := cp.pushFile(lf, ftp, client.Output, rpath, src.Size()); err != nil {
+			fmt.Fprintf(ow, "cp.pushFile %s->%s error %v\n", path, rpath, err)
+			return err
+		}
+
+		if err := ftp.Chtimes(rpath, time.Now(), src.ModTime()); err != nil {
+			fmt.Fprintf(ow, "ftp.Chtimes %s error %v\n", rpath, err)
+			return err
+		}
+
+		if cp.Verify {
+			cp.verify(ow, client.Server, rpath,
+				func() (string, error) { return localSum(path) },
+				func() (string, error) { return remoteSum(client, rpath) })
+		}
+	}
+
+	if err := ftp.Chmod(rpath, src.Mode()); err != nil {
+		fmt.Fprintf(ow, "ftp.Chmod %s error %v\n", rpath, err)
+		return err
+	}
+
+	return nil
+}
+
+// syncDeleteRemote deletes the remote files not in keep under the remote directory root.
+// The files excluded by Filter are kept.
+func (cp *Scp) syncDeleteRemote(client *Connect, ow io.Writer, root string, keep map[string]bool, st *syncStat) {
+	ftp := client.Connect
+
+	if stat, err := ftp.Stat(root); err != nil || !stat.IsDir() {
+		return
+	}
+
+	walker := ftp.Walk(root)
+	matcher := cp.Filter.Matcher(root, sftpReadFile(ftp))
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			continue
+		}
+
+		p := walker.Path()
+		if keep[p] || common.IsHidden(root, p) || skipExcluded(walker.SkipDir, matcher, p, walker.Stat()) {
+			continue
+		}
+
+		if walker.Stat().IsDir() {
+			walker.SkipDir()
+		}
+
+		cp.printSyncAction(ow, syncDelete, p, "")
+
+		if !cp.DryRun {
+			if err := ftp.RemoveAll(p); err != nil {
+				fmt.Fprintf(ow, "ftp.RemoveAll %s error %v\n", p, err)
+				st.failed++
+
+				continue
+			}
+		}
+
+		st.add(syncDelete)
+	}
+}
+
+// syncPull copies the changed files of the host to local, keeping the mtime and permission,
+// and deletes the local files not on the host under the directories pulled with --delete.
+func (cp *Scp) syncPull(client *Connect, ow io.Writer, baseDir string) {
+	ftp := client.Connect
+
+	var st syncStat
+
+	keep := map[string]bool{}
+
+	var roots []string
+
+	for _, path := range cp.From.Path {
+		globpath, err := tryEvalPath(ftp, path, ow)
+		if err != nil {
+			continue
+		}
+
+		for _, gp := range globpath {
+			remoteBase := filepath.Dir(gp)
+			if rel, err := filepath.Rel(remoteBase, gp); err == nil {
+				roots = append(roots, filepath.Join(baseDir, rel))
+			}
+
+			walker := ftp.Walk(gp)
+			matcher := cp.Filter.Matcher(gp, sftpReadFile(ftp))
+
+			for walker.Step() {
+				if err := walker.Err(); err != nil {
+					fmt.Fprintf(ow, "walker.Err Error: %v\n", err)
+					continue
+				}
+
+				p := walker.Path()
+				if common.IsHidden(remoteBase, p) {
+					continue // ignore hidden files.
+				}
+
+				src := walker.Stat()
+				if skipExcluded(walker.SkipDir, matcher, p, src) {
+					continue
+				}
+
+				// follow the symlink like os.Stat of the destination, the content of the link target is copied.
+				if src.Mode()&os.ModeSymlink != 0 {
+					target, err := ftp.Stat(p)
+					if err != nil {
+						fmt.Fprintf(ow, "ftp.Stat %s error %v\n", p, err)
+						st.failed++
+
+						continue
+					}
+
+					src = target
+				}
+
+				rp, _ := filepath.Rel(remoteBase, p)
+				lpath := filepath.Join(baseDir, rp)
+				keep[lpath] = true
+
+				dst, dstErr := os.Stat(lpath)
+				reason := cp.syncReason(src, dst, dstErr, func() (string, string, error) {
+					return sumPair(func() (string, error) { return remoteSum(client, p) },
+						func() (string, error) { return localSum(lpath) })
+				})
+
+				if reason == "type" {
+					fmt.Fprintf(ow, "sync %s error: file type is different from %s\n", lpath, p)
+					st.failed++
+
+					continue
+				}
+
+				action := syncAction(src, dst, reason)
+				if action == "" {
+					st.add(action)
+					continue
+				}
+
+				cp.printSyncAction(ow, action, lpath, reason)
+
+				if !cp.DryRun {
+					if err := cp.syncPullPath(client, ow, action, p, lpath, src); err != nil {
+						st.failed++
+						continue
+					}
+				}
+
+				st.add(action)
+			}
+		}
+	}
+
+	if cp.Delete {
+		for _, root := range roots {
+			cp.syncDeleteLocal(ow, root, keep, &st)
+		}
+	}
+
+	st.print(ow, cp.DryRun)
+}
+
+// syncPullPath runs the action of remote path p to local lpath.
+func (cp *Scp) syncPullPath(client *Connect, ow io.Writer, action, p, lpath string, src os.FileInfo) error {
+	switch action {
+	case syncMkdir:
+		if err := os.MkdirAll(lpath, 0o755); err != nil {
+			fmt.Fprintf(ow, "os.MkdirAll %s error %v\n", lpath, err)
+			return err
+		}
+	case syncCopy:
+		if err := cp.createFile(src, p, ow, lpath, client); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(lpath, time.Now(), src.ModTime()); err != nil {
+			fmt.Fprintf(ow, "os.Chtimes %s error %v\n", lpath, err)
+			return err
+		}
+	}
+
+	if err := os.Chmod(lpath, src.Mode()); err != nil {
+		fmt.Fprintf(ow, "os.Chmod %s error %v\n", lpath, err)
+		return err
+	}
+
+	return nil
+}
+
+// syncDeleteLocal deletes the local files not in keep under the local directory root.
+// The files excluded by Filter are kept.
+func (cp *Scp) syncDeleteLocal(ow io.Writer, root string, keep map[string]bool, st *syncStat) {
+	if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+		return
+	}
+
+	matcher := cp.Filter.Matcher(root, os.ReadFile)
+
+	_ = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || keep[p] || common.IsHidden(root, p) {
+			return nil
+		}
+
+		if matcher.Excluded(p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		cp.printSyncAction(ow, syncDelete, p, "")
+
+		if !cp.DryRun {
+			if err := os.RemoveAll(p); err != nil {
+				fmt.Fprintf(ow, "os.RemoveAll %s error %v\n", p, err)
+				st.failed++
+
+				return nil
+			}
+		}
+
+		st.add(syncDelete)
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// sumPair returns the sha256 of the source and destination.
+func sumPair(src, dst func() (string, error)) (string, string, error) {
+	srcSum, err := src()
+	if err != nil {
+		return "", "", err
+	}
+
+	dstSum, err := dst()
+
+	return srcSum, dstSum, err
+}
@@ -0,0 +1,175 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/bssh/common"
+	"github.com/bingoohuang/bssh/output"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vbauerster/mpb"
+)
+
+// fileInfo is os.FileInfo of a test file.
+type fileInfo struct {
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (f fileInfo) Name() string       { return "f" }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return f.mode }
+func (f fileInfo) ModTime() time.Time { return f.mtime }
+func (f fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func TestSyncReason(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := fileInfo{size: 3, mode: 0o644, mtime: mtime}
+	dir := fileInfo{mode: os.ModeDir | 0o755, mtime: mtime}
+
+	same := func() (string, string, error) { return "a", "a", nil }
+	differ := func() (string, string, error) { return "a", "b", nil }
+	failed := func() (string, string, error) { return "", "", errors.New("no sha256sum") }
+
+	type TestData struct {
+		desc     string
+		checksum bool
+		src, dst os.FileInfo
+		dstErr   error
+		sums     func() (string, string, error)
+		expect   string
+	}
+
+	tds := []TestData{
+		{desc: "New", src: file, dstErr: os.ErrNotExist, expect: "new"},
+		{desc: "File to directory", src: file, dst: dir, expect: "type"},
+		{desc: "Directory to file", src: dir, dst: file, expect: "type"},
+		{desc: "Directory", src: dir, dst: fileInfo{mode: os.ModeDir | 0o700}, expect: ""},
+		{desc: "Size", src: file, dst: fileInfo{size: 4, mode: 0o644, mtime: mtime}, expect: "size"},
+		{desc: "Mtime", src: file, dst: fileInfo{size: 3, mode: 0o644, mtime: mtime.Add(time.Second)}, expect: "mtime"},
+		{desc: "Mtime in a second", src: file, dst: fileInfo{size: 3, mode: 0o644, mtime: mtime.Add(time.Millisecond)}},
+		{desc: "Up to date", src: file, dst: file, expect: ""},
+		{desc: "Checksum", checksum: true, src: file, dst: file, sums: differ, expect: "checksum"},
+		{desc: "Checksum failed", checksum: true, src: file, dst: file, sums: failed, expect: "checksum"},
+		{desc: "Checksum ignores mtime", checksum: true, src: file, dst: fileInfo{size: 3, mtime: mtime.Add(time.Hour)}, sums: same},
+		{desc: "Checksum after size", checksum: true, src: file, dst: fileInfo{size: 4}, sums: same, expect: "size"},
+	}
+
+	for _, v := range tds {
+		cp := &Scp{Checksum: v.checksum}
+		assert.Equal(t, v.expect, cp.syncReason(v.src, v.dst, v.dstErr, v.sums), v.desc)
+	}
+}
+
+func TestSyncAction(t *testing.T) {
+	file := fileInfo{mode: 0o644}
+	dir := fileInfo{mode: os.ModeDir | 0o755}
+
+	type TestData struct {
+		desc     string
+		src, dst os.FileInfo
+		reason   string
+		expect   string
+	}
+
+	tds := []TestData{
+		{desc: "Up to date", src: file, dst: file, reason: "", expect: ""},
+		{desc: "Permission", src: file, dst: fileInfo{mode: 0o600}, reason: "", expect: syncChmod},
+		{desc: "Directory permission", src: dir, dst: fileInfo{mode: os.ModeDir | 0o700}, reason: "", expect: syncChmod},
+		{desc: "New file", src: file, reason: "new", expect: syncCopy},
+		{desc: "New directory", src: dir, reason: "new", expect: syncMkdir},
+		{desc: "Changed", src: file, dst: file, reason: "size", expect: syncCopy},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, syncAction(v.src, v.dst, v.reason), v.desc)
+	}
+}
+
+// TestSyncSymlink syncs a symlink twice, the content of the link target is copied once and up to date next time.
+func TestSyncSymlink(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+
+	src := filepath.Join(local, "src")
+	require.Nil(t, os.Mkdir(src, 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(src, "a"), []byte("0123456789"), 0o644))
+	require.Nil(t, os.Symlink("a", filepath.Join(src, "link")))
+
+	client := newSyncClient(t)
+
+	cp := &Scp{ProgressWG: client.Output.ProgressWG}
+	cp.From.Path = []string{src}
+	cp.To.Path = []string{remote}
+
+	data, err := common.WalkDirFilter(src, nil)
+	require.Nil(t, err)
+
+	pathset := []PathSet{{Base: local, PathSlice: data}}
+
+	var b bytes.Buffer
+
+	cp.syncPush(client, &b, pathset)
+	assert.Contains(t, b.String(), "sync: 2 copied, 1 mkdir, 0 chmod, 0 deleted, 0 up to date, 0 failed\n")
+
+	copied, err := os.ReadFile(filepath.Join(remote, "src", "link"))
+	require.Nil(t, err)
+	assert.Equal(t, "0123456789", string(copied), "the content of the link target")
+
+	b.Reset()
+	cp.syncPush(client, &b, pathset)
+	assert.Equal(t, "sync: 0 copied, 0 mkdir, 0 chmod, 0 deleted, 3 up to date, 0 failed\n", b.String(), "push")
+
+	// pull the remote symlink
+	require.Nil(t, os.Symlink("a", filepath.Join(remote, "src", "rlink")))
+
+	pulled := t.TempDir()
+	cp.From.Path = []string{filepath.Join(remote, "src")}
+
+	b.Reset()
+	cp.syncPull(client, &b, pulled)
+	assert.Contains(t, b.String(), "sync: 3 copied, 1 mkdir, 0 chmod, 0 deleted, 0 up to date, 0 failed\n")
+
+	b.Reset()
+	cp.syncPull(client, &b, pulled)
+	assert.Equal(t, "sync: 0 copied, 0 mkdir, 0 chmod, 0 deleted, 4 up to date, 0 failed\n", b.String(), "pull")
+}
+
+// newSyncClient returns the connection of an in-memory sftp server on the local file system.
+func newSyncClient(t *testing.T) *Connect {
+	t.Helper()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	require.Nil(t, err)
+
+	go func() { _ = server.Serve() }()
+
+	ftp, err := sftp.NewClientPipe(cr, cw)
+	require.Nil(t, err)
+
+	// the server closes the pipe to the client first, not to wait the client reading.
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = ftp.Close()
+	})
+
+	wg := new(sync.WaitGroup)
+	o := &output.Output{Progress: mpb.New(mpb.WithWaitGroup(wg), mpb.WithOutput(io.Discard)), ProgressWG: wg}
+
+	return &Connect{Server: "web1", Connect: ftp, Output: o}
+}
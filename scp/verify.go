@@ -0,0 +1,114 @@
+package scp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// resumeOffset returns the offset to continue the transfer of size bytes, by the stat of the destination file.
+// It is 0 if the destination does not exist, or is larger than the source.
+func resumeOffset(stat os.FileInfo, err error, size int64) int64 {
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() > size {
+		return 0
+	}
+
+	return stat.Size()
+}
+
+// seekBoth seeks the source and destination files to offset.
+func seekBoth(src, dst io.Seeker, offset int64) error {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := dst.Seek(offset, io.SeekStart)
+
+	return err
+}
+
+// localSum returns the sha256 of the local file.
+func localSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	return readerSum(f)
+}
+
+// remoteSum returns the sha256 of the remote file, by sha256sum (or shasum) on the server.
+// If the command is not available, the file is read through sftp.
+func remoteSum(client *Connect, path string) (string, error) {
+	if client.Client != nil {
+		if session, err := client.Client.NewSession(); err == nil {
+			q := shellQuote(path)
+			out, err := session.Output(fmt.Sprintf("sha256sum %s 2>/dev/null || shasum -a 256 %s", q, q))
+			_ = session.Close()
+
+			if fields := strings.Fields(string(out)); err == nil && len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+				return fields[0], nil
+			}
+		}
+	}
+
+	f, err := client.Connect.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	return readerSum(f)
+}
+
+func readerSum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verify compares the sha256 of the source and destination file of path on server, and reports the mismatch.
+func (cp *Scp) verify(ow io.Writer, server, path string, src, dst func() (string, error)) {
+	srcSum, err := src()
+	if err == nil {
+		var dstSum string
+		if dstSum, err = dst(); err == nil && srcSum != dstSum {
+			err = fmt.Errorf("sha256 mismatch, source %s destination %s", srcSum, dstSum)
+		}
+	}
+
+	if err == nil {
+		fmt.Fprintf(ow, "verify %s ok, sha256 %s\n", path, srcSum)
+		return
+	}
+
+	fmt.Fprintf(ow, "verify %s error %v\n", path, err)
+
+	cp.verifyMu.Lock()
+	defer cp.verifyMu.Unlock()
+
+	cp.verifyFailed = append(cp.verifyFailed, server+":"+path)
+	cp.ExitCode = 1
+}
+
+// printVerifyFailed prints the files not verified of all servers.
+func (cp *Scp) printVerifyFailed() {
+	if len(cp.verifyFailed) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "verify failed: %d file(s)\n", len(cp.verifyFailed))
+
+	for _, f := range cp.verifyFailed {
+		fmt.Fprintf(os.Stderr, "  %s\n", f)
+	}
+}
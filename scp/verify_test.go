@@ -0,0 +1,85 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeOffset(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "part")
+	require.Nil(t, os.WriteFile(file, []byte("12345"), 0o600))
+
+	type TestData struct {
+		desc   string
+		path   string
+		size   int64
+		expect int64
+	}
+
+	tds := []TestData{
+		{desc: "Partial", path: file, size: 10, expect: 5},
+		{desc: "Complete", path: file, size: 5, expect: 5},
+		{desc: "Larger than source", path: file, size: 3, expect: 0},
+		{desc: "Not exist", path: filepath.Join(dir, "none"), size: 10, expect: 0},
+		{desc: "Directory", path: dir, size: 1 << 20, expect: 0},
+	}
+
+	for _, v := range tds {
+		stat, err := os.Stat(v.path)
+		assert.Equal(t, v.expect, resumeOffset(stat, err, v.size), v.desc)
+	}
+}
+
+// failSeeker fails to seek.
+type failSeeker struct{}
+
+func (failSeeker) Seek(int64, int) (int64, error) { return 0, errors.New("not seekable") }
+
+func TestSeekBoth(t *testing.T) {
+	src, dst := strings.NewReader("0123456789"), strings.NewReader("0123")
+
+	require.Nil(t, seekBoth(src, dst, 4))
+
+	rest, _ := io.ReadAll(src)
+	assert.Equal(t, "456789", string(rest))
+	assert.Equal(t, 0, dst.Len(), "destination at its end")
+
+	assert.EqualError(t, seekBoth(failSeeker{}, dst, 1), "not seekable", "source")
+	assert.EqualError(t, seekBoth(src, failSeeker{}, 1), "not seekable", "destination")
+}
+
+func TestVerify(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a")
+	require.Nil(t, os.WriteFile(file, []byte("abc"), 0o600))
+
+	sum, err := localSum(file)
+	require.Nil(t, err)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", sum)
+
+	src := func() (string, error) { return localSum(file) }
+	same := func() (string, error) { return readerSum(strings.NewReader("abc")) }
+	differ := func() (string, error) { return readerSum(strings.NewReader("abd")) }
+
+	cp := &Scp{}
+
+	var b bytes.Buffer
+
+	cp.verify(&b, "web1", "/tmp/a", src, same)
+	assert.Equal(t, "verify /tmp/a ok, sha256 "+sum+"\n", b.String())
+	assert.Equal(t, 0, cp.ExitCode)
+
+	b.Reset()
+	cp.verify(&b, "web2", "/tmp/a", src, differ)
+	assert.Contains(t, b.String(), "verify /tmp/a error sha256 mismatch, source "+sum)
+	assert.Equal(t, 1, cp.ExitCode)
+	assert.Equal(t, []string{"web2:/tmp/a"}, cp.verifyFailed)
+}
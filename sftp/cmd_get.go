@@ -38,13 +38,14 @@ func (r *RunSftp) get(args []string) {
 	app.EnableBashCompletion = true
 
 	// action
+	app.Flags = common.FilterFlags()
 	app.Action = r.getAction
 	// parse short options
 	args = common.ParseArgs(app.Flags, args)
 	_ = app.Run(args)
 }
 
-func (r *RunSftp) pullPath(client *Connect, path, target string) {
+func (r *RunSftp) pullPath(client *Connect, path, target string, filter *common.PathFilter) {
 	// set arg path
 	var rpath string
 
@@ -66,6 +67,7 @@ func (r *RunSftp) pullPath(client *Connect, path, target string) {
 	// for walk
 	for _, ep := range epath {
 		walker := client.Connect.Walk(ep)
+		matcher := filter.Matcher(ep, sftpReadFile(client))
 
 		for walker.Step() {
 			err := walker.Err()
@@ -75,9 +77,18 @@ func (r *RunSftp) pullPath(client *Connect, path, target string) {
 			}
 
 			p := walker.Path()
-			relpath, _ := filepath.Rel(base, p)
 			stat := walker.Stat()
 
+			if matcher.Excluded(p, stat.IsDir()) {
+				if stat.IsDir() {
+					walker.SkipDir()
+				}
+
+				continue
+			}
+
+			relpath, _ := filepath.Rel(base, p)
+
 			localpath := filepath.Join(target, relpath)
 
 			//
@@ -101,6 +112,12 @@ func (r *RunSftp) getAction(c *cli.Context) error {
 		return nil
 	}
 
+	filter, err := common.ContextPathFilter(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return nil
+	}
+
 	// Create Progress
 	r.ProgressWG = new(sync.WaitGroup)
 	r.Progress = mpb.New(mpb.WithWaitGroup(r.ProgressWG))
@@ -132,7 +149,7 @@ func (r *RunSftp) getAction(c *cli.Context) error {
 			}
 		}
 
-		go r.doGet(exit, c, server, source, targetdir)
+		go r.doGet(exit, c, server, source, targetdir, filter)
 	}
 
 	// wait exit
@@ -149,7 +166,7 @@ func (r *RunSftp) getAction(c *cli.Context) error {
 	return nil
 }
 
-func (r *RunSftp) doGet(exit chan bool, client *Connect, server, source, targetdir string) {
+func (r *RunSftp) doGet(exit chan bool, client *Connect, server, source, targetdir string, filter *common.PathFilter) {
 	defer func() { exit <- true }()
 
 	// set Progress
@@ -159,7 +176,7 @@ func (r *RunSftp) doGet(exit chan bool, client *Connect, server, source, targetd
 	// create output
 	client.Output.Create(server)
 
-	r.pullPath(client, source, targetdir)
+	r.pullPath(client, source, targetdir, filter)
 }
 
 func (r *RunSftp) parseTarget(c *cli.Context) (string, error) {
@@ -182,6 +199,20 @@ func (r *RunSftp) parseTarget(c *cli.Context) (string, error) {
 	return target, nil
 }
 
+// sftpReadFile returns the function to read the remote file of client, for the .gitignore of PathFilter.
+func sftpReadFile(client *Connect) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		f, err := client.Connect.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return io.ReadAll(f)
+	}
+}
+
 func pullFile(stat os.FileInfo, client *Connect, localpath, p string, r *RunSftp) error {
 	// get size
 	size := stat.Size()
@@ -30,6 +30,7 @@ func (r *RunSftp) put(args []string) {
 	app.HideHelp = true
 	app.HideVersion = true
 	app.EnableBashCompletion = true
+	app.Flags = common.FilterFlags()
 	app.Action = r.putAction
 
 	// parse short options
@@ -45,6 +46,12 @@ func (r *RunSftp) putAction(c *cli.Context) error {
 		return nil
 	}
 
+	filter, err := common.ContextPathFilter(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return nil
+	}
+
 	// Create Progress
 	r.ProgressWG = new(sync.WaitGroup)
 	r.Progress = mpb.New(mpb.WithWaitGroup(r.ProgressWG))
@@ -53,7 +60,7 @@ func (r *RunSftp) putAction(c *cli.Context) error {
 	source := ss.ExpandHome(c.Args()[0])
 	target := c.Args()[1]
 
-	data, err := common.WalkDir(source)
+	data, err := common.WalkDirFilter(source, filter)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return nil
@@ -0,0 +1,96 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/bssh/common"
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/internal/forwards"
+	"github.com/bingoohuang/bssh/sshlib"
+)
+
+// backgroundExecute keeps port forwarding in the background process (-f option),
+// until the connection is lost or it is stopped by `bssh forwards stop`.
+func (r *Run) backgroundExecute(config *conf.ServerConfig, server string, connect *sshlib.Connect) {
+	info := forwards.Info{
+		ID:       r.ForwardID,
+		Server:   server,
+		Forwards: forwardSpecs(config),
+		Started:  time.Now(),
+	}
+
+	stop, cleanup, err := forwards.Serve(info)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer cleanup()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if r.AutoReconnect {
+			r.noneExecute(config, server, connect)
+			return
+		}
+
+		go connect.SendClientKeepAlive()
+		_ = connect.SSHClient().Wait()
+		fmt.Fprintf(os.Stderr, "connection to %s lost.\n", server)
+	}()
+
+	select {
+	case <-stop:
+	case <-done:
+	}
+}
+
+// AuthPrompts returns the passwords and passphrases asked on the terminal to authenticate the servers.
+// The background process (-f option) has no terminal, they must be in the config or ssh-agent.
+func (r *Run) AuthPrompts() (prompts []string) {
+	for _, server := range r.getSSHServers() {
+		config := r.Conf.Server[server]
+
+		for _, pass := range append([]string{config.Pass}, config.Passes...) {
+			if strings.EqualFold(pass, "{Prompt}") {
+				prompts = append(prompts, "password of "+server)
+				break
+			}
+		}
+
+		// pairs of key and passphrase
+		keys := [][]string{{config.Key, config.KeyPass}}
+		if config.Cert != "" {
+			keys = append(keys, []string{config.CertKey, config.CertKeyPass})
+		}
+
+		for _, key := range config.Keys {
+			keys = append(keys, append(strings.SplitN(key, "::", 2), ""))
+		}
+
+		for _, key := range keys {
+			if key[0] != "" && sshlib.NeedPassphrase(key[0], key[1]) {
+				prompts = append(prompts, "passphrase of "+key[0])
+			}
+		}
+	}
+
+	sort.Strings(prompts)
+
+	return common.GetUniqueSlice(prompts)
+}
+
+// forwardSpecs returns the description of port forwarding in config.
+func forwardSpecs(config *conf.ServerConfig) (specs []string) {
+	for _, f := range config.GetPortForwards() {
+		specs = append(specs, f.String())
+	}
+
+	return specs
+}
@@ -0,0 +1,36 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthPrompts(t *testing.T) {
+	servers := map[string]conf.ServerConfig{
+		"web1":  {Addr: "10.0.0.1", User: "u", Pass: "p"},
+		"web2":  {Addr: "10.0.0.2", User: "u", Pass: "{prompt}"},
+		"web3":  {Addr: "10.0.0.3", User: "u", Passes: []string{"p", "{Prompt}"}, Proxy: "jump"},
+		"jump":  {Addr: "10.0.0.9", User: "u", Pass: "{Prompt}"},
+		"nokey": {Addr: "10.0.0.4", User: "u", Key: "/not/exist", Keys: []string{"/not/exist2::"}},
+	}
+
+	type TestData struct {
+		desc   string
+		server string
+		expect []string
+	}
+
+	tds := []TestData{
+		{desc: "Password", server: "web1"},
+		{desc: "Prompt", server: "web2", expect: []string{"password of web2"}},
+		{desc: "Prompt of proxy", server: "web3", expect: []string{"password of jump", "password of web3"}},
+		{desc: "No key file", server: "nokey"},
+	}
+
+	for _, v := range tds {
+		r := &Run{ServerList: []string{v.server}, Conf: conf.Config{Server: servers}}
+		assert.Equal(t, v.expect, r.AuthPrompts(), v.desc)
+	}
+}
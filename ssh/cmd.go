@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,7 +20,6 @@ const cmdOPROMPT = "${SERVER} :: "
 // cmd is run command.
 func (r *Run) cmd() {
 	command := strings.Join(r.ExecCmd, " ")
-	finished, exitInput := make(chan bool), make(chan bool)
 
 	// print header
 	r.PrintSelectServer()
@@ -29,6 +29,53 @@ func (r *Run) cmd() {
 		r.printProxy(r.ServerList[0])
 	}
 
+	if r.Script != "" {
+		script, err := r.loadScript()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --script: %v\n", err)
+			r.ExitCode = exitCodeFailed
+
+			return
+		}
+
+		r.script = script
+	}
+
+	if r.OutputFormat != "" && r.OutputFormat != output.FormatText {
+		r.recordPrinter = output.NewRecordPrinter(r.OutputFormat, os.Stdout)
+		defer r.recordPrinter.Flush()
+	}
+
+	r.cmdResults = newCmdResults()
+
+	if r.TotalTimeout > 0 {
+		t := time.AfterFunc(r.TotalTimeout, r.cmdResults.expire)
+		defer t.Stop()
+	}
+
+	if r.Concurrency > 0 || r.BatchSize > 0 {
+		r.cmdBatches(command)
+	} else {
+		r.cmdAll(command)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if r.GroupOutput && r.recordPrinter == nil {
+		output.PrintGroups(os.Stdout, output.GroupOutputs(r.cmdResults.bufferedOutputs()))
+	}
+
+	if len(r.ServerList) > 1 {
+		r.cmdResults.printSummary()
+	}
+
+	r.ExitCode = r.cmdResults.exitCode()
+}
+
+// cmdAll connects to all servers, then runs command on all servers at once.
+func (r *Run) cmdAll(command string) {
+	finished, exitInput := make(chan bool), make(chan bool)
+
 	connMap := r.createConnMap()
 	writers := r.createWriter(connMap)
 
@@ -50,8 +97,8 @@ func (r *Run) cmd() {
 	}
 
 	// run command
-	for _, c := range connMap {
-		r.runCommand(c, finished, command, stdinData)
+	for s, c := range connMap {
+		r.runCommand(s, c, finished, command, stdinData)
 	}
 
 	// wait
@@ -60,8 +107,6 @@ func (r *Run) cmd() {
 	}
 
 	close(exitInput)
-
-	time.Sleep(300 * time.Millisecond)
 }
 
 func (r *Run) createWriter(connMap map[string]*sshlib.Connect) []io.WriteCloser {
@@ -72,15 +117,7 @@ func (r *Run) createWriter(connMap map[string]*sshlib.Connect) []io.WriteCloser
 		c.Session, _ = c.CreateSession()
 
 		config := r.Conf.Server[s]
-
-		o := &output.Output{
-			Templete: cmdOPROMPT, Count: 0, AutoColor: true,
-			ServerList: r.ServerList, Conf: r.Conf.Server[s],
-			EnableHeader: r.EnableHeader, DisableHeader: r.DisableHeader,
-		}
-		o.Create(s)
-
-		c.Stdout, c.Stderr = o.NewWriter(), o.NewWriter()
+		r.setOutputWriter(s, c)
 
 		// if single server, setup port forwarding.
 		if len(r.ServerList) == 1 {
@@ -94,41 +131,161 @@ func (r *Run) createWriter(connMap map[string]*sshlib.Connect) []io.WriteCloser
 	return writers
 }
 
+// setOutputWriter sets the stdout/stderr writers of server to c, in text, grouped or structured output.
+func (r *Run) setOutputWriter(server string, c *sshlib.Connect) {
+	o := &output.Output{
+		Templete: cmdOPROMPT, Count: 0, AutoColor: true,
+		ServerList: r.ServerList, Conf: r.Conf.Server[server],
+		EnableHeader: r.EnableHeader, DisableHeader: r.DisableHeader,
+	}
+	o.Create(server)
+
+	switch {
+	case r.recordPrinter != nil:
+		r.createRecordWriter(server, c, o)
+	case r.GroupOutput:
+		buf := r.cmdResults.newBuffer(server)
+		c.Stdout, c.Stderr = buf, buf
+	default:
+		c.Stdout, c.Stderr = o.NewWriter(), o.NewWriter()
+	}
+}
+
 func (r *Run) createConnMap() map[string]*sshlib.Connect {
 	connMap := map[string]*sshlib.Connect{}
 
 	// Create sshlib.Connect to connMap
 	for _, server := range r.ServerList {
-		cf := r.parseDirectServer(server)
-
-		// check count AuthMethod
-		if len(r.serverAuthMethodMap[server]) == 0 {
-			fmt.Fprintf(os.Stderr, "Error: %s is No AuthMethod.\n", server)
-			continue
+		if id, conn, ok := r.connectServer(server); ok {
+			connMap[id] = conn
 		}
+	}
+
+	return connMap
+}
+
+// connectServer creates sshlib.Connect to server, and returns it with the server id.
+// If it can not connect, the server is collected as unreachable.
+func (r *Run) connectServer(server string) (id string, conn *sshlib.Connect, ok bool) {
+	cf := r.serverConfig(server)
+
+	// check count AuthMethod
+	if len(r.serverAuthMethodMap[cf.ID]) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s is No AuthMethod.\n", server)
+		r.unreachable(cf.ID, errors.New("no AuthMethod"))
+		return cf.ID, nil, false
+	}
+
+	conn, err := r.CreateSSHConnect(&cf, server)
+	if err != nil {
+		log.Printf("Error: %s:%s\n", server, err)
+		r.unreachable(cf.ID, err)
+		return cf.ID, nil, false
+	}
+
+	if cf.DirectServer {
+		r.connectMu.Lock()
+		r.Conf.WriteTempHosts(cf.ID, server, cf.Pass)
+		r.connectMu.Unlock()
+	}
+
+	r.cmdResults.addConn(cf.ID, conn)
+
+	return cf.ID, conn, true
+}
+
+// serverConfig returns the config of server name in r.ServerList, parsing the direct server only once.
+// It is safe to call from connecting goroutines, after all servers are resolved once.
+func (r *Run) serverConfig(server string) conf.ServerConfig {
+	r.connectMu.Lock()
+	defer r.connectMu.Unlock()
+
+	if cf, ok := r.serverConfigs[server]; ok {
+		return cf
+	}
 
-		conn, err := r.CreateSSHConnect(&cf, server)
+	cf, ok := r.Conf.Server[server]
+	if !ok {
+		cf = r.parseDirectServer(server)
+	}
+
+	if r.serverConfigs == nil {
+		r.serverConfigs = map[string]conf.ServerConfig{}
+	}
+
+	r.serverConfigs[server] = cf
+
+	return cf
+}
+
+// unreachable collects the result of server that can not connect.
+func (r *Run) unreachable(server string, err error) {
+	res := r.cmdResults.addStatus(server, ResultUnreachable, err)
+
+	if r.recordPrinter != nil {
+		r.printResultRecord(res)
+	}
+
+	if r.FailFast {
+		r.cmdResults.cancel()
+	}
+}
+
+// skipServer collects the result of server that is canceled or timeout before running.
+func (r *Run) skipServer(server, status string, err error) {
+	res := r.cmdResults.addStatus(server, status, err)
+
+	if r.recordPrinter != nil {
+		r.printResultRecord(res)
+	}
+}
+
+// execCommand runs command on conn.Session, with --timeout and --total-timeout.
+func (r *Run) execCommand(server string, conn *sshlib.Connect, command string) error {
+	if conn.Session == nil {
+		session, err := conn.CreateSession()
 		if err != nil {
-			log.Printf("Error: %s:%s\n", server, err)
-			continue
+			return err
 		}
 
-		if cf.DirectServer {
-			r.Conf.WriteTempHosts(cf.ID, server, cf.Pass)
-		}
+		conn.Session = session
+	}
+
+	// --timeout covers the upload of the script, the connection is closed by timeout while uploading.
+	stop := r.cmdResults.watch(server, conn.Session, r.Timeout)
+	defer stop()
 
-		connMap[cf.ID] = conn
+	// upload the script, and run it with the command as arguments.
+	if r.script != nil {
+		done := r.cmdResults.uploading(server)
+		command = r.script.command(conn, command, r.Conf.Server[server].LocalRcDecodeCmd)
+		done()
 	}
 
-	return connMap
+	return conn.Command(command)
 }
 
-func (r *Run) runCommand(conn *sshlib.Connect, finished chan bool, command string, stdinData []byte) {
+func (r *Run) runCommand(server string, conn *sshlib.Connect, finished chan bool, command string, stdinData []byte) {
+	run := func() {
+		// not run after the first failure (--fail-fast), or --total-timeout.
+		if status, err, ok := r.cmdResults.skipped(); ok {
+			_ = conn.Client.Close()
+			r.cmdResults.outputDone(server)()
+			r.skipServer(server, status, err)
+
+			return
+		}
+
+		start := time.Now()
+		err := r.execCommand(server, conn, command)
+		r.commandResult(server, start, err)
+	}
+
 	if r.IsParallel {
 		go func() {
 			defer func() { finished <- true }()
 
-			_ = conn.Command(command)
+			run()
 		}()
 
 		return
@@ -143,7 +300,7 @@ func (r *Run) runCommand(conn *sshlib.Connect, finished chan bool, command strin
 		go func() {
 			defer func() { finished <- true }()
 
-			_ = conn.Command(command)
+			run()
 		}()
 
 		// send stdin
@@ -151,41 +308,70 @@ func (r *Run) runCommand(conn *sshlib.Connect, finished chan bool, command strin
 		_ = w.Close()
 	} else {
 		// run command
-		_ = conn.Command(command)
+		run()
 		go func() { finished <- true }()
 	}
 }
 
-func (r *Run) setupPortForwarding(config *conf.ServerConfig, c *sshlib.Connect) {
-	// OverWrite port forward mode
-	if r.PortForwardMode != "" {
-		config.PortForwardMode = r.PortForwardMode
-	}
+// createRecordWriter sets the writers of structured output to c.
+func (r *Run) createRecordWriter(server string, c *sshlib.Connect, o *output.Output) {
+	stdout, stdoutDone := o.NewRecordWriter(r.recordPrinter, "stdout")
+	stderr, stderrDone := o.NewRecordWriter(r.recordPrinter, "stderr")
+	c.Stdout, c.Stderr = stdout, stderr
+
+	r.cmdResults.setOutputDone(server, func() {
+		_ = stdout.Close()
+		_ = stderr.Close()
+		<-stdoutDone
+		<-stderrDone
+	})
+}
+
+// commandResult collects the result of the command on server.
+// In structured output, prints the final record of server after all lines of the server.
+// With --fail-fast, the first failure cancels the remaining servers.
+func (r *Run) commandResult(server string, start time.Time, err error) {
+	res := r.cmdResults.add(server, time.Since(start), err)
 
-	// Overwrite port forward address
-	if r.PortForwardLocal != "" && r.PortForwardRemote != "" {
-		config.PortForwardLocal = r.PortForwardLocal
-		config.PortForwardRemote = r.PortForwardRemote
+	if r.recordPrinter != nil {
+		r.cmdResults.outputDone(server)()
+		r.printResultRecord(res)
 	}
 
-	// print header
-	r.printPortForward(config.PortForwardMode, config.PortForwardLocal, config.PortForwardRemote)
+	if r.FailFast && (res.Status == ResultFailed || res.Status == ResultTimeout) {
+		r.cmdResults.cancel()
+	}
+}
 
-	// Port Forwarding
-	switch config.PortForwardMode {
-	case "L", "":
-		_ = c.TCPLocalForward(config.PortForwardLocal, config.PortForwardRemote)
-	case "R":
-		_ = c.TCPRemoteForward(config.PortForwardLocal, config.PortForwardRemote)
+// printResultRecord prints the final record of res in structured output.
+func (r *Run) printResultRecord(res *cmdResult) {
+	rec := output.Record{
+		Type: output.RecordResult, Server: res.Server, Addr: r.Conf.Server[res.Server].Addr, Status: res.Status,
 	}
 
-	// Dynamic Port Forwarding
-	if config.DynamicPortForward != "" {
-		r.printDynamicPortForward(config.DynamicPortForward)
+	if res.Duration > 0 {
+		rec.Duration = res.Duration.Round(time.Millisecond).String()
+	}
 
-		go func() { _ = c.TCPDynamicForward("localhost", config.DynamicPortForward) }()
+	if res.ExitStatus >= 0 {
+		rec.ExitStatus = &res.ExitStatus
+		rec.Signal = res.Signal
+	} else if res.Err != nil {
+		rec.Error = res.Err.Error()
 	}
 
+	r.recordPrinter.Print(rec)
+}
+
+func (r *Run) setupPortForwarding(config *conf.ServerConfig, c *sshlib.Connect) {
+	r.overwritePortForwardConfig(config)
+
+	// print header
+	r.printPortForwards(config.GetPortForwards())
+
+	// Port Forwarding
+	_ = r.portForwarding(config, c)
+
 	// if tty
 	if r.IsTerm {
 		c.Stdin = os.Stdin
@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/bssh/sshlib"
+)
+
+// cmdBatches runs command on servers in rolling batches, like Ansible `serial`.
+// In each batch, servers are connected and run in a worker pool of r.Concurrency.
+// Stdin from pipe is sent to each server, interactive input is not sent.
+func (r *Run) cmdBatches(command string) {
+	var stdinData []byte
+	if r.isStdinPipe {
+		stdinData, _ = io.ReadAll(os.Stdin)
+	}
+
+	// resolve all servers and ssh-agent before connecting in goroutines.
+	for _, server := range r.ServerList {
+		r.serverConfig(server)
+	}
+
+	if r.agent == nil {
+		r.agent = sshlib.ConnectSshAgent()
+	}
+
+	batches := splitBatches(r.ServerList, r.BatchSize)
+
+	for i, batch := range batches {
+		if i > 0 {
+			if err := r.betweenBatches(); err != nil {
+				r.haltBatches(batches[i:], err)
+				return
+			}
+		}
+
+		if len(batches) > 1 {
+			fmt.Fprintf(os.Stderr, "Batch         :%d/%d %s\n", i+1, len(batches), strings.Join(batch, ","))
+		}
+
+		r.runBatch(batch, command, stdinData)
+
+		if failed := r.cmdResults.failedCount(); r.MaxFail > 0 && failed >= r.MaxFail && i+1 < len(batches) {
+			r.haltBatches(batches[i+1:], fmt.Errorf("halted by --max-fail, %d servers failed", failed))
+			return
+		}
+	}
+}
+
+// splitBatches splits servers into batches of size, size 0 is a single batch.
+func splitBatches(servers []string, size int) (batches [][]string) {
+	if size <= 0 || size > len(servers) {
+		size = len(servers)
+	}
+
+	for start := 0; start < len(servers); start += size {
+		end := start + size
+		if end > len(servers) {
+			end = len(servers)
+		}
+
+		batches = append(batches, servers[start:end])
+	}
+
+	return batches
+}
+
+// betweenBatches pauses and runs the local health check command between batches.
+func (r *Run) betweenBatches() error {
+	if r.BatchPause > 0 {
+		fmt.Fprintf(os.Stderr, "Batch         :pause %s\n", r.BatchPause)
+		time.Sleep(r.BatchPause)
+	}
+
+	if r.BatchCheck == "" {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Batch         :check `%s`\n", r.BatchCheck)
+
+	cmd := exec.Command("sh", "-c", r.BatchCheck)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("halted by --batch-check: %w", err)
+	}
+
+	return nil
+}
+
+// haltBatches collects the servers of remaining batches as canceled.
+func (r *Run) haltBatches(batches [][]string, err error) {
+	fmt.Fprintf(os.Stderr, "Batch         :%s\n", err)
+
+	for _, batch := range batches {
+		for _, server := range batch {
+			r.skipServer(r.serverID(server), ResultCanceled, err)
+		}
+	}
+}
+
+// runBatch connects and runs command on servers, r.Concurrency servers at a time.
+func (r *Run) runBatch(servers []string, command string, stdinData []byte) {
+	concurrency := r.Concurrency
+	if concurrency <= 0 || concurrency > len(servers) {
+		concurrency = len(servers)
+	}
+
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for _, server := range servers {
+		sem <- struct{}{}
+
+		// not run after the first failure (--fail-fast), or --total-timeout.
+		if status, err, ok := r.cmdResults.skipped(); ok {
+			<-sem
+			r.skipServer(r.serverID(server), status, err)
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(server string) {
+			defer func() { <-sem; wg.Done() }()
+
+			r.runServer(server, command, stdinData)
+		}(server)
+	}
+
+	wg.Wait()
+}
+
+// runServer connects to server, runs command and closes the connection.
+func (r *Run) runServer(server, command string, stdinData []byte) {
+	id, conn, ok := r.connectServer(server)
+	if !ok {
+		return
+	}
+
+	defer conn.Client.Close()
+
+	conn.Session, _ = conn.CreateSession()
+	if conn.Session == nil {
+		r.unreachable(id, fmt.Errorf("failed to create session"))
+		return
+	}
+
+	r.setOutputWriter(id, conn)
+
+	// send stdin, and EOF.
+	w, _ := conn.Session.StdinPipe()
+	go func() {
+		_, _ = io.Copy(w, bytes.NewReader(stdinData))
+		_ = w.Close()
+	}()
+
+	start := time.Now()
+	err := r.execCommand(id, conn, command)
+	r.commandResult(id, start, err)
+}
+
+// serverID returns the server id of server name in r.ServerList.
+func (r *Run) serverID(server string) string {
+	return r.serverConfig(server).ID
+}
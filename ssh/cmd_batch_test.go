@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBatches(t *testing.T) {
+	type TestData struct {
+		desc    string
+		servers []string
+		size    int
+		expect  [][]string
+	}
+
+	tds := []TestData{
+		{desc: "Single batch", servers: []string{"a", "b", "c"}, size: 0, expect: [][]string{{"a", "b", "c"}}},
+		{desc: "Negative size", servers: []string{"a", "b"}, size: -1, expect: [][]string{{"a", "b"}}},
+		{desc: "Rolling", servers: []string{"a", "b", "c", "d", "e"}, size: 2, expect: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{desc: "Exact", servers: []string{"a", "b", "c", "d"}, size: 2, expect: [][]string{{"a", "b"}, {"c", "d"}}},
+		{desc: "Larger than servers", servers: []string{"a", "b"}, size: 5, expect: [][]string{{"a", "b"}}},
+		{desc: "No servers", servers: nil, size: 2, expect: nil},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, splitBatches(v.servers, v.size), v.desc)
+	}
+}
@@ -0,0 +1,392 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/bssh/output"
+	"github.com/bingoohuang/bssh/sshlib"
+	"github.com/jedib0t/go-pretty/table"
+	"golang.org/x/crypto/ssh"
+)
+
+// Result status of the command on a server in command mode.
+const (
+	ResultOK          = "ok"
+	ResultFailed      = "failed"
+	ResultUnreachable = "unreachable"
+	ResultCanceled    = "canceled"
+	ResultTimeout     = "timeout"
+)
+
+// exit code of command mode, when there is no exit status of remote command.
+const (
+	exitCodeFailed      = 1
+	exitCodeTimeout     = 124
+	exitCodeUnreachable = 255
+)
+
+// errFailFast is the error of servers canceled by --fail-fast.
+var errFailFast = errors.New("canceled by --fail-fast")
+
+// errTotalTimeout is the error of servers not finished in --total-timeout.
+var errTotalTimeout = errors.New("--total-timeout expired")
+
+// cmdResult is the result of the command on a server.
+type cmdResult struct {
+	Server string
+	Status string
+
+	// ExitStatus is -1 when there is no exit status, e.g. unreachable or connection lost.
+	ExitStatus int
+	Signal     string
+	Duration   time.Duration
+	Err        error
+}
+
+// cmdResults collects the results of the command on all servers.
+type cmdResults struct {
+	mu       sync.Mutex
+	results  map[string]*cmdResult
+	conns    map[string]*sshlib.Connect
+	canceled bool
+
+	// sessions are the running sessions, timedOut are the servers terminated by timeout.
+	// expired is true after --total-timeout.
+	sessions map[string]*ssh.Session
+	timedOut map[string]error
+	expired  bool
+
+	// uploads are the servers uploading the script by sftp, which is not the session,
+	// the connection is closed to terminate it.
+	uploads map[string]bool
+
+	// outputs closes the record writers of the server, and waits all lines are printed.
+	outputs map[string]func()
+
+	// buffers are the output of servers in --group-output.
+	buffers map[string]*output.Buffer
+}
+
+func newCmdResults() *cmdResults {
+	return &cmdResults{
+		results: map[string]*cmdResult{}, conns: map[string]*sshlib.Connect{}, outputs: map[string]func(){},
+		sessions: map[string]*ssh.Session{}, timedOut: map[string]error{}, buffers: map[string]*output.Buffer{},
+		uploads: map[string]bool{},
+	}
+}
+
+// addConn adds the connection of server, to close at cancel.
+func (rs *cmdResults) addConn(server string, c *sshlib.Connect) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.conns[server] = c
+}
+
+func (rs *cmdResults) setOutputDone(server string, done func()) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.outputs[server] = done
+}
+
+// newBuffer returns the buffer of server output in --group-output.
+func (rs *cmdResults) newBuffer(server string) *output.Buffer {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	buf := &output.Buffer{}
+	rs.buffers[server] = buf
+
+	return buf
+}
+
+// bufferedOutputs returns the output of servers in --group-output.
+func (rs *cmdResults) bufferedOutputs() map[string]string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	outputs := make(map[string]string, len(rs.buffers))
+	for server, buf := range rs.buffers {
+		outputs[server] = buf.String()
+	}
+
+	return outputs
+}
+
+// outputDone returns the function to close the record writers of server.
+func (rs *cmdResults) outputDone(server string) func() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if done, ok := rs.outputs[server]; ok {
+		return done
+	}
+
+	return func() {}
+}
+
+// add classifies err of the command on server, and returns the result.
+// Connection lost after cancel is `canceled`.
+func (rs *cmdResults) add(server string, duration time.Duration, err error) *cmdResult {
+	res := &cmdResult{Server: server, Status: ResultOK, ExitStatus: -1, Duration: duration, Err: err}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		res.ExitStatus = 0
+	case errors.As(err, &exitErr):
+		res.Status = ResultFailed
+		res.ExitStatus = exitErr.ExitStatus()
+		res.Signal = exitErr.Signal()
+	default:
+		res.Status = ResultFailed
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if timeoutErr, ok := rs.timedOut[server]; ok {
+		res.Status, res.ExitStatus, res.Signal, res.Err = ResultTimeout, -1, "", timeoutErr
+	} else if rs.canceled && res.Status != ResultOK {
+		res.Status, res.ExitStatus, res.Signal, res.Err = ResultCanceled, -1, "", errFailFast
+	}
+
+	rs.results[server] = res
+
+	return res
+}
+
+// addStatus adds the result of server that did not run the command.
+func (rs *cmdResults) addStatus(server, status string, err error) *cmdResult {
+	res := &cmdResult{Server: server, Status: status, ExitStatus: -1, Err: err}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.results[server] = res
+
+	return res
+}
+
+// cancel closes the connection of all servers still running, for --fail-fast.
+func (rs *cmdResults) cancel() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.canceled {
+		return
+	}
+
+	rs.canceled = true
+
+	for server, c := range rs.conns {
+		if _, done := rs.results[server]; !done && c.Client != nil {
+			_ = c.Client.Close()
+		}
+	}
+}
+
+// failedCount returns the number of failed and unreachable servers.
+func (rs *cmdResults) failedCount() (count int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, res := range rs.results {
+		if res.Status == ResultFailed || res.Status == ResultUnreachable || res.Status == ResultTimeout {
+			count++
+		}
+	}
+
+	return count
+}
+
+// skipped returns the status and the reason, if servers not started yet should not run,
+// by --fail-fast or --total-timeout.
+func (rs *cmdResults) skipped() (status string, err error, ok bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	switch {
+	case rs.expired:
+		return ResultTimeout, errTotalTimeout, true
+	case rs.canceled:
+		return ResultCanceled, errFailFast, true
+	}
+
+	return "", nil, false
+}
+
+// watch watches the running session of server, until the returned stop is called.
+// After timeout (0 is unlimited), or --total-timeout expired, the session is terminated.
+func (rs *cmdResults) watch(server string, session *ssh.Session, timeout time.Duration) (stop func()) {
+	rs.mu.Lock()
+	rs.sessions[server] = session
+	rs.mu.Unlock()
+
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			rs.terminate(server, fmt.Errorf("--timeout %s expired", timeout))
+		})
+	}
+
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+
+		rs.mu.Lock()
+		delete(rs.sessions, server)
+		rs.mu.Unlock()
+	}
+}
+
+// terminate terminates the running session of server by timeout.
+func (rs *cmdResults) terminate(server string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	session, ok := rs.sessions[server]
+	if !ok {
+		return
+	}
+
+	rs.timedOut[server] = err
+	rs.terminateServer(server, session)
+}
+
+// terminateServer terminates the session of server, and closes the connection if uploading the script.
+// rs.mu must be held.
+func (rs *cmdResults) terminateServer(server string, session *ssh.Session) {
+	terminateSession(session)
+
+	if c := rs.conns[server]; rs.uploads[server] && c != nil && c.Client != nil {
+		_ = c.Client.Close()
+	}
+}
+
+// uploading marks server uploading the script, until the returned done is called.
+func (rs *cmdResults) uploading(server string) (done func()) {
+	rs.mu.Lock()
+	rs.uploads[server] = true
+	rs.mu.Unlock()
+
+	return func() {
+		rs.mu.Lock()
+		delete(rs.uploads, server)
+		rs.mu.Unlock()
+	}
+}
+
+// expire terminates all running sessions by --total-timeout, and skips servers not started yet.
+func (rs *cmdResults) expire() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.expired = true
+
+	for server, session := range rs.sessions {
+		rs.timedOut[server] = errTotalTimeout
+		rs.terminateServer(server, session)
+	}
+}
+
+// sorted returns the results in order of server name.
+func (rs *cmdResults) sorted() []*cmdResult {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	results := make([]*cmdResult, 0, len(rs.results))
+	for _, res := range rs.results {
+		results = append(results, res)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Server < results[j].Server })
+
+	return results
+}
+
+// exitCode returns the exit code of command mode.
+// With a single server, it is the exit status of remote command like ssh, 124 if timeout like timeout(1).
+// Otherwise 0 if all ok, 255 if some server is unreachable, 1 if some server failed.
+func (rs *cmdResults) exitCode() int {
+	results := rs.sorted()
+
+	if len(results) == 1 {
+		switch res := results[0]; {
+		case res.Status == ResultUnreachable:
+			return exitCodeUnreachable
+		case res.Status == ResultTimeout:
+			return exitCodeTimeout
+		case res.ExitStatus >= 0:
+			return res.ExitStatus
+		case res.Status != ResultOK:
+			return exitCodeFailed
+		}
+	}
+
+	code := 0
+
+	for _, res := range results {
+		switch res.Status {
+		case ResultUnreachable:
+			return exitCodeUnreachable
+		case ResultFailed, ResultCanceled, ResultTimeout:
+			code = exitCodeFailed
+		}
+	}
+
+	return code
+}
+
+// printSummary prints the summary table of results to stderr.
+func (rs *cmdResults) printSummary() {
+	results := rs.sorted()
+	counts := map[string]int{}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stderr)
+	t.AppendHeader(table.Row{"Server", "Status", "Exit", "Duration", "Error"})
+
+	for _, res := range results {
+		counts[res.Status]++
+
+		exit := ""
+		if res.ExitStatus >= 0 {
+			exit = fmt.Sprintf("%d", res.ExitStatus)
+		}
+
+		if res.Signal != "" {
+			exit += " (SIG" + res.Signal + ")"
+		}
+
+		var duration, errText string
+		if res.Duration > 0 {
+			duration = res.Duration.Round(time.Millisecond).String()
+		}
+
+		if res.Err != nil && res.ExitStatus < 0 {
+			errText = res.Err.Error()
+		}
+
+		t.AppendRow(table.Row{res.Server, res.Status, exit, duration, errText})
+	}
+
+	t.Render()
+
+	var summary []string
+	for _, status := range []string{ResultOK, ResultFailed, ResultUnreachable, ResultTimeout, ResultCanceled} {
+		if counts[status] > 0 || (status != ResultTimeout && status != ResultCanceled) {
+			summary = append(summary, fmt.Sprintf("%s %d", status, counts[status]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Summary       :%s\n", strings.Join(summary, ", "))
+}
@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdResultsAdd(t *testing.T) {
+	type TestData struct {
+		desc       string
+		err        error
+		timedOut   error
+		canceled   bool
+		status     string
+		exitStatus int
+		resultErr  error
+	}
+
+	lost := errors.New("connection lost")
+	timeout := errors.New("--timeout 1s expired")
+	exitErr := newExitError(t, 3)
+
+	tds := []TestData{
+		{desc: "OK", status: ResultOK, exitStatus: 0},
+		{desc: "Exit status", err: exitErr, status: ResultFailed, exitStatus: 3, resultErr: exitErr},
+		{desc: "No exit status", err: lost, status: ResultFailed, exitStatus: -1, resultErr: lost},
+		{desc: "Timeout", err: lost, timedOut: timeout, status: ResultTimeout, exitStatus: -1, resultErr: timeout},
+		{desc: "Canceled", err: lost, canceled: true, status: ResultCanceled, exitStatus: -1, resultErr: errFailFast},
+		{desc: "OK before canceled", canceled: true, status: ResultOK, exitStatus: 0},
+	}
+
+	for _, v := range tds {
+		rs := newCmdResults()
+		rs.canceled = v.canceled
+
+		if v.timedOut != nil {
+			rs.timedOut["a"] = v.timedOut
+		}
+
+		res := rs.add("a", time.Second, v.err)
+		assert.Equal(t, v.status, res.Status, v.desc)
+		assert.Equal(t, v.exitStatus, res.ExitStatus, v.desc)
+		assert.Equal(t, v.resultErr, res.Err, v.desc)
+	}
+}
+
+func TestCmdResultsExitCode(t *testing.T) {
+	type TestData struct {
+		desc    string
+		results []*cmdResult
+		expect  int
+	}
+
+	tds := []TestData{
+		{desc: "Single ok", results: []*cmdResult{{Server: "a", Status: ResultOK}}, expect: 0},
+		{desc: "Single exit status", results: []*cmdResult{{Server: "a", Status: ResultFailed, ExitStatus: 3}}, expect: 3},
+		{desc: "Single no exit status", results: []*cmdResult{{Server: "a", Status: ResultFailed, ExitStatus: -1}}, expect: exitCodeFailed},
+		{desc: "Single timeout", results: []*cmdResult{{Server: "a", Status: ResultTimeout, ExitStatus: -1}}, expect: exitCodeTimeout},
+		{desc: "Single unreachable", results: []*cmdResult{{Server: "a", Status: ResultUnreachable, ExitStatus: -1}}, expect: exitCodeUnreachable},
+		{desc: "All ok", results: []*cmdResult{{Server: "a", Status: ResultOK}, {Server: "b", Status: ResultOK}}, expect: 0},
+		{
+			desc:    "Some failed",
+			results: []*cmdResult{{Server: "a", Status: ResultOK}, {Server: "b", Status: ResultFailed, ExitStatus: 3}},
+			expect:  exitCodeFailed,
+		},
+		{
+			desc:    "Some timeout",
+			results: []*cmdResult{{Server: "a", Status: ResultTimeout, ExitStatus: -1}, {Server: "b", Status: ResultOK}},
+			expect:  exitCodeFailed,
+		},
+		{
+			desc:    "Some canceled",
+			results: []*cmdResult{{Server: "a", Status: ResultFailed, ExitStatus: 1}, {Server: "b", Status: ResultCanceled, ExitStatus: -1}},
+			expect:  exitCodeFailed,
+		},
+		{
+			desc: "Unreachable wins",
+			results: []*cmdResult{
+				{Server: "a", Status: ResultFailed, ExitStatus: 1},
+				{Server: "b", Status: ResultUnreachable, ExitStatus: -1},
+			},
+			expect: exitCodeUnreachable,
+		},
+	}
+
+	for _, v := range tds {
+		rs := newCmdResults()
+		for _, res := range v.results {
+			rs.results[res.Server] = res
+		}
+
+		assert.Equal(t, v.expect, rs.exitCode(), v.desc)
+	}
+}
+
+// TestTerminateUpload closes the connection by timeout while uploading the script, the sftp channel is not the session.
+func TestTerminateUpload(t *testing.T) {
+	for _, upload := range []bool{true, false} {
+		c := newEvalHost(t, "web1", 0)
+
+		session, err := c.Connect.Client.NewSession()
+		require.Nil(t, err)
+
+		rs := newCmdResults()
+		rs.addConn("web1", c.Connect)
+
+		stop := rs.watch("web1", session, 0)
+
+		if upload {
+			done := rs.uploading("web1")
+			rs.terminate("web1", errors.New("--timeout 1s expired"))
+			done()
+		} else {
+			rs.terminate("web1", errors.New("--timeout 1s expired"))
+		}
+
+		stop()
+
+		_, err = c.Connect.Client.NewSession()
+		assert.Equal(t, upload, err != nil, "closed only while uploading")
+	}
+}
@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bingoohuang/bssh/sshlib"
+	"github.com/bingoohuang/ngg/ss"
+	"github.com/pkg/sftp"
+)
+
+const (
+	// scriptDirEnv is the environment variable of the directory of script and extra files on the server.
+	scriptDirEnv = "BSSH_SCRIPT_DIR"
+
+	// scriptDecoder decodes base64 stdin on the server, GNU coreutils or BSD (same as localrcShell).
+	scriptDecoder = "if base64 --help 2>&1 | grep -q coreutils; then base64 -d; else base64 -D; fi"
+
+	// scriptCleanup removes the directory $d on exit of the remote shell, also by signal.
+	scriptCleanup = `trap 'rm -rf "$d"' EXIT; trap 'exit 1' HUP INT TERM; `
+
+	// scriptMktemp creates the directory of script and extra files on the server.
+	scriptMktemp = `mktemp -d "${TMPDIR:-/tmp}/bssh-script.XXXXXX"`
+)
+
+// cmdScript is the local script and extra files run on the servers (--script option).
+type cmdScript struct {
+	// interpreter runs the script, the script is not executed directly as /tmp may be mounted noexec.
+	interpreter string
+
+	// files are the script and extra files, the script is first.
+	files []scriptFile
+}
+
+// scriptFile is a file uploaded to the directory of script.
+type scriptFile struct {
+	name string
+	data []byte
+	mode os.FileMode
+}
+
+// loadScript reads the script and extra files of --script and --script-file.
+func (r *Run) loadScript() (*cmdScript, error) {
+	s := &cmdScript{interpreter: r.ScriptInterpreter}
+
+	names := map[string]bool{}
+
+	for i, p := range append([]string{r.Script}, r.ScriptFiles...) {
+		p = ss.ExpandHome(p)
+
+		stat, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if stat.IsDir() {
+			return nil, fmt.Errorf("%s: is a directory", p)
+		}
+
+		name := filepath.Base(p)
+		if names[name] {
+			return nil, fmt.Errorf("%s: duplicate file name %s", p, name)
+		}
+
+		names[name] = true
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := stat.Mode().Perm()
+
+		// the script
+		if i == 0 {
+			mode |= 0o700
+
+			if s.interpreter == "" {
+				s.interpreter = shebangInterpreter(data)
+			}
+		}
+
+		s.files = append(s.files, scriptFile{name: name, data: data, mode: mode})
+	}
+
+	return s, nil
+}
+
+// shebangInterpreter returns the interpreter with its argument in the shebang line of the script, sh if none.
+func shebangInterpreter(data []byte) string {
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return "sh"
+	}
+
+	line, _, _ := bytes.Cut(data[2:], []byte("\n"))
+	if interpreter := strings.TrimSpace(string(line)); interpreter != "" {
+		return interpreter
+	}
+
+	return "sh"
+}
+
+// command returns the command to run the script with args on the server.
+// The files are uploaded to a temporary directory by sftp, or embedded in the command
+// as base64 if sftp is not available. The directory is removed after the script.
+func (s *cmdScript) command(conn *sshlib.Connect, args, decoder string) string {
+	var b strings.Builder
+
+	if dir, err := s.upload(conn); err == nil {
+		fmt.Fprintf(&b, "d=%s; %s", shellQuote(dir), scriptCleanup)
+	} else {
+		if decoder == "" {
+			decoder = scriptDecoder
+		}
+
+		b.WriteString(`d=$(` + scriptMktemp + `) || exit 1; ` + scriptCleanup)
+
+		for _, f := range s.files {
+			fmt.Fprintf(&b, `echo %s | (%s) > "$d/%s" && chmod %o "$d/%s" || exit 1; `,
+				base64.StdEncoding.EncodeToString(f.data), decoder, f.name, f.mode, f.name)
+		}
+	}
+
+	fmt.Fprintf(&b, `%s="$d" `, scriptDirEnv)
+
+	fmt.Fprintf(&b, `%s "$d/%s"`, s.interpreter, s.files[0].name)
+
+	if args != "" {
+		b.WriteString(" " + args)
+	}
+
+	return b.String()
+}
+
+// upload uploads the files by sftp to a new temporary directory created by mktemp like the base64 fallback,
+// and returns the directory.
+func (s *cmdScript) upload(conn *sshlib.Connect) (dir string, err error) {
+	ftp, err := sftp.NewClient(conn.Client)
+	if err != nil {
+		return "", err
+	}
+
+	defer ftp.Close()
+
+	session, err := conn.Client.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := session.Output(scriptMktemp)
+	_ = session.Close()
+
+	if err != nil {
+		return "", err
+	}
+
+	dir = strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("no directory by %s", scriptMktemp)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = ftp.RemoveAll(dir)
+		}
+	}()
+
+	for _, f := range s.files {
+		if err := writeScriptFile(ftp, path.Join(dir, f.name), f); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// writeScriptFile writes f to p on the server.
+func writeScriptFile(ftp *sftp.Client, p string, f scriptFile) error {
+	w, err := ftp.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(f.data); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return ftp.Chmod(p, f.mode)
+}
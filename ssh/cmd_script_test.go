@@ -0,0 +1,61 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShebangInterpreter(t *testing.T) {
+	type TestData struct {
+		desc   string
+		script string
+		expect string
+	}
+
+	tds := []TestData{
+		{desc: "Shebang", script: "#!/bin/bash\necho a\n", expect: "/bin/bash"},
+		{desc: "With argument", script: "#! /bin/sh -e\necho a\n", expect: "/bin/sh -e"},
+		{desc: "Env", script: "#!/usr/bin/env python3\r\nprint(1)\n", expect: "/usr/bin/env python3"},
+		{desc: "Shebang only", script: "#!/bin/bash", expect: "/bin/bash"},
+		{desc: "No shebang", script: "echo a\n", expect: "sh"},
+		{desc: "Empty shebang", script: "#!\necho a\n", expect: "sh"},
+	}
+
+	for _, v := range tds {
+		assert.Equal(t, v.expect, shebangInterpreter([]byte(v.script)), v.desc)
+	}
+}
+
+func TestLoadScript(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	extra := filepath.Join(dir, "app.conf")
+
+	require.Nil(t, os.WriteFile(script, []byte("#!/bin/bash\n"), 0o644))
+	require.Nil(t, os.WriteFile(extra, []byte("a=1\n"), 0o640))
+
+	r := &Run{Script: script, ScriptFiles: []string{extra}}
+	s, err := r.loadScript()
+	require.Nil(t, err)
+
+	assert.Equal(t, "/bin/bash", s.interpreter)
+	assert.Equal(t, []scriptFile{
+		{name: "run.sh", data: []byte("#!/bin/bash\n"), mode: 0o744},
+		{name: "app.conf", data: []byte("a=1\n"), mode: 0o640},
+	}, s.files)
+
+	r.ScriptInterpreter = "bash -x"
+	s, err = r.loadScript()
+	require.Nil(t, err)
+	assert.Equal(t, "bash -x", s.interpreter, "--interpreter")
+
+	r.ScriptFiles = []string{filepath.Join(t.TempDir(), "run.sh")}
+	require.Nil(t, os.WriteFile(r.ScriptFiles[0], nil, 0o644))
+
+	_, err = r.loadScript()
+	assert.NotNil(t, err, "duplicate file name")
+}
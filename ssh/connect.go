@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -25,6 +26,16 @@ func (r *Run) CreateSSHConnect(serverConfig *conf.ServerConfig, server string) (
 		serverConfig = &config
 	}
 
+	// reuse the connection of control master
+	if r.useControl(serverConfig) {
+		c, err := r.controlConnect(serverConfig, server)
+		if err == nil {
+			return c, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "control master of %s: %v, connect directly\n", server, err)
+	}
+
 	// create proxyRoute
 	proxyRoute, err := getProxyRoute(server, r.Conf)
 	if err != nil {
@@ -53,6 +64,10 @@ func (r *Run) CreateSSHConnect(serverConfig *conf.ServerConfig, server string) (
 		default:
 			c, name := findServer(config.Server, p.Name)
 			pxy := &sshlib.Connect{ProxyDialer: dialer}
+			if err := r.setHostKeyChecking(pxy, c); err != nil {
+				return connect, err
+			}
+
 			err := pxy.CreateClient(c.Addr, c.Port, c.User, r.serverAuthMethodMap[name], c.Brg)
 			if err != nil {
 				return connect, err
@@ -106,14 +121,37 @@ func (r *Run) CreateSSHConnect(serverConfig *conf.ServerConfig, server string) (
 		Agent: r.agent, ForwardX11: x11, TTY: r.IsTerm, ConnectTimeout: serverConfig.ConnectTimeout,
 		SendKeepAliveMax: serverConfig.ServerAliveCountMax, SendKeepAliveInterval: serverConfig.ServerAliveCountInterval,
 	}
+	if err = r.setHostKeyChecking(connect, *serverConfig); err != nil {
+		return nil, err
+	}
 
 	if err = connect.CreateClient(serverConfig.Addr, serverConfig.Port, serverConfig.User, r.serverAuthMethodMap[serverConfig.ID], serverConfig.Brg); err != nil && serverConfig.DirectServer {
+		r.connectMu.Lock()
 		r.Conf.WriteTempHosts(serverConfig.ID, server, serverConfig.Pass)
+		r.connectMu.Unlock()
 	}
 
 	return connect, err
 }
 
+// setHostKeyChecking sets the host key checking policy of config to connect.
+// When it can not ask on the terminal (parallel, batch, stdin pipe, background or pshell, whose terminal is
+// read by the prompt), unknown hosts are refused in `ask` policy.
+func (r *Run) setHostKeyChecking(connect *sshlib.Connect, config conf.ServerConfig) error {
+	policy, err := sshlib.NormalizeHostKeyChecking(config.StrictHostKeyChecking)
+	if err != nil || policy == sshlib.HostKeyCheckingNo {
+		return err
+	}
+
+	connect.CheckKnownHosts = true
+	connect.StrictHostKeyChecking = policy
+	connect.KnownHostsFiles = append([]string{}, config.KnownHostsFiles...)
+	connect.NonInteractive = r.IsParallel || r.Concurrency > 0 || r.BatchSize > 0 || r.isStdinPipe ||
+		r.ForwardID != "" || r.controlMaster || r.Mode == "pshell"
+
+	return nil
+}
+
 func findServer(servers map[string]conf.ServerConfig, name string) (conf.ServerConfig, string) {
 	c, ok := servers[name]
 	if !ok {
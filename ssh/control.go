@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/internal/control"
+	"github.com/bingoohuang/bssh/sshlib"
+)
+
+// ControlSock returns the control socket of server.
+func ControlSock(config conf.ServerConfig, server string) string {
+	dir := config.ControlPath
+	if dir == "" {
+		dir = control.DefaultDir
+	}
+
+	return control.SockPath(dir, fmt.Sprintf("%s %s@%s:%s", server, config.User, config.Addr, config.Port))
+}
+
+// controlPersist returns the time to keep the control master without clients.
+func controlPersist(config conf.ServerConfig) (time.Duration, error) {
+	switch config.ControlPersist {
+	case "":
+		return control.DefaultPersist, nil
+	case "0":
+		return 0, nil
+	default:
+		return time.ParseDuration(config.ControlPersist)
+	}
+}
+
+// useControl returns true if the connection of server is multiplexed by the control master.
+// X11 and ssh-agent forwarding open channels from the server, they are not multiplexed.
+func (r *Run) useControl(config *conf.ServerConfig) bool {
+	return config.ControlMaster && !r.controlMaster && !config.DirectServer &&
+		!config.X11 && !r.X11 && !config.SSHAgentUse
+}
+
+// controlConnect returns the connection over the control master of server,
+// the master is started in the background if not running.
+func (r *Run) controlConnect(config *conf.ServerConfig, server string) (*sshlib.Connect, error) {
+	sock := ControlSock(*config, server)
+
+	client, err := control.Dial(sock)
+	if err != nil {
+		if err := control.Start(sock, []string{"-c", r.Conf.ConfPath, "control", "master", server}); err != nil {
+			return nil, err
+		}
+
+		if client, err = control.Dial(sock); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sshlib.Connect{
+		Client: client, TTY: r.IsTerm, ConnectTimeout: config.ConnectTimeout,
+		SendKeepAliveMax: config.ServerAliveCountMax, SendKeepAliveInterval: config.ServerAliveCountInterval,
+	}, nil
+}
+
+// ControlMaster connects to server, and serves the connection on the control socket in the background process
+// started by controlConnect (`bssh control master`), until no clients for control_persist, `bssh control exit`
+// or the connection is lost.
+func (r *Run) ControlMaster(server string) error {
+	config, ok := r.Conf.Server[server]
+	if !ok {
+		return fmt.Errorf("not found server %s", server)
+	}
+
+	persist, err := controlPersist(config)
+	if err != nil {
+		return fmt.Errorf("control_persist %q: %w", config.ControlPersist, err)
+	}
+
+	r.controlMaster = true
+	r.ServerList = []string{server}
+	r.CreateAuthMethodMap()
+
+	connect, err := r.CreateSSHConnect(&config, server)
+	if err != nil {
+		return err
+	}
+
+	go connect.SendClientKeepAlive()
+
+	fmt.Fprintf(os.Stderr, "control master of %s started, pid %d\n", server, os.Getpid())
+
+	err = control.Serve(connect.Client, server, ControlSock(config, server), persist)
+	_ = connect.Client.Close()
+
+	fmt.Fprintf(os.Stderr, "control master of %s exited\n", server)
+
+	return err
+}
@@ -7,7 +7,10 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/bingoohuang/bssh/conf"
 	"github.com/bingoohuang/bssh/output"
@@ -16,11 +19,6 @@ import (
 	"github.com/c-bata/go-prompt/completer"
 )
 
-// TDXX(blacknon): 接続が切れた場合の再接続処理、および再接続ができなかった場合のsliceからの削除対応の追加(v0.6.1)
-// TDXX(blacknon): pShellのログ(実行コマンド及び出力結果)をログとしてファイルに記録する機能の追加(v0.6.1)
-// TDXX(blacknon): グループ化(`()`で囲んだりする)や三項演算子への対応(v0.6.1)
-// TDXX(blacknon): `サーバ名:command...` で、指定したサーバでのみコマンドを実行させる機能の追加(v0.6.1)
-
 // Pshell is Parallel-Shell struct.
 type pShell struct {
 	Signal        chan os.Signal
@@ -31,27 +29,59 @@ type pShell struct {
 	History       map[int]map[string]*pShellHistory
 	HistoryFile   string
 	latestCommand string
-	CmdComplete   []prompt.Suggest
+	CmdComplete   []prompt.Suggest // local commands
 	PathComplete  []prompt.Suggest
 	Options       pShellOption
+
+	// ConfPath is the config file, to save the options changed by `%set` to [shell].
+	ConfPath string
+
+	// Timeout is the deadline of remote command on each server, 0 is unlimited.
+	Timeout time.Duration
+
+	// Groups is the server names of each group, for host selector.
+	Groups map[string][]string
+
+	// Active is the hosts to run remote command set by `%use`, nil is all hosts.
+	// targets is the hosts of `@selector:` while running the command line.
+	Active  []*psConnect
+	targets []*psConnect
+
+	// createConnect connects the server, to reconnect.
+	createConnect func(server string) (*sshlib.Connect, error)
+
+	// Log records the commands and outputs, nil if [log] is disabled.
+	Log *pShellLog
+
+	// canceled is true if the command line is interrupted, not to run the rest.
+	canceled atomic.Bool
+
+	// historyWG waits the outputs are recorded to History.
+	// historyMu guards History written by the outputs of hosts and pipelines.
+	historyWG sync.WaitGroup
+	historyMu sync.Mutex
+
+	// completeOnce starts to refresh the completion cache of hosts.
+	completeOnce sync.Once
+
+	// env is the environment variables of remote commands set by `%export`.
+	env map[string]string
 }
 
 // pShellOption is optitons pshell.
+// The default values are [shell] config, and changed by `%set`.
 type pShellOption struct {
 	// local command実行時の結果をHistoryResultに記録しない(os.Stdoutに直接出す)
 	LocalCommandNotRecordResult bool
 
 	// trueの場合、リモートマシンでパイプライン処理をする際にパイプ経由でもOPROMPTを付与して出力する
-	// RemoteHeaderWithPipe bool
-
-	// trueの場合、リモートマシンにキーインプットを送信しない
-	// hogehoge
+	RemoteHeaderWithPipe bool
 
 	// trueの場合、コマンドの補完処理を無効にする
-	// DisableCommandComplete bool
+	DisableCommandComplete bool
 
-	// trueの場合、PATHの補完処理を無効にする
-	// DisableCommandComplete bool
+	// trueの場合、リモートマシンの出力を同じ出力ごとにまとめてコマンドライン終了後に出力する(%groupと同じ)
+	GroupOutput bool
 }
 
 // psConnect is pShell connect struct.
@@ -59,11 +89,25 @@ type psConnect struct {
 	Name   string
 	Output *output.Output
 	*sshlib.Connect
+
+	// health of the connection, the dead connection is reconnected in background.
+	// retry wakes up the background reconnect.
+	mu      sync.Mutex
+	alive   bool
+	dropped bool
+	err     error
+	retry   chan struct{}
+
+	// cwd is the current directory of the host, empty is the home directory.
+	cwd string
+
+	// complete is the completion cache of the host, refreshed in background.
+	complete psCompleteCache
 }
 
 const (
 	// Default PROMPT.
-	defaultPrompt = "[${COUNT}] <<< "
+	defaultPrompt = "[${COUNT}][${ACTIVE}/${TOTAL}]${DOWN} <<< "
 
 	// Default OPROMPT.
 	defaultOPrompt = "[${SERVER}][${COUNT}] > "
@@ -112,6 +156,18 @@ func (r *Run) pshell() (err error) {
 		PROMPT:      config.Prompt,
 		History:     map[int]map[string]*pShellHistory{},
 		HistoryFile: config.HistoryFile,
+		Timeout:     r.pShellTimeout(config),
+		Options:     newPShellOption(config),
+		ConfPath:    r.Conf.ConfPath,
+		Groups:      r.serverGroups(),
+
+		createConnect: r.createPsConnect(),
+		Log:           r.newPShellLog(),
+	}
+
+	// watch and reconnect the connections in background.
+	for _, c := range cons {
+		go ps.keepConnect(c)
 	}
 
 	// set signal
@@ -128,9 +184,11 @@ func (r *Run) pshell() (err error) {
 		}
 	}
 
-	// create complete data
-	// TDXX(blacknon): 定期的に裏で取得するよう処理を加える(v0.6.1)
-	ps.GetCommandComplete()
+	// create complete data, remote data is refreshed in background.
+	if !ps.Options.DisableCommandComplete {
+		ps.GetCommandComplete()
+		ps.startComplete()
+	}
 
 	// create go-prompt
 	p := prompt.New(
@@ -149,20 +207,56 @@ func (r *Run) pshell() (err error) {
 	return nil
 }
 
+// pShellTimeout returns the deadline of remote command in pshell.
+// Servers run in parallel, so --total-timeout works same as --timeout, the shorter one is used.
+// Without the options, it is the timeout of [shell] config.
+func (r *Run) pShellTimeout(config conf.ShellConfig) time.Duration {
+	if r.TotalTimeout > 0 && (r.Timeout <= 0 || r.TotalTimeout < r.Timeout) {
+		return r.TotalTimeout
+	}
+
+	if r.Timeout <= 0 && config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[shell] timeout: %v\n", err)
+		}
+
+		return timeout
+	}
+
+	return r.Timeout
+}
+
+// serverGroups returns the server names of each group in r.ServerList.
+func (r *Run) serverGroups() map[string][]string {
+	groups := map[string][]string{}
+
+	for _, server := range r.ServerList {
+		for _, g := range r.Conf.Server[server].Group {
+			groups[g] = append(groups[g], server)
+		}
+	}
+
+	return groups
+}
+
+// createPsConnects connects all servers.
+// The servers failed to connect are kept as disconnected, to reconnect in background.
+// It returns nil if no server is connected.
 func (r *Run) createPsConnects(config conf.ShellConfig) []*psConnect {
 	// Connect
 	cons := make([]*psConnect, len(r.ServerList))
+	connected := false
+	createConnect := r.createPsConnect()
 
 	for i, server := range r.ServerList {
-		con, err := r.CreateSSHConnect(nil, server)
+		con, err := createConnect(server)
 		if err != nil {
 			log.Println(err)
-			continue
+		} else {
+			connected = true
 		}
 
-		// TTY enable
-		con.TTY = true
-
 		// Create Output
 		o := &output.Output{
 			Templete:   config.OPrompt,
@@ -174,14 +268,20 @@ func (r *Run) createPsConnects(config conf.ShellConfig) []*psConnect {
 		// Create output prompt
 		o.Create(server)
 
-		cons[i] = &psConnect{Name: server, Output: o, Connect: con}
+		cons[i] = &psConnect{Name: server, Output: o, Connect: con, alive: err == nil, err: err, retry: make(chan struct{}, 1)}
+	}
+
+	if !connected {
+		return nil
 	}
 
 	return cons
 }
 
 // CreatePrompt is create shell prompt.
-// default value is `[${COUNT}] <<< `.
+// default value is `[${COUNT}][${ACTIVE}/${TOTAL}]${DOWN} <<< `.
+// ${ACTIVE} is the number of connected hosts to run remote command, ${TOTAL} is the number of all hosts,
+// ${DOWN} is the disconnected hosts like `(down:web2)`, empty if all connected.
 func (ps *pShell) CreatePrompt() (p string, result bool) {
 	// set prompt template (from conf)
 	p = ps.PROMPT
@@ -196,6 +296,21 @@ func (ps *pShell) CreatePrompt() (p string, result bool) {
 
 	// replace variable value
 	p = strings.Replace(p, "${COUNT}", strconv.Itoa(ps.Count), -1)
+	active := 0
+	for _, c := range ps.activeConnects() {
+		if alive, _ := c.state(); alive {
+			active++
+		}
+	}
+
+	down := ""
+	if names := ps.disconnected(); len(names) > 0 {
+		down = "(down:" + strings.Join(names, ",") + ")"
+	}
+
+	p = strings.Replace(p, "${ACTIVE}", strconv.Itoa(active), -1)
+	p = strings.Replace(p, "${TOTAL}", strconv.Itoa(len(ps.Connects)), -1)
+	p = strings.Replace(p, "${DOWN}", down, -1)
 	p = strings.Replace(p, "${HOSTNAME}", hostname, -1)
 	p = strings.Replace(p, "${USER}", username, -1)
 	p = strings.Replace(p, "${PWD}", pwd, -1)
@@ -0,0 +1,311 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+)
+
+const (
+	// completeRefreshInterval is the interval to refresh the commands of host in background.
+	completeRefreshInterval = 5 * time.Minute
+
+	// completeRetryInterval is the interval to retry, the host is disconnected or failed.
+	completeRetryInterval = 10 * time.Second
+
+	// completePathTTL is the time to use the directory entries without refresh.
+	// The stale entries are used while refreshing in background.
+	completePathTTL = 30 * time.Second
+
+	// completePathTimeout is the time to wait the directory entries not cached.
+	completePathTimeout = 2 * time.Second
+)
+
+// psCompleteCache is the completion cache of a host, the commands and directory entries.
+type psCompleteCache struct {
+	mu       sync.Mutex
+	commands map[string]bool // nil until loaded
+	paths    map[string]*psPathCache
+}
+
+// psPathCache is the entries of a remote directory.
+// done is closed when the entries are loaded first.
+type psPathCache struct {
+	names   []string
+	err     error
+	loaded  bool
+	loading bool
+	updated time.Time
+	done    chan struct{}
+}
+
+// startComplete starts to refresh the completion cache of hosts in background, once.
+func (ps *pShell) startComplete() {
+	ps.completeOnce.Do(func() {
+		for _, c := range ps.Connects {
+			go c.keepComplete()
+		}
+	})
+}
+
+// keepComplete refreshes the commands of the host periodically, until the host is dropped.
+func (c *psConnect) keepComplete() {
+	for {
+		c.mu.Lock()
+		dropped := c.dropped
+		c.mu.Unlock()
+
+		if dropped {
+			return
+		}
+
+		interval := completeRefreshInterval
+		if err := c.refreshCommands(); err != nil {
+			interval = completeRetryInterval
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// refreshCommands gets the commands of the host by `compgen -c` of bash, the login shell may not be bash.
+func (c *psConnect) refreshCommands() error {
+	session, err := c.session()
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	session.Stdout = buf
+
+	if err := session.Run("bash -c 'compgen -c'"); err != nil {
+		return err
+	}
+
+	commands := map[string]bool{}
+
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		commands[sc.Text()] = true
+	}
+
+	c.complete.mu.Lock()
+	c.complete.commands = commands
+	c.complete.mu.Unlock()
+
+	return nil
+}
+
+// expireComplete marks the directory entries of hosts stale, the files may be changed by the command.
+func (ps *pShell) expireComplete() {
+	for _, c := range ps.Connects {
+		c.complete.mu.Lock()
+		for _, p := range c.complete.paths {
+			p.updated = time.Time{}
+		}
+		c.complete.mu.Unlock()
+	}
+}
+
+// remoteCommandSuggests returns the commands of the active hosts with prefix word.
+// The description shows the hosts lack the command.
+func (ps *pShell) remoteCommandSuggests(word string) []prompt.Suggest {
+	found := map[string][]string{}
+
+	var hosts []string
+
+	for _, c := range ps.activeConnects() {
+		c.complete.mu.Lock()
+		if c.complete.commands != nil {
+			hosts = append(hosts, c.Name)
+
+			for cmd := range c.complete.commands {
+				if strings.HasPrefix(cmd, word) {
+					found[cmd] = append(found[cmd], c.Name)
+				}
+			}
+		}
+		c.complete.mu.Unlock()
+	}
+
+	return suggestsOnHosts(found, hosts, "Command. from:")
+}
+
+// remotePathSuggests returns the entries of the directory of word on the active hosts.
+// The description shows the hosts lack the path.
+func (ps *pShell) remotePathSuggests(word string) []prompt.Suggest {
+	dir, base := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+
+	connects := ps.activeConnects()
+
+	type result struct {
+		names []string
+		ok    bool
+	}
+
+	results := make([]result, len(connects))
+
+	var wg sync.WaitGroup
+
+	for i, c := range connects {
+		wg.Add(1)
+
+		go func(i int, c *psConnect) {
+			defer wg.Done()
+
+			names, ok := c.dirEntries(c.remotePath(dir))
+			results[i] = result{names, ok}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	found := map[string][]string{}
+
+	var hosts []string
+
+	for i, c := range connects {
+		if !results[i].ok {
+			continue
+		}
+
+		hosts = append(hosts, c.Name)
+
+		for _, name := range results[i].names {
+			// hidden files only if the word is.
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+				continue
+			}
+
+			found[name] = append(found[name], c.Name)
+		}
+	}
+
+	return suggestsOnHosts(found, hosts, "remote path. from:")
+}
+
+// suggestsOnHosts returns the suggests found on the hosts, with the hosts lack it.
+func suggestsOnHosts(found map[string][]string, hosts []string, description string) []prompt.Suggest {
+	suggests := make([]prompt.Suggest, 0, len(found))
+
+	for text, on := range found {
+		d := description + "all hosts"
+
+		if len(on) < len(hosts) {
+			has := map[string]bool{}
+			for _, h := range on {
+				has[h] = true
+			}
+
+			var missing []string
+
+			for _, h := range hosts {
+				if !has[h] {
+					missing = append(missing, h)
+				}
+			}
+
+			d = description + strings.Join(on, ",") + " missing:" + strings.Join(missing, ",")
+		}
+
+		suggests = append(suggests, prompt.Suggest{Text: text, Description: d})
+	}
+
+	sort.Slice(suggests, func(i, j int) bool { return suggests[i].Text < suggests[j].Text })
+
+	return suggests
+}
+
+// dirEntries returns the names in the remote directory from the cache, ok is false if not loaded or failed.
+// The stale cache is refreshed in background, the directory not cached is waited up to completePathTimeout.
+func (c *psConnect) dirEntries(dir string) (names []string, ok bool) {
+	cc := &c.complete
+
+	cc.mu.Lock()
+
+	if cc.paths == nil {
+		cc.paths = map[string]*psPathCache{}
+	}
+
+	p, cached := cc.paths[dir]
+	if !cached {
+		p = &psPathCache{done: make(chan struct{})}
+		cc.paths[dir] = p
+	}
+
+	if !p.loading && time.Since(p.updated) > completePathTTL {
+		p.loading = true
+
+		go c.loadDir(dir, p)
+	}
+
+	done := p.done
+	cc.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(completePathTimeout):
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return p.names, p.loaded && p.err == nil
+}
+
+// loadDir reads the remote directory by sftp, to the cache.
+func (c *psConnect) loadDir(dir string, p *psPathCache) {
+	names, err := c.readDir(dir)
+
+	c.complete.mu.Lock()
+	defer c.complete.mu.Unlock()
+
+	// the failure is also kept for completePathTTL, not to read the directory again on every key.
+	p.loading = false
+	p.err, p.updated = err, time.Now()
+
+	if err == nil {
+		p.names = names
+	}
+
+	if !p.loaded {
+		p.loaded = true
+		close(p.done)
+	}
+}
+
+// readDir returns the names in the remote directory, nothing if it does not exist.
+func (c *psConnect) readDir(dir string) ([]string, error) {
+	ftp, err := c.sftp()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ftp.Close()
+
+	infos, err := ftp.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+
+	return names, nil
+}
@@ -0,0 +1,35 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDirEntriesFailed keeps the failure of reading the directory for completePathTTL, not to read it on every key.
+func TestDirEntriesFailed(t *testing.T) {
+	// the server runs no sftp subsystem.
+	c := newEvalHost(t, "web1", 0)
+
+	names, ok := c.dirEntries("/var/log")
+	assert.Empty(t, names)
+	assert.False(t, ok)
+
+	updated := func() time.Time {
+		c.complete.mu.Lock()
+		defer c.complete.mu.Unlock()
+
+		return c.complete.paths["/var/log"].updated
+	}
+
+	first := updated()
+	require.False(t, first.IsZero(), "the failure time is recorded")
+
+	_, ok = c.dirEntries("/var/log")
+	assert.False(t, ok)
+
+	assert.Never(t, func() bool { return !updated().Equal(first) }, 200*time.Millisecond, 10*time.Millisecond,
+		"not read again in completePathTTL")
+}
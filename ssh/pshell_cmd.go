@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bingoohuang/bssh/misc"
@@ -19,16 +20,6 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// TDXX(blacknon): 以下のBuild-in Commandを追加する
-//     - %cd <PATH>         ... リモートのディレクトリを変更する(事前のチェックにsftpを使用か？)
-//     - %lcd <PATH>        ... ローカルのディレクトリを変更する
-//     - %save <num> <PATH> ... 指定したnumの履歴をPATHに記録する (v0.6.1)
-//     - %set <args..>      ... 指定されたオプションを設定する(Optionsにて管理) (v0.6.1)
-//     - %diff <num>        ... 指定されたnumの履歴をdiffする(multi diff)。できるかどうか要検討。 (v0.6.1以降)
-//                              できれば、vimdiffのように横に差分表示させるようにしたいものだけど…？
-//     - %get remote local  ... sftpプロトコルを利用して、ファイルやディレクトリを取得する (v0.6.1)
-//     - %put local remote  ... sftpプロトコルを利用して、ファイルやディレクトリを配置する (v0.6.1)
-
 // checkBuildInCommand return true if cmd is build-in command.
 func checkBuildInCommand(cmd string) (isBuildInCmd bool) {
 	// check build-in command
@@ -38,7 +29,10 @@ func checkBuildInCommand(cmd string) (isBuildInCmd bool) {
 
 	case
 		"%history",
-		misc.PercentOut, "%outlist",
+		misc.PercentOut, "%outlist", "%group", "%diff",
+		"%use", "%all", "%hosts", "%reconnect", "%drop",
+		"%get", "%put",
+		"%cd", "%lcd", "%export",
 		"%save", "%set": // parsent build-in command.
 		isBuildInCmd = true
 	}
@@ -70,7 +64,8 @@ func checkLocalBuildInCommand(cmd string) (result bool) {
 }
 
 // runBuildInCommand is run buildin or local machine command.
-func (ps *pShell) run(pl pipeLine, in io.Reader, out *io.PipeWriter, ch chan<- bool, kill chan bool) {
+// st is the exit status of the pipeline if pl is the last command, otherwise nil.
+func (ps *pShell) run(pl pipeLine, in io.Reader, out *io.PipeWriter, ch chan<- bool, kill chan bool, st *pipeStatus) {
 	// get 1st element
 	command := pl.Args[0]
 
@@ -111,13 +106,83 @@ func (ps *pShell) run(pl pipeLine, in io.Reader, out *io.PipeWriter, ch chan<- b
 		ps.buildinOut(num, out, ch)
 
 		return
+
+	// %group [num]
+	case "%group":
+		ps.buildinGroup(pl.Args[1:], out, ch)
+		return
+
+	// %diff [num] [host]
+	case "%diff":
+		ps.buildinDiff(pl.Args[1:], out, ch)
+		return
+
+	// %use <selector>
+	case "%use":
+		ps.buildinUse(pl.Args[1:], out, ch)
+		return
+
+	// %all
+	case "%all":
+		ps.buildinAll(out, ch)
+		return
+
+	// %hosts
+	case "%hosts":
+		ps.buildinHosts(out, ch)
+		return
+
+	// %reconnect [selector]
+	case "%reconnect":
+		ps.buildinReconnect(pl.Args[1:], out, ch)
+		return
+
+	// %drop <selector>
+	case "%drop":
+		ps.buildinDrop(pl.Args[1:], out, ch)
+		return
+
+	// %get <remote...> <local>
+	case "%get":
+		ps.buildinGet(pl.Args[1:], out, ch)
+		return
+
+	// %put <local...> <remote>
+	case "%put":
+		ps.buildinPut(pl.Args[1:], out, ch)
+		return
+
+	// %set [name value]
+	case "%set":
+		ps.buildinSet(pl.Args[1:], out, ch)
+		return
+
+	// %save <num> <path>
+	case "%save":
+		ps.buildinSave(pl.Args[1:], out, ch)
+		return
+
+	// %cd [dir]
+	case "%cd":
+		ps.buildinCd(pl.Args[1:], out, ch)
+		return
+
+	// %lcd [dir]
+	case "%lcd":
+		ps.buildinLcd(pl.Args[1:], out, ch)
+		return
+
+	// %export [NAME=VALUE...]
+	case "%export":
+		ps.buildinExport(pl.Args[1:], out, ch)
+		return
 	}
 
 	// check and exec local command
 	if regexp.MustCompile(`^!.*`).MatchString(command) {
-		ps.executeLocalPipeLine(pl, in, out, ch, kill)
+		ps.executeLocalPipeLine(pl, in, out, ch, kill, st)
 	} else {
-		ps.executeRemotePipeLine(pl, in, out, ch, kill)
+		ps.executeRemotePipeLine(pl, in, out, ch, kill, st)
 	}
 }
 
@@ -195,13 +260,92 @@ func (ps *pShell) buildinOut(num int, out *io.PipeWriter, ch chan<- bool) {
 				_, _ = fmt.Fprintf(stdout, "%s %s\n", op, sc.Text())
 			}
 
+			if h.Status == ResultTimeout {
+				_, _ = fmt.Fprintf(stdout, "%s [%s]\n", op, h.Status)
+			}
+
 			// reset Output.Count
 			h.Output.Count = bc
 		} else {
-			_, _ = fmt.Fprintf(stdout, h.Result)
+			_, _ = fmt.Fprint(stdout, h.Result)
+
+			if h.Status == ResultTimeout {
+				_, _ = fmt.Fprintf(stdout, "[%s]\n", h.Status)
+			}
+		}
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinGroup is print exec history at number, each distinct output once
+// with the servers that produced it (like dshbak -c).
+// example:
+//   - %group
+//   - %group <num>
+func (ps *pShell) buildinGroup(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	num := ps.Count - 1
+
+	var err error
+	if len(args) == 1 {
+		num, err = strconv.Atoi(args[0])
+	}
+
+	switch {
+	case len(args) > 1:
+		fmt.Fprintf(stdout, "usage: %%group [num]\n")
+	case err != nil:
+		fmt.Fprintf(stdout, "%%group: invalid history number %q\n", args[0])
+	default:
+		output.PrintGroups(stdout, output.GroupOutputs(ps.historyOutputs(num)))
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinDiff is print unified diffs of exec history at number, from the baseline host
+// to the hosts that deviate. The baseline is the output of most hosts, or the named host.
+// example:
+//   - %diff
+//   - %diff <num>
+//   - %diff [num] <host>
+func (ps *pShell) buildinDiff(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	num := ps.Count - 1
+	server := ""
+
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			num = n
+		} else {
+			server = arg
 		}
 	}
 
+	groups := output.GroupOutputs(ps.historyOutputs(num))
+	if len(groups) == 0 {
+		fmt.Fprintf(stdout, "%%diff: no history %d\n", num)
+	} else if baseline, deviations, err := output.Baseline(groups, server); err != nil {
+		fmt.Fprintf(stdout, "%%diff: %v\n", err)
+	} else {
+		output.PrintDiffs(stdout, baseline, deviations)
+	}
+
 	// close out
 	if _, ok := stdout.(*io.PipeWriter); ok {
 		_ = out.CloseWithError(io.ErrClosedPipe)
@@ -211,11 +355,34 @@ func (ps *pShell) buildinOut(num int, out *io.PipeWriter, ch chan<- bool) {
 	ch <- true
 }
 
+// historyOutputs returns the output of each host in exec history at number, and prints the command.
+func (ps *pShell) historyOutputs(num int) map[string]string {
+	outputs := map[string]string{}
+
+	i := 0
+	for server, h := range ps.History[num] {
+		// if first, print out command
+		if i == 0 {
+			fmt.Fprintf(os.Stderr, "[History:%s ]\n", h.Command)
+		}
+		i++
+
+		result := h.Result
+		if h.Status == ResultTimeout {
+			result += "[" + h.Status + "]\n"
+		}
+
+		outputs[server] = result
+	}
+
+	return outputs
+}
+
 // executePipeLineRemote is exec command in remote machine.
 // Didn't know how to send data from Writer to Channel, so switch the function if * io.PipeWriter is Nil.
 
 func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.PipeWriter,
-	ch chan<- bool, kill chan bool,
+	ch chan<- bool, kill chan bool, st *pipeStatus,
 ) {
 	// join command
 	command := strings.Join(pline.Args, " ")
@@ -228,15 +395,25 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 	exit := make(chan bool)
 	exitInput := make(chan bool) // Input finish channel
 
-	writers := make([]io.WriteCloser, len(ps.Connects))
-	sessions := make([]*ssh.Session, len(ps.Connects))
+	// hosts to run command
+	connects := ps.activeConnects()
+
+	writers := make([]io.WriteCloser, 0, len(connects))
+	sessions := make([]*ssh.Session, len(connects))
+	histories := make([]*pShellHistory, len(connects))
+	closers := make([][]pipeCloser, len(connects))
 
 	// create session and writers
-	m := new(sync.Mutex)
+	hm := new(sync.Mutex)           // lines of header writers
+	printers := new(sync.WaitGroup) // printers of the output writers
 
-	for i, c := range ps.Connects {
-		s, err := c.CreateSession()
+	for i, c := range connects {
+		s, err := c.session()
 		if err != nil {
+			c.Output.Count = ps.Count
+			printDisconnected(c, err)
+			st.set(c, exitCodeUnreachable)
+
 			continue
 		}
 
@@ -249,17 +426,38 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 		var ow io.Writer
 
 		ow = stdout
-		if ow == os.Stdout {
-			// create Output Writer
-			c.Output.Count = ps.Count
-			w := c.Output.NewWriter()
+		c.Output.Count = ps.Count
+
+		switch {
+		case ow == os.Stdout && ps.Options.GroupOutput:
+			// print after the command line, only record pShellHistory
+			hw, psh := ps.NewHistoryWriter(c.Output.Server, c.Output)
+
+			ow = hw
+			histories[i] = psh
+			closers[i] = []pipeCloser{hw}
+		case ow == os.Stdout:
+			// create Output Writer, printed until it is closed.
+			r, w := io.Pipe()
+
+			printers.Add(1)
+
+			go func(o *output.Output) {
+				defer printers.Done()
+				o.Printer(r)
+			}(c.Output)
 
 			// create pShellHistory Writer
-			hw := ps.NewHistoryWriter(c.Output.Server, c.Output, m)
+			hw, psh := ps.NewHistoryWriter(c.Output.Server, c.Output)
 
 			ow = io.MultiWriter(w, hw)
-			_ = w.CloseWithError(io.ErrClosedPipe)
-			_ = hw.CloseWithError(io.ErrClosedPipe)
+			histories[i] = psh
+			closers[i] = []pipeCloser{w, hw}
+		case ps.Options.RemoteHeaderWithPipe:
+			hw := &headerWriter{w: stdout, header: c.Output.GetPrompt(), mu: hm}
+
+			ow = hw
+			closers[i] = []pipeCloser{hw}
 		}
 
 		s.Stdout = ow
@@ -267,7 +465,7 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 		// get and append stdin writer
 		w, _ := s.StdinPipe()
 
-		writers[i] = w
+		writers = append(writers, w)
 		sessions[i] = s
 	}
 
@@ -284,13 +482,40 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 	}
 
 	// run command
-	for _, s := range sessions {
-		session := s
+	for i, s := range sessions {
+		if s == nil {
+			go func() { exit <- true }()
+			continue
+		}
+
+		i, session := i, s
 
 		go func() {
-			_ = session.Run(command)
-			//_ = session.Close()
-			exit <- true
+			defer func() { exit <- true }()
+
+			var timedOut atomic.Bool
+			if ps.Timeout > 0 {
+				timer := time.AfterFunc(ps.Timeout, func() {
+					timedOut.Store(true)
+					fmt.Fprintf(os.Stderr, "%s timeout after %s, terminate.\n", connects[i].Output.GetPrompt(), ps.Timeout)
+					terminateSession(session)
+				})
+				defer timer.Stop()
+			}
+
+			err := session.Run(ps.remoteCommand(connects[i], command))
+
+			// record the result status to history, before closing the history writer.
+			status, exitStatus := pShellResultStatus(err, timedOut.Load())
+			if psh := histories[i]; psh != nil {
+				psh.Status, psh.ExitStatus = status, exitStatus
+			}
+
+			st.set(connects[i], pShellExitCode(status, exitStatus))
+
+			for _, w := range closers[i] {
+				_ = w.CloseWithError(io.ErrClosedPipe)
+			}
 		}()
 	}
 
@@ -298,15 +523,17 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 		<-kill
 
 		for _, s := range sessions {
-			_ = s.Signal(ssh.SIGINT)
-			_ = s.Close()
+			if s != nil {
+				_ = s.Signal(ssh.SIGINT)
+				_ = s.Close()
+			}
 		}
 	}()
 
 	wait(len(sessions), exit)
 
-	// wait time (0.500 sec)
-	time.Sleep(5000 * time.Millisecond)
+	// wait the outputs are printed, the output writers are closed after each session.
+	printers.Wait()
 
 	// Print message `Please input enter` (Only when input is os.Stdin and output is os.Stdout).
 	// Note: This necessary for using Blocking.IO.
@@ -327,7 +554,7 @@ func (ps *pShell) executeRemotePipeLine(pline pipeLine, in io.Reader, out *io.Pi
 // executePipeLineLocal is exec command in local machine.
 // TDXX(blacknon): 利用中のShellでの実行+functionや環境変数、aliasの引き継ぎを行えるように実装.
 func (ps *pShell) executeLocalPipeLine(pline pipeLine, in io.Reader, out *io.PipeWriter,
-	ch chan<- bool, kill chan bool,
+	ch chan<- bool, kill chan bool, st *pipeStatus,
 ) {
 	// set stdin/stdout
 	stdin := setInput(in)
@@ -336,10 +563,8 @@ func (ps *pShell) executeLocalPipeLine(pline pipeLine, in io.Reader, out *io.Pip
 	// set HistoryResult
 	var stdoutw io.Writer
 
-	m := new(sync.Mutex)
-
 	if stdout == os.Stdout {
-		pw := ps.NewHistoryWriter("localhost", nil, m)
+		pw, _ := ps.NewHistoryWriter("localhost", nil)
 
 		defer func() { _ = pw.CloseWithError(io.ErrClosedPipe) }()
 
@@ -378,6 +603,13 @@ func (ps *pShell) executeLocalPipeLine(pline pipeLine, in io.Reader, out *io.Pip
 	// wait command
 	_ = cmd.Wait()
 
+	// the exit status of local command is the status of all hosts.
+	if code := cmd.ProcessState.ExitCode(); code >= 0 {
+		st.setAll(code)
+	} else {
+		st.setAll(exitCodeFailed)
+	}
+
 	// close out, or write pShellHistory
 	if _, ok := stdout.(*io.PipeWriter); ok {
 		_ = out.CloseWithError(io.ErrClosedPipe)
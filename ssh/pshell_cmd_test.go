@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildinGroup(t *testing.T) {
+	type TestData struct {
+		desc   string
+		args   []string
+		expect string
+	}
+
+	tds := []TestData{
+		{desc: "Last history", args: nil, expect: "a,b (2)"},
+		{desc: "History number", args: []string{"0"}, expect: "a,b (2)"},
+		{desc: "Invalid history number", args: []string{"foo"}, expect: `%group: invalid history number "foo"`},
+		{desc: "Too many args", args: []string{"0", "1"}, expect: "usage: %group [num]"},
+	}
+
+	for _, v := range tds {
+		ps := &pShell{Count: 1, History: map[int]map[string]*pShellHistory{
+			0: {"a": {Command: "echo ok", Result: "ok\n"}, "b": {Command: "echo ok", Result: "ok\n"}},
+		}}
+
+		r, w := io.Pipe()
+		ch := make(chan bool, 1)
+
+		go ps.buildinGroup(v.args, w, ch)
+
+		data, _ := io.ReadAll(r)
+		assert.Contains(t, string(data), v.expect, v.desc)
+
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: exit is not sent", v.desc)
+		}
+	}
+}
+
+func TestBuildinSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bssh.toml")
+	assert.Nil(t, os.WriteFile(path, []byte("[shell]\ngroup = false\n"), 0o600))
+
+	type TestData struct {
+		desc   string
+		args   []string
+		expect string
+		saved  string
+	}
+
+	tds := []TestData{
+		{desc: "Set bool", args: []string{"group", "on"}, expect: "group = true, saved", saved: "group = true\n"},
+		{desc: "Set timeout", args: []string{"timeout", "30s"}, expect: "timeout = 30s, saved", saved: `timeout = "30s"`},
+		{desc: "Invalid value", args: []string{"group", "x"}, expect: "%set: group: invalid value", saved: "group = true\n"},
+		{desc: "Unknown option", args: []string{"foo", "1"}, expect: `%set: unknown option "foo"`},
+		{desc: "Print", args: nil, expect: "group = true # "},
+	}
+
+	ps := &pShell{ConfPath: path}
+
+	for _, v := range tds {
+		r, w := io.Pipe()
+		ch := make(chan bool, 1)
+
+		go ps.buildinSet(v.args, w, ch)
+
+		data, _ := io.ReadAll(r)
+		<-ch
+
+		assert.Contains(t, string(data), v.expect, v.desc)
+
+		saved, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Contains(t, string(saved), v.saved, v.desc)
+	}
+}
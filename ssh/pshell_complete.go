@@ -6,13 +6,11 @@ package ssh
 
 import (
 	"bufio"
-	"bytes"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/bingoohuang/bssh/misc"
 	"github.com/bingoohuang/ngg/ss"
@@ -32,6 +30,17 @@ func (ps *pShell) Completer(t prompt.Document) []prompt.Suggest {
 
 	// Get cursor left
 	left := t.CurrentLineBeforeCursor()
+
+	// host selector (`@web1,web3: command`)
+	if strings.HasPrefix(left, "@") {
+		_, rest, ok := splitHostSelector(left)
+		if !ok {
+			return ps.hostSuggests(t.GetWordBeforeCursor(), "@")
+		}
+
+		left = rest
+	}
+
 	pslice, err := parsePipeLine(left)
 	if err != nil {
 		return prompt.FilterHasPrefix(nil, t.GetWordBeforeCursor(), false)
@@ -62,25 +71,50 @@ func (ps *pShell) Completer(t prompt.Document) []prompt.Suggest {
 				{Text: "%history", Description: "show history"},
 				{Text: misc.PercentOut, Description: "%out [num], show history result."},
 				{Text: "%outlist", Description: "%outlist, show history result list."},
-				// outの出力でdiffをするためのローカルコマンド。すべての出力と比較するのはあまりに辛いと思われるため、最初の出力との比較、といった方式で対応するのが良いか？？
-				// {Text: "%diff", Description: "%diff [num], show history result list."},
+				{Text: "%group", Description: "%group [num], show history result grouped by the same output."},
+				{Text: "%use", Description: "%use <host|glob|group>[,...], run remote command on the hosts."},
+				{Text: "%all", Description: "%all, run remote command on all hosts."},
+				{Text: "%hosts", Description: "%hosts, show hosts, `*` is the active host."},
+				{Text: "%reconnect", Description: "%reconnect [host|glob|group], reconnect the disconnected hosts now."},
+				{Text: "%drop", Description: "%drop <host|glob|group>[,...], close and remove the hosts."},
+				{Text: "%get", Description: "%get <remote...> <local>, get files from the hosts into <local>/<server>/."},
+				{Text: "%put", Description: "%put <local...> <remote>, put files to the hosts."},
+				{Text: "%diff", Description: "%diff [num] [host], show diffs of history result from the majority (or host) output."},
+				{Text: "%set", Description: "%set [name value], show or set the options."},
+				{Text: "%save", Description: "%save <num> <path>, save history result to the file (json if *.json)."},
+				{Text: "%cd", Description: "%cd [dir], change the directory of remote commands."},
+				{Text: "%lcd", Description: "%lcd [dir], change the directory of local commands."},
+				{Text: "%export", Description: "%export [NAME=VALUE...], set the environment variables of remote commands."},
 			}
 
 			// get remote and local command complete data
-			c = append(c, ps.CmdComplete...)
+			if !ps.Options.DisableCommandComplete {
+				ps.startComplete()
+
+				c = append(c, ps.CmdComplete...)
+				c = append(c, ps.remoteCommandSuggests(t.GetWordBeforeCursor())...)
+			}
 
 			// return
 			return prompt.FilterHasPrefix(c, t.GetWordBeforeCursor(), false)
 
-		case checkBuildInCommand(c): // if build-in command.
+		case checkBuildInCommand(c) && !ss.AnyOf(c, "%get", "%put", "%save", "%cd", "%lcd"): // if build-in command.
 			return ps.buildinSuggests(c, t)
 
+		case ps.Options.DisableCommandComplete:
+			return nil
+
 		default:
+			// %put, %save and %lcd complete local path.
+			remote := !checkLocalCommand(c) && !ss.AnyOf(c, "%put", "%save", "%lcd")
+
 			switch {
+			case remote: // from the cache
+				ps.PathComplete = ps.GetPathComplete(remote, t.GetWordBeforeCursor())
 			case ss.AnyOf(char, "/"): // char is slach or
-				ps.PathComplete = ps.GetPathComplete(!checkLocalCommand(c), t.GetWordBeforeCursor())
+				ps.PathComplete = ps.GetPathComplete(remote, t.GetWordBeforeCursor())
 			case ss.AnyOf(char, " ") && strings.Count(t.CurrentLineBeforeCursor(), " ") == 1:
-				ps.PathComplete = ps.GetPathComplete(!checkLocalCommand(c), t.GetWordBeforeCursor())
+				ps.PathComplete = ps.GetPathComplete(remote, t.GetWordBeforeCursor())
 			}
 
 			// get last slash place
@@ -101,7 +135,17 @@ func (ps *pShell) Completer(t prompt.Document) []prompt.Suggest {
 func (ps *pShell) buildinSuggests(c string, t prompt.Document) []prompt.Suggest {
 	var a []prompt.Suggest
 
-	if c == misc.PercentOut {
+	if c == "%use" || c == "%reconnect" || c == "%drop" {
+		return ps.hostSuggests(t.GetWordBeforeCursor(), "")
+	}
+
+	if c == "%set" {
+		for _, o := range psOptions {
+			a = append(a, prompt.Suggest{Text: o.name, Description: o.description})
+		}
+	}
+
+	if c == misc.PercentOut || c == "%group" || c == "%diff" {
 		for i := 0; i < len(ps.History); i++ {
 			var cmd string
 			for _, h := range ps.History[i] {
@@ -128,9 +172,8 @@ func getCursorChar(left string) string {
 	return string(left[len(left)-1])
 }
 
-// GetCommandComplete get command list remote machine.
-// mode ... command/path.
-// data ... Value being entered.
+// GetCommandComplete get command list local machine.
+// The commands of remote machine are cached and refreshed in background (see startComplete).
 func (ps *pShell) GetCommandComplete() {
 	// bash complete command. use `compgen`.
 	compCmd := []string{"compgen", "-c"}
@@ -149,58 +192,19 @@ func (ps *pShell) GetCommandComplete() {
 		ps.CmdComplete = append(ps.CmdComplete, suggest)
 	}
 
-	// get remote machine command complete
-	// command map
-	cmdMap := map[string][]string{}
-
-	// append command to cmdMap
-	for _, c := range ps.Connects {
-		// Create buffer
-		buf := new(bytes.Buffer)
-
-		// Create session, and output to buffer
-		session, _ := c.CreateSession()
-		session.Stdout = buf
-
-		// Run get complete command
-		_ = session.Run(command)
-
-		// Scan and put completed command to map.
-		sc := bufio.NewScanner(buf)
-		for sc.Scan() {
-			cmdMap[sc.Text()] = append(cmdMap[sc.Text()], c.Name)
-		}
-	}
-
-	// cmdMap to suggest
-	for cmd, hosts := range cmdMap {
-		// join hosts
-		sort.Strings(hosts)
-		h := strings.Join(hosts, ",")
-
-		// create suggest
-		suggest := prompt.Suggest{Text: cmd, Description: "Command. from " + h}
-
-		// append ps.Complete
-		ps.CmdComplete = append(ps.CmdComplete, suggest)
-	}
-
 	sort.SliceStable(ps.CmdComplete, func(i, j int) bool { return ps.CmdComplete[i].Text < ps.CmdComplete[j].Text })
 }
 
 // GetPathComplete return complete path from local or remote machine.
-// TDXX(blacknon): 複数のノードにあるPATHだけ補完リストに出てる状態なので、単一ノードにしか無いファイルも出力されるよう修正する.
+// The remote path is from the completion cache of the active hosts.
 func (ps *pShell) GetPathComplete(remote bool, word string) []prompt.Suggest {
-	command := strings.Join([]string{"compgen", "-f", word}, " ")
+	if remote {
+		return ps.remotePathSuggests(word)
+	}
 
-	var p []prompt.Suggest
+	command := strings.Join([]string{"compgen", "-f", word}, " ")
 
-	switch {
-	case remote: // is remote machine
-		p = ps.remoteSuggest(command)
-	case !remote: // is local machine
-		p = ps.localSuggest(command)
-	}
+	p := ps.localSuggest(command)
 
 	sort.SliceStable(p, func(i, j int) bool { return p[i].Text < p[j].Text })
 
@@ -219,54 +223,3 @@ func (ps *pShell) localSuggest(command string) (p []prompt.Suggest) {
 
 	return p
 }
-
-func (ps *pShell) remoteSuggest(command string) (p []prompt.Suggest) {
-	// create map
-	m := map[string][]string{}
-
-	exit := make(chan bool)
-
-	// create sync mutex
-	sm := new(sync.Mutex)
-
-	// append path to m
-	for _, c := range ps.Connects {
-		con := c
-
-		go func() {
-			defer func() { exit <- true }()
-
-			// Create buffer
-			buf := new(bytes.Buffer)
-
-			// Create session, and output to buffer
-			session, _ := con.CreateSession()
-			session.Stdout = buf
-
-			// Run get complete command
-			_ = session.Run(command)
-
-			// Scan and put completed command to map.
-			sc := bufio.NewScanner(buf)
-			for sc.Scan() {
-				sm.Lock()
-				path := filepath.Base(sc.Text())
-				m[path] = append(m[path], con.Name)
-				sm.Unlock()
-			}
-		}()
-	}
-
-	for range ps.Connects {
-		<-exit
-	}
-
-	// m to suggest
-	for path, hosts := range m {
-		h := strings.Join(hosts, ",")
-		suggest := prompt.Suggest{Text: path, Description: "remote path from " + h}
-		p = append(p, suggest)
-	}
-
-	return p
-}
@@ -0,0 +1,309 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/bssh/sshlib"
+	"golang.org/x/crypto/ssh"
+)
+
+// errDropped is the error of the host dropped by `%drop`.
+var errDropped = errors.New("dropped")
+
+// session creates a session of the host.
+// If the connection is dead, the host is marked as disconnected and reconnected in background.
+func (c *psConnect) session() (*ssh.Session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.alive {
+		return nil, fmt.Errorf("disconnected: %w", c.err)
+	}
+
+	s, err := c.Connect.CreateSession()
+	if err != nil {
+		c.alive, c.err = false, err
+
+		// Client.Wait() in keepConnect returns, and starts reconnecting.
+		_ = c.Client.Close()
+	}
+
+	return s, err
+}
+
+// state returns the connection is alive or not, and the last error.
+func (c *psConnect) state() (alive bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.alive, c.err
+}
+
+// wake wakes up the background reconnect waiting for retry.
+func (c *psConnect) wake() {
+	select {
+	case c.retry <- struct{}{}:
+	default:
+	}
+}
+
+// keepConnect waits the connection of the host to die, and reconnects with exponential backoff.
+// It returns when the host is dropped.
+func (ps *pShell) keepConnect(c *psConnect) {
+	backoff := reconnectBackoffMin
+
+	for {
+		c.mu.Lock()
+		alive, dropped, connect := c.alive, c.dropped, c.Connect
+		c.mu.Unlock()
+
+		if dropped {
+			return
+		}
+
+		if alive {
+			go connect.SendClientKeepAlive()
+
+			err := connect.Client.Wait()
+
+			c.mu.Lock()
+			if c.Connect == connect {
+				c.alive = false
+				if c.err == nil {
+					c.err = fmt.Errorf("connection lost: %v", err)
+				}
+			}
+			c.mu.Unlock()
+
+			backoff = reconnectBackoffMin
+
+			continue
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.retry:
+		}
+
+		if err := ps.reconnect(c); err != nil {
+			if backoff *= 2; backoff > reconnectBackoffMax {
+				backoff = reconnectBackoffMax
+			}
+		}
+	}
+}
+
+// reconnect creates the connection of the host, if it is not alive.
+func (ps *pShell) reconnect(c *psConnect) error {
+	if alive, _ := c.state(); alive {
+		return nil
+	}
+
+	connect, err := ps.createConnect(c.Name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.dropped:
+		err = errDropped
+	case err != nil:
+		c.err = err
+	case c.alive: // reconnected by others in the meantime.
+		_ = connect.Client.Close()
+	default:
+		c.Connect, c.alive, c.err = connect, true, nil
+	}
+
+	if err != nil && connect != nil && connect.Client != nil {
+		_ = connect.Client.Close()
+	}
+
+	return err
+}
+
+// drop closes the connection of the host, and stops reconnecting.
+func (c *psConnect) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropped, c.alive, c.err = true, false, errDropped
+
+	if c.Connect != nil && c.Client != nil {
+		_ = c.Client.Close()
+	}
+
+	c.wake()
+}
+
+// disconnected returns the names of disconnected hosts.
+func (ps *pShell) disconnected() []string {
+	var names []string
+
+	for _, c := range ps.Connects {
+		if alive, _ := c.state(); !alive {
+			names = append(names, c.Name)
+		}
+	}
+
+	return names
+}
+
+// buildinReconnect is reconnect the disconnected hosts now.
+// example:
+//   - %reconnect
+//   - %reconnect <selector>
+func (ps *pShell) buildinReconnect(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	connects := ps.Connects
+	if len(args) > 0 {
+		var err error
+		if connects, err = ps.selectConnects(strings.Join(args, ",")); err != nil {
+			fmt.Fprintf(stdout, "%%reconnect: %v\n", err)
+			connects = nil
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	results := make([]string, len(connects))
+
+	for i, c := range connects {
+		if alive, _ := c.state(); alive {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, c *psConnect) {
+			defer wg.Done()
+
+			if err := ps.reconnect(c); err != nil {
+				results[i] = fmt.Sprintf("%s: reconnect error: %v", c.Name, err)
+				return
+			}
+
+			// keepConnect starts watching the new connection.
+			c.wake()
+
+			results[i] = fmt.Sprintf("%s: reconnected", c.Name)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	for _, result := range results {
+		if result != "" {
+			fmt.Fprintln(stdout, result)
+		}
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinDrop is remove the hosts from pshell, and close the connections.
+// example:
+//   - %drop <selector>
+func (ps *pShell) buildinDrop(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	var connects []*psConnect
+
+	var err error
+
+	switch {
+	case len(args) == 0:
+		err = errors.New("usage: %drop <host|glob|group>[,...]")
+	default:
+		connects, err = ps.selectConnects(strings.Join(args, ","))
+		if err == nil && len(connects) == len(ps.Connects) {
+			err = errors.New("can not drop all hosts")
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(stdout, "%%drop: %v\n", err)
+	} else {
+		ps.dropConnects(connects)
+		fmt.Fprintf(stdout, "dropped %s\n", joinConnectNames(connects))
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// dropConnects removes connects from ps.Connects and ps.Active.
+func (ps *pShell) dropConnects(connects []*psConnect) {
+	dropped := map[*psConnect]bool{}
+	for _, c := range connects {
+		c.drop()
+		dropped[c] = true
+	}
+
+	remove := func(list []*psConnect) []*psConnect {
+		if list == nil {
+			return nil
+		}
+
+		result := make([]*psConnect, 0, len(list))
+		for _, c := range list {
+			if !dropped[c] {
+				result = append(result, c)
+			}
+		}
+
+		return result
+	}
+
+	ps.Connects = remove(ps.Connects)
+	ps.Active = remove(ps.Active)
+
+	if len(ps.Active) == 0 {
+		ps.Active = nil
+	}
+
+	servers := make([]string, 0, len(ps.Connects))
+	for _, c := range ps.Connects {
+		servers = append(servers, c.Name)
+	}
+
+	ps.ServerList = servers
+}
+
+// createPsConnect returns the function to connect the server in pshell.
+func (r *Run) createPsConnect() func(server string) (*sshlib.Connect, error) {
+	return func(server string) (*sshlib.Connect, error) {
+		con, err := r.CreateSSHConnect(nil, server)
+		if err != nil {
+			return nil, err
+		}
+
+		// TTY enable
+		con.TTY = true
+
+		return con, nil
+	}
+}
+
+// printDisconnected prints the disconnected hosts to skip.
+func printDisconnected(c *psConnect, err error) {
+	fmt.Fprintf(os.Stderr, "%s skipped, %v\n", c.Output.GetPrompt(), err)
+}
@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/bssh/sshlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestKeepConnect loses the connection of the host, it is reconnected in background until dropped.
+func TestKeepConnect(t *testing.T) {
+	c := newEvalHost(t, "web1", 0)
+	c.retry = make(chan struct{}, 1)
+
+	ps := &pShell{createConnect: func(server string) (*sshlib.Connect, error) {
+		return newEvalHost(t, server, 0).Connect, nil
+	}}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ps.keepConnect(c)
+	}()
+
+	first := c.Connect
+	_ = first.Client.Close()
+
+	assert.Eventually(t, func() bool {
+		alive, err := c.state()
+		return !alive && err != nil
+	}, 5*time.Second, 10*time.Millisecond, "disconnected")
+
+	assert.Eventually(t, func() bool {
+		alive, _ := c.state()
+		return alive
+	}, 5*time.Second, 10*time.Millisecond, "reconnected after backoff")
+
+	c.mu.Lock()
+	assert.NotSame(t, first, c.Connect)
+	c.mu.Unlock()
+
+	c.drop()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepConnect not returned after drop")
+	}
+
+	alive, err := c.state()
+	assert.False(t, alive)
+	assert.Equal(t, errDropped, err)
+}
+
+func TestReconnect(t *testing.T) {
+	refused := errors.New("connection refused")
+
+	ps := &pShell{createConnect: func(server string) (*sshlib.Connect, error) {
+		if server == "down" {
+			return nil, refused
+		}
+
+		return newEvalHost(t, server, 0).Connect, nil
+	}}
+
+	down := &psConnect{Name: "down", err: errors.New("connection lost")}
+	assert.Equal(t, refused, ps.reconnect(down))
+
+	alive, err := down.state()
+	assert.False(t, alive)
+	assert.Equal(t, refused, err, "the last error")
+
+	up := &psConnect{Name: "web1", err: errors.New("connection lost")}
+	require.Nil(t, ps.reconnect(up))
+
+	alive, err = up.state()
+	assert.True(t, alive)
+	assert.Nil(t, err)
+
+	dropped := &psConnect{Name: "web2"}
+	dropped.drop()
+	assert.Equal(t, errDropped, ps.reconnect(dropped))
+}
+
+func TestDropConnects(t *testing.T) {
+	newPShell := func() *pShell {
+		ps := &pShell{}
+		for _, name := range []string{"web1", "web2", "db1"} {
+			ps.Connects = append(ps.Connects, &psConnect{Name: name, alive: true, retry: make(chan struct{}, 1)})
+		}
+
+		return ps
+	}
+
+	ps := newPShell()
+	ps.Active = []*psConnect{ps.Connects[1], ps.Connects[2]}
+	ps.dropConnects([]*psConnect{ps.Connects[1]})
+
+	assert.Equal(t, []string{"web1", "db1"}, ps.ServerList)
+	assert.Equal(t, []string{"web1", "db1"}, connectNames(ps.Connects))
+	assert.Equal(t, []string{"db1"}, connectNames(ps.Active))
+
+	ps.dropConnects([]*psConnect{ps.Connects[1]})
+	assert.Nil(t, ps.Active, "all hosts are active after the active hosts are dropped")
+	assert.Empty(t, ps.disconnected(), "dropped hosts are removed")
+
+	ps.Connects[0].alive = false
+	assert.Equal(t, []string{"web1"}, ps.disconnected())
+}
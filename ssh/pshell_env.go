@@ -0,0 +1,312 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bingoohuang/ngg/ss"
+	"mvdan.cc/sh/syntax"
+)
+
+// envNameRegexp is the name of environment variable can be exported.
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// remoteCommand returns the command to run on the host, in the current directory
+// of the host with the environment variables of `%export`.
+func (ps *pShell) remoteCommand(c *psConnect, command string) string {
+	var prefix strings.Builder
+
+	if c.cwd != "" {
+		fmt.Fprintf(&prefix, "cd %s || exit 1; ", shellQuote(c.cwd))
+	}
+
+	names := make([]string, 0, len(ps.env))
+	for name := range ps.env {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	// the value is as typed, expanded by the remote shell (e.g. `PATH=$PATH:/opt/bin`).
+	for _, name := range names {
+		fmt.Fprintf(&prefix, "export %s=%s; ", name, ps.env[name])
+	}
+
+	return prefix.String() + command
+}
+
+// shellQuote quotes s by single quotes for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// buildinCd is change the current directory of remote commands on the active hosts.
+// The directory is checked by sftp on each host, the hosts failed keep the current directory.
+// example:
+//   - %cd            ... home directory
+//   - %cd <dir>
+//   - @web1: %cd <dir>
+func (ps *pShell) buildinCd(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	if len(args) > 1 {
+		fmt.Fprintf(stdout, "usage: %%cd [dir]\n")
+	} else {
+		dir := ""
+		if len(args) == 1 {
+			dir = args[0]
+		}
+
+		connects := ps.activeConnects()
+		results := make([]string, len(connects))
+
+		var wg sync.WaitGroup
+
+		for i, c := range connects {
+			wg.Add(1)
+
+			go func(i int, c *psConnect) {
+				defer wg.Done()
+
+				c.Output.Count = ps.Count
+
+				cwd, err := c.changeDir(dir)
+				if err != nil {
+					results[i] = fmt.Sprintf("%s %%cd: %v", c.Output.GetPrompt(), err)
+					return
+				}
+
+				results[i] = fmt.Sprintf("%s %s", c.Output.GetPrompt(), cwd)
+			}(i, c)
+		}
+
+		wg.Wait()
+
+		for _, result := range results {
+			fmt.Fprintln(stdout, result)
+		}
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// changeDir changes the current directory of the host to dir, and returns the absolute path.
+// Empty dir or `~` is the home directory.
+func (c *psConnect) changeDir(dir string) (string, error) {
+	ftp, err := c.sftp()
+	if err != nil {
+		return "", err
+	}
+
+	defer ftp.Close()
+
+	// sftp resolves relative path from the home directory.
+	switch {
+	case dir == "" || dir == "~":
+		dir = "."
+	case strings.HasPrefix(dir, "~/"):
+		dir = path.Join(".", dir[2:])
+	case !path.IsAbs(dir) && c.cwd != "":
+		dir = path.Join(c.cwd, dir)
+	}
+
+	cwd, err := ftp.RealPath(dir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", dir, err)
+	}
+
+	stat, err := ftp.Stat(cwd)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cwd, err)
+	}
+
+	if !stat.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", cwd)
+	}
+
+	c.cwd = cwd
+
+	return cwd, nil
+}
+
+// plainCd returns the directory of the statement `cd [dir]` of a literal dir, ok is false otherwise.
+// `cd -`, `cd ~user` and the dir with quotes or expansions are run by the remote shell as is.
+func plainCd(stmt *syntax.Stmt) (dir string, ok bool) {
+	call, isCall := stmt.Cmd.(*syntax.CallExpr)
+	if !isCall || stmt.Negated || stmt.Background || len(stmt.Redirs) > 0 || len(call.Assigns) > 0 {
+		return "", false
+	}
+
+	if len(call.Args) == 0 || len(call.Args) > 2 || call.Args[0].Lit() != "cd" {
+		return "", false
+	}
+
+	if len(call.Args) == 1 {
+		return "", true
+	}
+
+	dir = call.Args[1].Lit()
+	if dir == "" || dir == "-" || strings.ContainsAny(dir, `\*?[`) {
+		return "", false
+	}
+
+	if strings.HasPrefix(dir, "~") && dir != "~" && !strings.HasPrefix(dir, "~/") {
+		return "", false
+	}
+
+	return dir, true
+}
+
+// keepCwd saves the current directory of the hosts, the returned restore restores it.
+func keepCwd(hosts []*psConnect) (restore func()) {
+	cwds := make([]string, len(hosts))
+	for i, c := range hosts {
+		cwds[i] = c.cwd
+	}
+
+	return func() {
+		for i, c := range hosts {
+			c.cwd = cwds[i]
+		}
+	}
+}
+
+// trackCd changes the current directory of the hosts by `cd [dir]`, like %cd but prints only the errors.
+// It returns the exit status of each host.
+func (ps *pShell) trackCd(dir string, hosts []*psConnect) hostStatus {
+	status := statusOf(hosts, 0)
+	errs := make([]string, len(hosts))
+
+	var wg sync.WaitGroup
+
+	for i, c := range hosts {
+		wg.Add(1)
+
+		go func(i int, c *psConnect) {
+			defer wg.Done()
+
+			c.Output.Count = ps.Count
+
+			if _, err := c.changeDir(dir); err != nil {
+				errs[i] = fmt.Sprintf("%s cd: %v", c.Output.GetPrompt(), err)
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	for i, c := range hosts {
+		if errs[i] != "" {
+			fmt.Fprintln(os.Stderr, errs[i])
+			status[c] = exitCodeFailed
+		}
+	}
+
+	return status
+}
+
+// buildinLcd is change the current directory of local commands.
+// example:
+//   - %lcd           ... home directory
+//   - %lcd <dir>
+func (ps *pShell) buildinLcd(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	dir := "~"
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if len(args) > 1 {
+		fmt.Fprintf(stdout, "usage: %%lcd [dir]\n")
+	} else if err := os.Chdir(ss.ExpandHome(dir)); err != nil {
+		fmt.Fprintf(stdout, "%%lcd: %v\n", err)
+	} else {
+		wd, _ := os.Getwd()
+		fmt.Fprintf(stdout, "localhost %s\n", wd)
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinExport is set the environment variables of remote commands, or print them.
+// example:
+//   - %export
+//   - %export <NAME=VALUE...>
+//   - %export -n <NAME...>  ... unset
+func (ps *pShell) buildinExport(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	switch {
+	case len(args) == 0:
+		names := make([]string, 0, len(ps.env))
+		for name := range ps.env {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(stdout, "export %s=%s\n", name, ps.env[name])
+		}
+	case args[0] == "-n":
+		for _, name := range args[1:] {
+			delete(ps.env, name)
+		}
+	default:
+		for _, arg := range args {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok || !envNameRegexp.MatchString(name) {
+				fmt.Fprintf(stdout, "%%export: invalid %q, use NAME=VALUE\n", arg)
+				continue
+			}
+
+			if ps.env == nil {
+				ps.env = map[string]string{}
+			}
+
+			ps.env[name] = value
+		}
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// remotePath returns the remote path for sftp, relative path is from the current directory of the host.
+// sftp resolves relative path from the home directory.
+func (c *psConnect) remotePath(p string) string {
+	switch {
+	case p == "~" || strings.HasPrefix(p, "~/"):
+		return path.Join(".", p[1:])
+	case path.IsAbs(p):
+		return path.Clean(p)
+	case c.cwd != "":
+		return path.Join(c.cwd, p)
+	default:
+		return path.Join(".", p)
+	}
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainCd(t *testing.T) {
+	type TestData struct {
+		desc    string
+		command string
+		dir     string
+		ok      bool
+	}
+
+	tds := []TestData{
+		{desc: "Home", command: "cd", dir: "", ok: true},
+		{desc: "Absolute", command: "cd /var/log", dir: "/var/log", ok: true},
+		{desc: "Relative", command: "cd ../tmp", dir: "../tmp", ok: true},
+		{desc: "Tilde", command: "cd ~/app", dir: "~/app", ok: true},
+		{desc: "Tilde home", command: "cd ~", dir: "~", ok: true},
+		{desc: "Tilde user", command: "cd ~root"},
+		{desc: "Tilde user path", command: "cd ~root/app"},
+		{desc: "Previous", command: "cd -"},
+		{desc: "Expansion", command: "cd $HOME/app"},
+		{desc: "Quoted", command: `cd "my dir"`},
+		{desc: "Glob", command: "cd /var/lo*"},
+		{desc: "Too many args", command: "cd a b"},
+		{desc: "Redirect", command: "cd /tmp > /dev/null"},
+		{desc: "With command", command: "cd /tmp && ls"},
+		{desc: "Other command", command: "ls /tmp"},
+	}
+
+	for _, v := range tds {
+		f, err := parseCommandLine(v.command)
+		require.Nil(t, err, v.desc)
+
+		dir, ok := plainCd(f.Stmts[0])
+		assert.Equal(t, v.dir, dir, v.desc)
+		assert.Equal(t, v.ok, ok, v.desc)
+	}
+}
+
+func TestRemotePath(t *testing.T) {
+	type TestData struct {
+		desc   string
+		cwd    string
+		path   string
+		expect string
+	}
+
+	tds := []TestData{
+		{desc: "Relative without cd", path: "app/conf", expect: "app/conf"},
+		{desc: "Relative", cwd: "/var/log", path: "nginx/*.log", expect: "/var/log/nginx/*.log"},
+		{desc: "Parent", cwd: "/var/log", path: "../tmp", expect: "/var/tmp"},
+		{desc: "Current", cwd: "/var/log", path: ".", expect: "/var/log"},
+		{desc: "Absolute", cwd: "/var/log", path: "/etc//hosts", expect: "/etc/hosts"},
+		{desc: "Home", cwd: "/var/log", path: "~", expect: "."},
+		{desc: "Under home", cwd: "/var/log", path: "~/app", expect: "app"},
+	}
+
+	for _, v := range tds {
+		c := &psConnect{cwd: v.cwd}
+		assert.Equal(t, v.expect, c.remotePath(v.path), v.desc)
+	}
+}
+
+func TestKeepCwd(t *testing.T) {
+	hosts := []*psConnect{{Name: "web1", cwd: "/home/u"}, {Name: "web2"}}
+
+	restore := keepCwd(hosts)
+	hosts[0].cwd, hosts[1].cwd = "/var/log", "/var/log"
+	restore()
+
+	assert.Equal(t, "/home/u", hosts[0].cwd)
+	assert.Equal(t, "", hosts[1].cwd)
+}
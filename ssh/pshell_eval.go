@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"mvdan.cc/sh/syntax"
+)
+
+// exitCodeInterrupted is the exit status of the commands canceled by the signal, like shell.
+const exitCodeInterrupted = 130
+
+// hostStatus is the exit status of each host, to short-circuit `&&` and `||` per host.
+type hostStatus map[*psConnect]int
+
+// pipeStatus collects the exit status of the last command of pipeline.
+type pipeStatus struct {
+	mu     sync.Mutex
+	status hostStatus
+}
+
+func newPipeStatus(hosts []*psConnect) *pipeStatus {
+	return &pipeStatus{status: statusOf(hosts, 0)}
+}
+
+// set sets the exit status of the host, nil receiver is ignored (not the last command).
+func (s *pipeStatus) set(c *psConnect, code int) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status[c] = code
+}
+
+// setAll sets the exit status of all hosts, by the local command.
+func (s *pipeStatus) setAll(code int) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.status {
+		s.status[c] = code
+	}
+}
+
+func (s *pipeStatus) result() hostStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(hostStatus, len(s.status))
+	for c, code := range s.status {
+		result[c] = code
+	}
+
+	return result
+}
+
+// statusOf returns the same exit status of hosts.
+func statusOf(hosts []*psConnect, code int) hostStatus {
+	status := make(hostStatus, len(hosts))
+	for _, c := range hosts {
+		status[c] = code
+	}
+
+	return status
+}
+
+// filterHosts returns the hosts succeeded (exit status 0), or failed if !succeeded.
+func filterHosts(hosts []*psConnect, status hostStatus, succeeded bool) []*psConnect {
+	result := make([]*psConnect, 0, len(hosts))
+
+	for _, c := range hosts {
+		if (status[c] == 0) == succeeded {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+// evalStmts runs the statements in order on hosts, returns the exit status of the last statement.
+func (ps *pShell) evalStmts(stmts []*syntax.Stmt, hosts []*psConnect) hostStatus {
+	status := statusOf(hosts, 0)
+
+	for _, stmt := range stmts {
+		status = ps.evalStmt(stmt, hosts)
+	}
+
+	return status
+}
+
+// evalStmt runs the statement on hosts, returns the exit status of each host.
+// The statement without local and build-in command runs as is by the remote shell.
+// Otherwise it is evaluated here, `&&` and `||` run the next command only on the hosts
+// succeeded or failed, the local command runs once for the hosts.
+func (ps *pShell) evalStmt(stmt *syntax.Stmt, hosts []*psConnect) (status hostStatus) {
+	if len(hosts) == 0 {
+		return hostStatus{}
+	}
+
+	if ps.canceled.Load() {
+		return statusOf(hosts, exitCodeInterrupted)
+	}
+
+	// each remote command runs in a new session, `cd` alone changes the directory of the next commands.
+	if dir, ok := plainCd(stmt); ok {
+		return ps.trackCd(dir, hosts)
+	}
+
+	if !hasLocalCommand(stmt) {
+		return ps.runPipeLine([]pipeLine{{Args: []string{printNode(stmt)}}}, hosts)
+	}
+
+	switch c := stmt.Cmd.(type) {
+	case *syntax.BinaryCmd:
+		switch c.Op {
+		case syntax.AndStmt, syntax.OrStmt:
+			status = ps.evalStmt(c.X, hosts)
+
+			next := filterHosts(hosts, status, c.Op == syntax.AndStmt)
+			for host, code := range ps.evalStmt(c.Y, next) {
+				status[host] = code
+			}
+		default: // pipe
+			status = ps.evalPipe(stmt, hosts)
+		}
+	case *syntax.CallExpr:
+		status = ps.evalPipe(stmt, hosts)
+	case *syntax.Subshell:
+		// `cd` in the subshell does not change the directory after it.
+		restore := keepCwd(hosts)
+		status = ps.evalStmts(c.Stmts, hosts)
+		restore()
+	case *syntax.Block:
+		status = ps.evalStmts(c.Stmts, hosts)
+	case *syntax.IfClause:
+		status = ps.evalIf(c, hosts)
+	default:
+		fmt.Fprintf(os.Stderr, "local or build-in command is not supported in `%s`\n", printNode(stmt))
+		return statusOf(hosts, exitCodeFailed)
+	}
+
+	// `! command`
+	if stmt.Negated {
+		for host, code := range status {
+			if code == 0 {
+				status[host] = 1
+			} else {
+				status[host] = 0
+			}
+		}
+	}
+
+	return status
+}
+
+// evalPipe runs the pipeline of local, build-in and remote commands.
+func (ps *pShell) evalPipe(stmt *syntax.Stmt, hosts []*psConnect) hostStatus {
+	pline := flattenStmt(stmt)
+
+	// a compound command in pipeline runs only by the remote shell.
+	for _, p := range pline {
+		if len(p.Args) == 1 && !checkLocalBuildInCommand(p.Args[0]) && hasLocalCommandIn(p.Args[0]) {
+			fmt.Fprintf(os.Stderr, "local or build-in command is not supported in `%s`\n", p.Args[0])
+			return statusOf(hosts, exitCodeFailed)
+		}
+	}
+
+	return ps.runPipeLine(pline, hosts)
+}
+
+// evalIf runs `then` on the hosts the condition succeeded, `else` (or `elif`) on the others.
+func (ps *pShell) evalIf(c *syntax.IfClause, hosts []*psConnect) hostStatus {
+	cond := ps.evalStmts(c.Cond.Stmts, hosts)
+
+	// no `else` is exit status 0.
+	status := statusOf(filterHosts(hosts, cond, false), 0)
+
+	for host, code := range ps.evalStmts(c.Then.Stmts, filterHosts(hosts, cond, true)) {
+		status[host] = code
+	}
+
+	for host, code := range ps.evalStmts(c.Else.Stmts, filterHosts(hosts, cond, false)) {
+		status[host] = code
+	}
+
+	return status
+}
+
+// hasLocalCommandIn returns true if the command line has a local or build-in command.
+func hasLocalCommandIn(command string) bool {
+	f, err := parseCommandLine(command)
+	if err != nil {
+		return false
+	}
+
+	return hasLocalCommand(f)
+}
@@ -0,0 +1,153 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bingoohuang/bssh/output"
+	"github.com/bingoohuang/bssh/sshlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestEvalStmtPerHost runs `&&` and `||` on two hosts, `check` succeeds on web1 and fails on web2.
+// The local command runs only on the hosts short-circuited to it.
+func TestEvalStmtPerHost(t *testing.T) {
+	// not to read the terminal for the input of remote commands.
+	stdin := os.Stdin
+	t.Cleanup(func() { os.Stdin = stdin })
+
+	devNull, err := os.Open(os.DevNull)
+	require.Nil(t, err)
+
+	t.Cleanup(func() { _ = devNull.Close() })
+	os.Stdin = devNull
+
+	type TestData struct {
+		desc    string
+		command string
+		status  map[string]int
+	}
+
+	tds := []TestData{
+		{desc: "And", command: "check && !exit 3", status: map[string]int{"web1": 3, "web2": 1}},
+		{desc: "Or", command: "check || !exit 4", status: map[string]int{"web1": 0, "web2": 4}},
+	}
+
+	for _, v := range tds {
+		v := v
+
+		t.Run(v.desc, func(t *testing.T) {
+			t.Parallel()
+
+			ps := &pShell{History: map[int]map[string]*pShellHistory{0: {}}}
+			hosts := []*psConnect{newEvalHost(t, "web1", 0), newEvalHost(t, "web2", 1)}
+
+			f, err := parseCommandLine(v.command)
+			require.Nil(t, err)
+
+			status := ps.evalStmt(f.Stmts[0], hosts)
+			for _, c := range hosts {
+				assert.Equal(t, v.status[c.Name], status[c], c.Name)
+			}
+
+			ps.historyWG.Wait()
+			assert.Equal(t, "ok\n", ps.History[0]["web1"].Result)
+			assert.Equal(t, "ng\n", ps.History[0]["web2"].Result)
+		})
+	}
+}
+
+// newEvalHost returns the host of an in-memory ssh server, `check` exits with the status.
+func newEvalHost(t *testing.T, name string, status uint32) *psConnect {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.Nil(t, err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+
+		go ssh.DiscardRequests(reqs)
+
+		for ch := range chans {
+			go serveEvalSession(ch, status)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, name,
+		&ssh.ClientConfig{User: "u", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	require.Nil(t, err)
+
+	client := ssh.NewClient(c, chans, reqs)
+	t.Cleanup(func() { _ = client.Close() })
+
+	o := &output.Output{Templete: "${SERVER} :: ", ServerList: []string{name}}
+	o.Create(name)
+
+	return &psConnect{Name: name, Output: o, Connect: &sshlib.Connect{Client: client}, alive: true}
+}
+
+// serveEvalSession runs the exec request of the session, prints ok or ng and exits with the status if `check`.
+func serveEvalSession(ch ssh.NewChannel, status uint32) {
+	c, reqs, err := ch.Accept()
+	if err != nil {
+		return
+	}
+
+	defer c.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var exec struct{ Command string }
+
+		_ = ssh.Unmarshal(req.Payload, &exec)
+		_ = req.Reply(true, nil)
+
+		code := uint32(0)
+		if strings.Contains(exec.Command, "check") {
+			code = status
+		}
+
+		if code == 0 {
+			_, _ = c.Write([]byte("ok\n"))
+		} else {
+			_, _ = c.Write([]byte("ng\n"))
+		}
+
+		_, _ = c.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{code}))
+
+		return
+	}
+}
@@ -7,7 +7,10 @@ package ssh
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
+
+	"mvdan.cc/sh/syntax"
 )
 
 // PipeSet is pipe in/out set struct.
@@ -21,123 +24,180 @@ func (ps *pShell) Executor(command string) {
 	// trim space
 	command = strings.TrimSpace(command)
 
+	// split host selector (`@web1,web3: command`)
+	selector, line, ok := splitHostSelector(command)
+
 	// parse command
-	pslice, _ := parsePipeLine(command)
-	if len(pslice) == 0 {
+	f, err := parseCommandLine(line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		return
 	}
 
+	if len(f.Stmts) == 0 {
+		return
+	}
+
+	// set the hosts of this command line
+	if ok {
+		targets, err := ps.selectConnects(selector)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "@%s: %v\n", selector, err)
+			return
+		}
+
+		ps.targets = targets
+		defer func() { ps.targets = nil }()
+	}
+
 	// set latest command
 	ps.latestCommand = command
 
 	// register history
 	_ = ps.PutHistoryFile(command)
 
-	// exec pipeline
-	ps.parseExecutor(pslice)
+	// record to the session log
+	ps.Log.command(ps.Count, command, connectNames(ps.activeConnects()))
+
+	// exec command line
+	ps.parseExecutor(f.Stmts)
 }
 
 // parseExecutor assemble and execute the parsed command line.
-// TDXX(blacknon): 現状はパイプにしか対応していないので、`&&`や`||`にも対応できるよう変更する(v0.6.1)
-
-func (ps *pShell) parseExecutor(pslice [][]pipeLine) {
+// `&&` and `||` short-circuit in each host by the exit status of the host.
+func (ps *pShell) parseExecutor(stmts []*syntax.Stmt) {
 	// Create History
 	ps.History[ps.Count] = map[string]*pShellHistory{}
+	ps.canceled.Store(false)
 
-	for _, pline := range pslice {
-		// count pipe num
-		pnum := countPipeSet(pline, "|")
+	ps.evalStmts(stmts, ps.activeConnects())
 
-		// create pipe set
-		pipes := createPipeSet(pnum)
+	// the files may be changed, refresh the path completion.
+	ps.expireComplete()
 
-		// join pipe set
-		pline = joinPipeLine(pline)
+	// print the outputs grouped (%set group true)
+	if ps.Options.GroupOutput {
+		ps.historyWG.Wait()
+		ps.printGroupOutput(ps.Count)
+	}
 
-		// printout run command
-		fmt.Printf("[Command:%s ]\n", joinPipeLineSlice(pline))
+	// add ps.Count
+	// (Does not count if only the built-in command is executed)
+	isBuildInOnly := true
 
-		// pipe counter
-		var n int
+	for _, stmt := range stmts {
+		if _, ok := plainCd(stmt); ok {
+			continue
+		}
 
-		// create channel
-		ch := make(chan bool)
-		defer close(ch)
+		for _, p := range flattenStmt(stmt) {
+			if !checkBuildInCommand(p.Args[0]) {
+				isBuildInOnly = false
+			}
+		}
+	}
 
-		kill := make(chan bool)
-		defer close(kill)
+	if !isBuildInOnly {
+		ps.Count++
+	}
+}
 
-		for i, p := range pline {
-			// declare nextPipeLine
-			var bp pipeLine
+// runPipeLine runs the pipeline on hosts, returns the exit status of the last command on each host.
+func (ps *pShell) runPipeLine(pline []pipeLine, hosts []*psConnect) hostStatus {
+	// run remote command and build-in command on hosts
+	targets := ps.targets
+	ps.targets = hosts
 
-			// declare in,out
-			var in *io.PipeReader
+	defer func() { ps.targets = targets }()
 
-			var out *io.PipeWriter
+	// count pipe num
+	pnum := countPipeSet(pline, "|")
 
-			// get next pipe line
-			if i > 0 {
-				bp = pline[i-1]
-			}
+	// create pipe set
+	pipes := createPipeSet(pnum)
 
-			// set stdin
-			// If the before delimiter is a pipe, set the stdin before io.PipeReader.
-			if bp.Operator == "|" {
-				in = pipes[n-1].in
-			}
+	// join pipe set
+	pline = joinPipeLine(pline)
 
-			// set stdout
-			// If the delimiter is a pipe, set the stdout output a io.PipeWriter.
-			if p.Operator == "|" {
-				out = pipes[n].out
+	// printout run command
+	fmt.Printf("[Command:%s ]\n", joinPipeLineSlice(pline))
 
-				// add pipe num
-				n++
-			}
+	// exit status of the last command
+	status := newPipeStatus(hosts)
 
-			// exec pipeline
-			go ps.run(p, in, out, ch, kill)
-		}
+	// pipe counter
+	var n int
 
-		// get and send kill
-		killExit := make(chan bool)
-		defer close(killExit)
-
-		go func() {
-			select {
-			case <-ps.Signal:
-				for i := 0; i < len(pline); i++ {
-					kill <- true
-				}
-			case <-killExit:
-				return
-			}
-		}()
+	// create channel
+	ch := make(chan bool)
+	defer close(ch)
 
-		// wait channel
-		wait(len(pline), ch)
-	}
+	kill := make(chan bool)
+	defer close(kill)
 
-	// add ps.Count
-	// (Does not count if only the built-in command is executed)
-	isBuildInOnly := true
+	for i, p := range pline {
+		// declare nextPipeLine
+		var bp pipeLine
 
-	for _, pline := range pslice {
-		if len(pline) > 1 {
-			isBuildInOnly = false
-			break
+		// declare in,out
+		var in *io.PipeReader
+
+		var out *io.PipeWriter
+
+		// get next pipe line
+		if i > 0 {
+			bp = pline[i-1]
 		}
 
-		if !checkBuildInCommand(pline[0].Args[0]) {
-			isBuildInOnly = false
-			break
+		// set stdin
+		// If the before delimiter is a pipe, set the stdin before io.PipeReader.
+		if bp.Operator == "|" {
+			in = pipes[n-1].in
 		}
-	}
 
-	if !isBuildInOnly {
-		ps.Count++
+		// set stdout
+		// If the delimiter is a pipe, set the stdout output a io.PipeWriter.
+		if p.Operator == "|" {
+			out = pipes[n].out
+
+			// add pipe num
+			n++
+		}
+
+		// only the exit status of the last command is the pipeline's.
+		var st *pipeStatus
+		if i == len(pline)-1 {
+			st = status
+		}
+
+		// exec pipeline
+		go ps.run(p, in, out, ch, kill, st)
 	}
+
+	// get and send kill
+	killExit := make(chan bool)
+	defer close(killExit)
+
+	go func() {
+		select {
+		case <-ps.Signal:
+			ps.canceled.Store(true)
+
+			for i := 0; i < len(pline); i++ {
+				kill <- true
+			}
+		case <-killExit:
+			return
+		}
+	}()
+
+	// wait channel
+	wait(len(pline), ch)
+
+	// the outputs are accumulated to the history in the order of statements.
+	ps.historyWG.Wait()
+
+	return status.result()
 }
 
 // countPipeSet count delimiter in pslice.
@@ -11,11 +11,11 @@ import (
 	"io"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/bingoohuang/bssh/output"
 	"github.com/bingoohuang/ngg/ss"
+	"golang.org/x/crypto/ssh"
 )
 
 type pShellHistory struct {
@@ -23,9 +23,46 @@ type pShellHistory struct {
 	Command   string
 	Result    string
 	Output    *output.Output
+
+	// Status is the result status of remote command, in ok, failed and timeout.
+	// ExitStatus is -1 when there is no exit status.
+	Status     string
+	ExitStatus int
+}
+
+// pShellResultStatus returns the result status and exit status of remote command from the error of Session.Run.
+func pShellResultStatus(err error, timedOut bool) (status string, exitStatus int) {
+	var exitErr *ssh.ExitError
+
+	switch {
+	case timedOut:
+		return ResultTimeout, -1
+	case err == nil:
+		return ResultOK, 0
+	case errors.As(err, &exitErr):
+		return ResultFailed, exitErr.ExitStatus()
+	default:
+		return ResultFailed, -1
+	}
 }
 
-func (ps *pShell) NewHistoryWriter(server string, output *output.Output, m sync.Locker) *io.PipeWriter {
+// pShellExitCode returns the exit code of the result status, for `&&` and `||`.
+func pShellExitCode(status string, exitStatus int) int {
+	switch {
+	case status == ResultOK:
+		return 0
+	case status == ResultTimeout:
+		return exitCodeTimeout
+	case exitStatus > 0:
+		return exitStatus
+	default:
+		return exitCodeFailed
+	}
+}
+
+// NewHistoryWriter returns io.PipeWriter that records the output to the history of server.
+// The history is added when the writer is closed.
+func (ps *pShell) NewHistoryWriter(server string, output *output.Output) (*io.PipeWriter, *pShellHistory) {
 	// craete pShellHistory struct
 	psh := &pShellHistory{
 		Command:   ps.latestCommand,
@@ -37,13 +74,17 @@ func (ps *pShell) NewHistoryWriter(server string, output *output.Output, m sync.
 	r, w := io.Pipe()
 
 	// output Struct
-	go ps.pShellHistoryPrint(psh, server, r, m)
+	ps.historyWG.Add(1)
+
+	go ps.pShellHistoryPrint(psh, server, r)
 
 	// return io.PipeWriter
-	return w
+	return w, psh
 }
 
-func (ps *pShell) pShellHistoryPrint(psh *pShellHistory, server string, r io.Reader, m sync.Locker) {
+func (ps *pShell) pShellHistoryPrint(psh *pShellHistory, server string, r io.Reader) {
+	defer ps.historyWG.Done()
+
 	count := ps.Count
 
 	var result string
@@ -54,6 +95,8 @@ func (ps *pShell) pShellHistoryPrint(psh *pShellHistory, server string, r io.Rea
 		for sc.Scan() {
 			text := sc.Text()
 			result = result + text + "\n"
+
+			ps.Log.output(server, text)
 		}
 
 		if errors.Is(sc.Err(), io.ErrClosedPipe) {
@@ -63,13 +106,16 @@ func (ps *pShell) pShellHistoryPrint(psh *pShellHistory, server string, r io.Rea
 		<-time.After(50 * time.Millisecond)
 	}
 
-	// Add Result
-	psh.Result = result
-
 	// Add History
-	m.Lock()
+	// The outputs of the commands joined by `&&`, `||` and `;` are accumulated in one history.
+	ps.historyMu.Lock()
+	if h, ok := ps.History[count][server]; ok {
+		result = h.Result + result
+	}
+
+	psh.Result = result
 	ps.History[count][server] = psh
-	m.Unlock()
+	ps.historyMu.Unlock()
 }
 
 // GetHistoryFromFile return []History from historyfile.
@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPShellResultStatus(t *testing.T) {
+	type TestData struct {
+		desc       string
+		err        error
+		timedOut   bool
+		status     string
+		exitStatus int
+		code       int
+	}
+
+	exitErr := newExitError(t, 3)
+
+	tds := []TestData{
+		{desc: "OK", err: nil, status: ResultOK, exitStatus: 0, code: 0},
+		{desc: "Exit status", err: exitErr, status: ResultFailed, exitStatus: 3, code: 3},
+		{desc: "Wrapped exit status", err: fmt.Errorf("run: %w", exitErr), status: ResultFailed, exitStatus: 3, code: 3},
+		{desc: "No exit status", err: errors.New("session closed"), status: ResultFailed, exitStatus: -1, code: exitCodeFailed},
+		{desc: "Timeout", err: errors.New("session closed"), timedOut: true, status: ResultTimeout, exitStatus: -1,
+			code: exitCodeTimeout},
+		{desc: "Timeout wins", err: nil, timedOut: true, status: ResultTimeout, exitStatus: -1, code: exitCodeTimeout},
+	}
+
+	for _, v := range tds {
+		status, exitStatus := pShellResultStatus(v.err, v.timedOut)
+		assert.Equal(t, v.status, status, v.desc)
+		assert.Equal(t, v.exitStatus, exitStatus, v.desc)
+		assert.Equal(t, v.code, pShellExitCode(status, exitStatus), v.desc)
+	}
+}
+
+// newExitError returns the *ssh.ExitError of a remote command exited with status.
+func newExitError(t *testing.T, status uint32) *ssh.ExitError {
+	t.Helper()
+
+	c := newEvalHost(t, "web1", status)
+
+	session, err := c.Connect.Client.NewSession()
+	require.Nil(t, err)
+
+	defer session.Close()
+
+	var exitErr *ssh.ExitError
+
+	require.ErrorAs(t, session.Run("check"), &exitErr)
+
+	return exitErr
+}
@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunixbochs/vtclean"
+)
+
+// pShellTimelineFile is the log file of all commands and outputs of hosts in pshell.
+const pShellTimelineFile = "timeline.log"
+
+// pShellLog records the commands and outputs of pshell to the session log directory,
+// a log file per host, and the timeline of all hosts.
+type pShellLog struct {
+	mu        sync.Mutex
+	dir       string
+	timestamp bool
+	hosts     map[string]*os.File
+	timeline  *os.File
+}
+
+// newPShellLog creates the session log directory of pshell, `<dirpath>/pshell_YYYYmmdd_HHMMSS`.
+// It returns nil if the logging is disabled.
+func (r *Run) newPShellLog() *pShellLog {
+	logConf := r.Conf.Log
+	if !logConf.Enable {
+		return nil
+	}
+
+	dir, _, _, err := r.getLogDirPath("pshell")
+	if err == nil {
+		dir = filepath.Join(dir, "pshell_"+time.Now().Format("20060102_150405"))
+		err = os.MkdirAll(dir, 0o700)
+	}
+
+	var timeline *os.File
+	if err == nil {
+		timeline, err = os.OpenFile(filepath.Join(dir, pShellTimelineFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pshell log error: %v\n", err)
+		return nil
+	}
+
+	fmt.Printf("logging to %s\n", dir)
+
+	return &pShellLog{dir: dir, timestamp: logConf.Timestamp, hosts: map[string]*os.File{}, timeline: timeline}
+}
+
+// command records the command line to the timeline and the log files of hosts.
+func (l *pShellLog) command(count int, command string, servers []string) {
+	if l == nil {
+		return
+	}
+
+	line := fmt.Sprintf("[%d] <<< %s\n", count, command)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.write(l.timeline, line)
+
+	for _, server := range servers {
+		l.write(l.host(server), line)
+	}
+}
+
+// output records the output line of server.
+func (l *pShellLog) output(server, text string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.write(l.timeline, fmt.Sprintf("[%s] %s\n", server, text))
+	l.write(l.host(server), text+"\n")
+}
+
+// host returns the log file of server, opens at first.
+func (l *pShellLog) host(server string) *os.File {
+	if f, ok := l.hosts[server]; ok {
+		return f
+	}
+
+	name := strings.ReplaceAll(server, string(os.PathSeparator), "_") + ".log"
+
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pshell log error: %v\n", err)
+	}
+
+	// nil file is not retried, and not written.
+	l.hosts[server] = f
+
+	return f
+}
+
+// write writes line with timestamp, without ansi code.
+func (l *pShellLog) write(f *os.File, line string) {
+	if f == nil {
+		return
+	}
+
+	_, _ = fmt.Fprint(f, formatLogLine(line, l.timestamp))
+}
+
+// formatLogLine removes ansi code of line, and adds the timestamp at the line head.
+func formatLogLine(line string, timestamp bool) string {
+	// NOTE:
+	//     vtclean.Clean treats `\r` of the line end as a carriage return, and moves the line.
+	//     for that reason, the line end is removed before, and added after.
+	eol := ""
+	if strings.HasSuffix(line, "\n") {
+		line, eol = strings.TrimRight(line, "\r\n"), "\n"
+	}
+
+	line = vtclean.Clean(line, false) + eol
+
+	if timestamp {
+		line = time.Now().Format("2006/01/02 15:04:05 ") + line // yyyy/mm/dd HH:MM:SS
+	}
+
+	return line
+}
@@ -0,0 +1,321 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/output"
+	"github.com/bingoohuang/ngg/ss"
+)
+
+// psOption is an option of `%set`, the name is same as the key of [shell] config.
+type psOption struct {
+	name        string
+	description string
+	get         func(ps *pShell) string
+	set         func(ps *pShell, value string) error
+}
+
+// psOptions are the options of `%set`.
+var psOptions = []psOption{
+	boolOption("header_in_pipe", "add OPROMPT to the remote output to pipe",
+		func(o *pShellOption) *bool { return &o.RemoteHeaderWithPipe }),
+	boolOption("no_record_local", "do not record the local command output to history",
+		func(o *pShellOption) *bool { return &o.LocalCommandNotRecordResult }),
+	boolOption("no_complete", "disable the completion of remote commands and paths",
+		func(o *pShellOption) *bool { return &o.DisableCommandComplete }),
+	{
+		name:        "timeout",
+		description: "timeout of remote command, 0 is unlimited",
+		get:         func(ps *pShell) string { return ps.Timeout.String() },
+		set: func(ps *pShell, value string) error {
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+
+			ps.Timeout = timeout
+
+			return nil
+		},
+	},
+	boolOption("group", "print each distinct output once with the servers, after the command line",
+		func(o *pShellOption) *bool { return &o.GroupOutput }),
+}
+
+// boolOption returns the bool option of `%set`, value is true|false, on|off or yes|no.
+func boolOption(name, description string, field func(o *pShellOption) *bool) psOption {
+	return psOption{
+		name:        name,
+		description: description,
+		get:         func(ps *pShell) string { return strconv.FormatBool(*field(&ps.Options)) },
+		set: func(ps *pShell, value string) error {
+			switch strings.ToLower(value) {
+			case "on", "yes":
+				value = "true"
+			case "off", "no":
+				value = "false"
+			}
+
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q, use true|false", value)
+			}
+
+			*field(&ps.Options) = b
+
+			return nil
+		},
+	}
+}
+
+// tomlValue returns the value of the option in TOML.
+func (o psOption) tomlValue(ps *pShell) string {
+	if o.name == "timeout" {
+		return strconv.Quote(o.get(ps))
+	}
+
+	return o.get(ps)
+}
+
+// findOption returns the option of `%set` by name.
+func findOption(name string) (psOption, bool) {
+	for _, o := range psOptions {
+		if o.name == name {
+			return o, true
+		}
+	}
+
+	return psOption{}, false
+}
+
+// newPShellOption returns the options of pshell from [shell] config.
+func newPShellOption(config conf.ShellConfig) pShellOption {
+	return pShellOption{
+		LocalCommandNotRecordResult: config.NoRecordLocal,
+		RemoteHeaderWithPipe:        config.HeaderInPipe,
+		DisableCommandComplete:      config.NoComplete,
+		GroupOutput:                 config.Group,
+	}
+}
+
+// buildinSet is print or set the options of pshell.
+// The options are printed in the format of [shell] config,
+// and the option set is saved to [shell] of the config file.
+// example:
+//   - %set
+//   - %set <name> <value>
+func (ps *pShell) buildinSet(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(stdout, "[shell]")
+
+		for _, o := range psOptions {
+			fmt.Fprintf(stdout, "%s = %s # %s\n", o.name, o.tomlValue(ps), o.description)
+		}
+	case 2:
+		if o, ok := findOption(args[0]); !ok {
+			fmt.Fprintf(stdout, "%%set: unknown option %q\n", args[0])
+		} else if err := o.set(ps, args[1]); err != nil {
+			fmt.Fprintf(stdout, "%%set: %s: %v\n", o.name, err)
+		} else if err := conf.SetShellOption(ps.ConfPath, o.name, o.tomlValue(ps)); err != nil {
+			fmt.Fprintf(stdout, "%s = %s, not saved to %s: %v\n", o.name, o.get(ps), ps.ConfPath, err)
+		} else {
+			fmt.Fprintf(stdout, "%s = %s, saved to [shell] of %s\n", o.name, o.get(ps), ps.ConfPath)
+		}
+	default:
+		fmt.Fprintf(stdout, "usage: %%set [<name> <value>]\n")
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// psSaveResult is the result of a host saved by `%save` in json.
+type psSaveResult struct {
+	Server     string `json:"server"`
+	Command    string `json:"command"`
+	Timestamp  string `json:"timestamp"`
+	Status     string `json:"status,omitempty"`
+	ExitStatus *int   `json:"exit_status,omitempty"`
+	Result     string `json:"result"`
+}
+
+// buildinSave is save the result of each host in exec history at number to the file.
+// The file is json if path ends with `.json`, otherwise text of `[server] line`.
+// example:
+//   - %save <num> <path>
+func (ps *pShell) buildinSave(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	if len(args) != 2 {
+		fmt.Fprintf(stdout, "usage: %%save <num> <path>\n")
+	} else if num, err := strconv.Atoi(args[0]); err != nil {
+		fmt.Fprintf(stdout, "%%save: invalid history number %q\n", args[0])
+	} else if len(ps.History[num]) == 0 {
+		fmt.Fprintf(stdout, "%%save: no history %d\n", num)
+	} else if err := ps.saveHistory(num, ss.ExpandHome(args[1])); err != nil {
+		fmt.Fprintf(stdout, "%%save: %v\n", err)
+	} else {
+		fmt.Fprintf(stdout, "saved %d to %s\n", num, args[1])
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// saveHistory writes the result of each host in exec history at number to path.
+func (ps *pShell) saveHistory(num int, path string) error {
+	histories := ps.History[num]
+
+	servers := make([]string, 0, len(histories))
+	for server := range histories {
+		servers = append(servers, server)
+	}
+
+	sort.Strings(servers)
+
+	var data []byte
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		results := make([]psSaveResult, 0, len(servers))
+
+		for _, server := range servers {
+			h := histories[server]
+			r := psSaveResult{
+				Server: server, Command: h.Command, Timestamp: strings.TrimSpace(h.Timestamp),
+				Status: h.Status, Result: h.Result,
+			}
+
+			if h.Status != "" && h.ExitStatus >= 0 {
+				exitStatus := h.ExitStatus
+				r.ExitStatus = &exitStatus
+			}
+
+			results = append(results, r)
+		}
+
+		var err error
+		if data, err = json.MarshalIndent(results, "", "  "); err != nil {
+			return err
+		}
+
+		data = append(data, '\n')
+	} else {
+		var b strings.Builder
+
+		for _, server := range servers {
+			h := histories[server]
+			if h.Result != "" {
+				for _, line := range strings.Split(strings.TrimSuffix(h.Result, "\n"), "\n") {
+					fmt.Fprintf(&b, "[%s] %s\n", server, line)
+				}
+			}
+
+			if h.Status == ResultTimeout {
+				fmt.Fprintf(&b, "[%s] [%s]\n", server, h.Status)
+			}
+		}
+
+		data = []byte(b.String())
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// printGroupOutput prints the remote output of exec history at number, each distinct output once.
+func (ps *pShell) printGroupOutput(num int) {
+	outputs := map[string]string{}
+
+	for server, h := range ps.History[num] {
+		// local command output is printed already.
+		if h.Output == nil {
+			continue
+		}
+
+		result := h.Result
+		if h.Status == ResultTimeout {
+			result += "[" + h.Status + "]\n"
+		}
+
+		outputs[server] = result
+	}
+
+	if len(outputs) > 0 {
+		output.PrintGroups(os.Stdout, output.GroupOutputs(outputs))
+	}
+}
+
+// pipeCloser closes the output writer of remote command, like io.PipeWriter.
+type pipeCloser interface {
+	CloseWithError(err error) error
+}
+
+// headerWriter writes each line with the header (OPROMPT), to the remote output to pipe.
+// The lines of hosts written concurrently are not mixed by mu.
+type headerWriter struct {
+	w      io.Writer
+	header string
+	mu     sync.Locker
+	buf    []byte
+}
+
+func (hw *headerWriter) Write(p []byte) (int, error) {
+	hw.buf = append(hw.buf, p...)
+
+	for {
+		i := bytes.IndexByte(hw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		if err := hw.writeLine(hw.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+
+		hw.buf = hw.buf[i+1:]
+	}
+}
+
+// CloseWithError writes the last line without newline.
+func (hw *headerWriter) CloseWithError(error) error {
+	if len(hw.buf) == 0 {
+		return nil
+	}
+
+	line := append(hw.buf, '\n')
+	hw.buf = nil
+
+	return hw.writeLine(line)
+}
+
+func (hw *headerWriter) writeLine(line []byte) error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	_, err := fmt.Fprintf(hw.w, "%s %s", hw.header, line)
+
+	return err
+}
@@ -79,74 +79,57 @@ func joinPipeLine(pslice []pipeLine) []pipeLine {
 	return result
 }
 
+// parseCommandLine parses the command line as a shell script.
+func parseCommandLine(command string) (*syntax.File, error) {
+	return syntax.NewParser().Parse(strings.NewReader(command), " ")
+}
+
 // parseCmdPipeLine return [][]pipeLine.
+// Each statement is flatten into the commands joined by the operators (`|`, `&&`, `||`).
 func parsePipeLine(command string) (pslice [][]pipeLine, err error) {
 	// Create result pipeLineSlice
 	pslice = [][]pipeLine{}
 
-	// Create parser
-	in := strings.NewReader(command)
-	f, err := syntax.NewParser().Parse(in, " ")
+	f, err := parseCommandLine(command)
 	if err != nil {
 		return
 	}
 
 	// parse stmt
 	for _, stmt := range f.Stmts {
-		// create slice
-		var cmdLine []pipeLine
-
-		// create stmtCmd, stmtRedirs
-		var stmtCmd syntax.Command
-
-		var stmtRedirs []*syntax.Redirect
-
-		stmtCmd = stmt.Cmd
-		stmtRedirs = stmt.Redirs
-
-		// parse stmt loop
-	stmtCmdLoop:
-		for {
-			switch c := stmtCmd.(type) {
-			case *syntax.CallExpr:
-				args := parseCallExpr(c)
-				args = append(args, parseRedirect(stmtRedirs)...)
-				pLine := pipeLine{Args: args}
-				cmdLine = append(cmdLine, pLine)
-
-				break stmtCmdLoop
-			case *syntax.BinaryCmd:
-				switch cx := c.X.Cmd.(type) {
-				case *syntax.CallExpr:
-					args := parseCallExpr(cx)
-					args = append(args, parseRedirect(c.X.Redirs)...)
-					pLine := pipeLine{Args: args, Operator: c.Op.String()}
-					cmdLine = append(cmdLine, pLine)
-					stmtCmd = c.Y.Cmd
-					stmtRedirs = c.Y.Redirs
-				case *syntax.BinaryCmd: // TDXX(blacknon): &&や||に対応させる(対処方法がわからん…)
-					stmtCmd = cx
-					stmtRedirs = c.X.Redirs
-				}
-			}
-		}
-
-		pslice = append(pslice, cmdLine)
+		pslice = append(pslice, flattenStmt(stmt))
 	}
 
 	return pslice, err
 }
 
+// flattenStmt returns the commands of stmt in order, with the operators (`|`, `&&`, `||`).
+// A compound command (subshell, if, for...) is a command of the whole.
+func flattenStmt(stmt *syntax.Stmt) []pipeLine {
+	switch c := stmt.Cmd.(type) {
+	case *syntax.BinaryCmd:
+		x := flattenStmt(c.X)
+		x[len(x)-1].Operator = c.Op.String()
+
+		return append(x, flattenStmt(c.Y)...)
+	case *syntax.CallExpr:
+		if args := parseCallExpr(c); len(args) > 0 {
+			return []pipeLine{{Args: append(args, parseRedirect(stmt.Redirs)...)}}
+		}
+	}
+
+	return []pipeLine{{Args: []string{printNode(stmt)}}}
+}
+
 // parseCallExpr return pipeline element ([]string).
+// The command with assignments (`A=1 cmd`) is an element of the whole.
 func parseCallExpr(cmd *syntax.CallExpr) (pLine []string) {
-	printer := syntax.NewPrinter()
+	if len(cmd.Assigns) > 0 {
+		return []string{printNode(cmd)}
+	}
 
 	for _, arg := range cmd.Args {
-		for _, part := range arg.Parts {
-			buf := new(bytes.Buffer)
-			printer.Print(buf, part)
-			pLine = append(pLine, buf.String())
-		}
+		pLine = append(pLine, printNode(arg))
 	}
 
 	return
@@ -154,8 +137,6 @@ func parseCallExpr(cmd *syntax.CallExpr) (pLine []string) {
 
 // parseRedirect return pipeline redirect element ([]string).
 func parseRedirect(redir []*syntax.Redirect) (rs []string) {
-	printer := syntax.NewPrinter()
-
 	for _, r := range redir {
 		var rr string
 		if r.N != nil {
@@ -163,15 +144,31 @@ func parseRedirect(redir []*syntax.Redirect) (rs []string) {
 		}
 
 		rr += r.Op.String()
-
-		for _, part := range r.Word.Parts {
-			buf := new(bytes.Buffer)
-			printer.Print(buf, part)
-			rr += buf.String()
-		}
+		rr += printNode(r.Word)
 
 		rs = append(rs, rr)
 	}
 
 	return
 }
+
+// printNode returns the shell script of node.
+func printNode(node syntax.Node) string {
+	buf := new(bytes.Buffer)
+	_ = syntax.NewPrinter().Print(buf, node)
+
+	return buf.String()
+}
+
+// hasLocalCommand returns true if node has a local command (`!cmd`) or a build-in command.
+func hasLocalCommand(node syntax.Node) (found bool) {
+	syntax.Walk(node, func(node syntax.Node) bool {
+		if c, ok := node.(*syntax.CallExpr); ok && len(c.Args) > 0 && checkLocalBuildInCommand(printNode(c.Args[0])) {
+			found = true
+		}
+
+		return !found
+	})
+
+	return found
+}
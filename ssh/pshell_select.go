@@ -0,0 +1,230 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/c-bata/go-prompt"
+)
+
+// splitHostSelector splits `@web1,web3: command` into the host selector and the command.
+// The selector ends at the first colon followed by a space or the end, server names may contain colons
+// (e.g. `@user@host:22: command`).
+// ok is false if command has no host selector.
+func splitHostSelector(command string) (selector, rest string, ok bool) {
+	if !strings.HasPrefix(command, "@") {
+		return "", command, false
+	}
+
+	for i := 1; i < len(command); i++ {
+		if command[i] != ':' {
+			continue
+		}
+
+		if i+1 == len(command) || command[i+1] == ' ' || command[i+1] == '\t' {
+			return strings.TrimSpace(command[1:i]), strings.TrimSpace(command[i+1:]), true
+		}
+	}
+
+	return "", command, false
+}
+
+// selectConnects returns the connects matched by selector, in order of ps.Connects.
+// selector is comma separated server names, globs (`web*`) or group names.
+func (ps *pShell) selectConnects(selector string) ([]*psConnect, error) {
+	matched := map[string]bool{}
+
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		found := false
+
+		for _, name := range ps.ServerList {
+			if ps.matchHost(term, name) {
+				matched[name] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no host matches %q", term)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("empty host selector")
+	}
+
+	var connects []*psConnect
+
+	for _, c := range ps.Connects {
+		if matched[c.Name] {
+			connects = append(connects, c)
+		}
+	}
+
+	if len(connects) == 0 {
+		return nil, fmt.Errorf("no host matches %q", selector)
+	}
+
+	return connects, nil
+}
+
+// matchHost returns true if server name matches term, by name, glob or group name.
+func (ps *pShell) matchHost(term, name string) bool {
+	if term == name {
+		return true
+	}
+
+	if ok, _ := path.Match(term, name); ok {
+		return true
+	}
+
+	for _, n := range ps.Groups[term] {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// activeConnects returns the connects to run remote command.
+// It is the hosts of `@selector:` while running the command line, otherwise the hosts of `%use`.
+func (ps *pShell) activeConnects() []*psConnect {
+	connects := ps.Active
+	if ps.targets != nil {
+		connects = ps.targets
+	}
+
+	if connects == nil {
+		connects = ps.Connects
+	}
+
+	return connects
+}
+
+// buildinUse is set the active hosts of subsequent commands.
+// example:
+//   - %use web1,web3
+//   - %use web*
+//   - %use <group>
+func (ps *pShell) buildinUse(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	if len(args) == 0 {
+		fmt.Fprintf(stdout, "usage: %%use <host|glob|group>[,...]\n")
+	} else if connects, err := ps.selectConnects(strings.Join(args, ",")); err != nil {
+		fmt.Fprintf(stdout, "%%use: %v\n", err)
+	} else {
+		ps.Active = connects
+		fmt.Fprintf(stdout, "use %d/%d hosts: %s\n", len(connects), len(ps.Connects), joinConnectNames(connects))
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinAll is reset the active hosts to all hosts.
+func (ps *pShell) buildinAll(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	ps.Active = nil
+	fmt.Fprintf(stdout, "use all %d hosts\n", len(ps.activeConnects()))
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinHosts is print hosts, `*` is the active host, with the error of disconnected host.
+func (ps *pShell) buildinHosts(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	active := map[string]bool{}
+	for _, c := range ps.activeConnects() {
+		active[c.Name] = true
+	}
+
+	for _, c := range ps.Connects {
+		mark := " "
+		if active[c.Name] {
+			mark = "*"
+		}
+
+		if alive, err := c.state(); !alive {
+			fmt.Fprintf(stdout, "%s %s (down: %v)\n", mark, c.Name, err)
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s %s\n", mark, c.Name)
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// hostSuggests return the suggests of host selector, server and group names.
+// word is the comma separated selector before cursor, prefix is prepended to the suggest (e.g. `@`).
+func (ps *pShell) hostSuggests(word, prefix string) []prompt.Suggest {
+	word = strings.TrimPrefix(word, prefix)
+
+	head := ""
+	if i := strings.LastIndex(word, ","); i >= 0 {
+		head, word = word[:i+1], word[i+1:]
+	}
+
+	var a []prompt.Suggest
+
+	for _, c := range ps.Connects {
+		a = append(a, prompt.Suggest{Text: prefix + head + c.Name, Description: "host"})
+	}
+
+	groups := make([]string, 0, len(ps.Groups))
+	for g := range ps.Groups {
+		groups = append(groups, g)
+	}
+
+	sort.Strings(groups)
+
+	for _, g := range groups {
+		a = append(a, prompt.Suggest{Text: prefix + head + g, Description: "group: " + strings.Join(ps.Groups[g], ",")})
+	}
+
+	return prompt.FilterHasPrefix(a, prefix+head+word, false)
+}
+
+// connectNames returns the names of connects.
+func connectNames(connects []*psConnect) []string {
+	names := make([]string, len(connects))
+	for i, c := range connects {
+		names[i] = c.Name
+	}
+
+	return names
+}
+
+// joinConnectNames returns the comma separated names of connects.
+func joinConnectNames(connects []*psConnect) string {
+	return strings.Join(connectNames(connects), ",")
+}
@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitHostSelector(t *testing.T) {
+	type TestData struct {
+		desc     string
+		command  string
+		selector string
+		rest     string
+		ok       bool
+	}
+
+	tds := []TestData{
+		{desc: "Selector", command: "@web1,web3: uptime", selector: "web1,web3", rest: "uptime", ok: true},
+		{desc: "Tab after colon", command: "@web1:\tuptime", selector: "web1", rest: "uptime", ok: true},
+		{desc: "Selector only", command: "@web1:", selector: "web1", rest: "", ok: true},
+		{desc: "Name with colon", command: "@file:host: uptime", selector: "file:host", rest: "uptime", ok: true},
+		{desc: "Name with user and port", command: "@user@host:22: ls /tmp", selector: "user@host:22", rest: "ls /tmp", ok: true},
+		{desc: "Colon in command", command: "@web1: echo a:b", selector: "web1", rest: "echo a:b", ok: true},
+		{desc: "No space after colon", command: "@web1:uptime", rest: "@web1:uptime"},
+		{desc: "No selector", command: "echo a: b", rest: "echo a: b"},
+		{desc: "No colon", command: "@web1", rest: "@web1"},
+	}
+
+	for _, v := range tds {
+		selector, rest, ok := splitHostSelector(v.command)
+		assert.Equal(t, v.selector, selector, v.desc)
+		assert.Equal(t, v.rest, rest, v.desc)
+		assert.Equal(t, v.ok, ok, v.desc)
+	}
+}
+
+func TestSelectConnects(t *testing.T) {
+	names := []string{"web1", "web2", "db1", "file:host", "user@host:22"}
+	ps := &pShell{ServerList: names, Groups: map[string][]string{"db": {"db1"}}}
+
+	for _, name := range names {
+		ps.Connects = append(ps.Connects, &psConnect{Name: name})
+	}
+
+	type TestData struct {
+		desc     string
+		selector string
+		expect   []string
+		err      bool
+	}
+
+	tds := []TestData{
+		{desc: "Name", selector: "web2", expect: []string{"web2"}},
+		{desc: "In order of hosts", selector: "db1,web1", expect: []string{"web1", "db1"}},
+		{desc: "Glob", selector: "web*", expect: []string{"web1", "web2"}},
+		{desc: "Group", selector: "db, web1", expect: []string{"web1", "db1"}},
+		{desc: "Duplicated", selector: "web1,web*", expect: []string{"web1", "web2"}},
+		{desc: "Name with colon", selector: "file:host,user@host:22", expect: []string{"file:host", "user@host:22"}},
+		{desc: "No match", selector: "web1,cache", err: true},
+		{desc: "Empty", selector: " , ", err: true},
+	}
+
+	for _, v := range tds {
+		connects, err := ps.selectConnects(v.selector)
+		assert.Equal(t, v.err, err != nil, v.desc)
+
+		if !v.err {
+			assert.Equal(t, v.expect, connectNames(connects), v.desc)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/bingoohuang/bssh/common"
+	"github.com/bingoohuang/bssh/output"
+	"github.com/bingoohuang/ngg/ss"
+	"github.com/pkg/sftp"
+	"github.com/vbauerster/mpb"
+)
+
+// sftp creates a sftp client on the connection of the host.
+func (c *psConnect) sftp() (*sftp.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.alive {
+		return nil, fmt.Errorf("disconnected: %w", c.err)
+	}
+
+	return sftp.NewClient(c.Client)
+}
+
+// buildinPut is put local files or directories to the remote directory of the active hosts, in parallel.
+// example:
+//   - %put <local...> <remote>
+//   - @web1: %put <local...> <remote>
+func (ps *pShell) buildinPut(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	if len(args) < 2 {
+		fmt.Fprintf(stdout, "usage: %%put <local...> <remote>\n")
+	} else {
+		local, remote := args[:len(args)-1], args[len(args)-1]
+		ps.transfer(func(c *psConnect, o *output.Output, ftp *sftp.Client) error {
+			return putPaths(o, ftp, local, c.remotePath(remote))
+		})
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildinGet is get remote files or directories of the active hosts, in parallel.
+// The files of each host are placed in `<local>/<server>/`.
+// example:
+//   - %get <remote...> <local>
+//   - @web1: %get <remote...> <local>
+func (ps *pShell) buildinGet(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	if len(args) < 2 {
+		fmt.Fprintf(stdout, "usage: %%get <remote...> <local>\n")
+	} else {
+		remote, local := args[:len(args)-1], args[len(args)-1]
+		ps.transfer(func(c *psConnect, o *output.Output, ftp *sftp.Client) error {
+			paths := make([]string, len(remote))
+			for i, r := range remote {
+				paths[i] = c.remotePath(r)
+			}
+
+			return getPaths(o, ftp, paths, filepath.Join(ss.ExpandHome(local), c.Name))
+		})
+	}
+
+	// close out
+	if _, ok := stdout.(*io.PipeWriter); ok {
+		_ = out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// transfer runs fn with a sftp client on each active host in parallel, and waits the progress bars.
+func (ps *pShell) transfer(fn func(c *psConnect, o *output.Output, ftp *sftp.Client) error) {
+	wg := new(sync.WaitGroup)
+	progress := mpb.New(mpb.WithWaitGroup(wg))
+
+	var done sync.WaitGroup
+
+	for _, c := range ps.activeConnects() {
+		// Output with progress bar, not to change the output of commands.
+		o := *c.Output
+		o.Count = ps.Count
+		o.Progress = progress
+		o.ProgressWG = wg
+
+		ftp, err := c.sftp()
+		if err != nil {
+			printDisconnected(c, err)
+			continue
+		}
+
+		done.Add(1)
+
+		go func(c *psConnect, o *output.Output) {
+			defer done.Done()
+			defer ftp.Close()
+
+			if err := fn(c, o, ftp); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %v\n", o.GetPrompt(), err)
+			}
+		}(c, &o)
+	}
+
+	done.Wait()
+	progress.Wait()
+}
+
+// putPaths puts local paths into the remote directory.
+func putPaths(o *output.Output, ftp *sftp.Client, local []string, remote string) error {
+	for _, l := range local {
+		l = ss.ExpandHome(l)
+
+		files, err := common.WalkDir(l)
+		if err != nil {
+			return err
+		}
+
+		sort.Strings(files)
+
+		base := filepath.Dir(filepath.Clean(l))
+
+		for _, f := range files {
+			rel, _ := filepath.Rel(base, f)
+			rpath := path.Join(remote, filepath.ToSlash(rel))
+
+			if err := putPath(o, ftp, f, rpath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// putPath puts the local file or directory to rpath.
+func putPath(o *output.Output, ftp *sftp.Client, lpath, rpath string) error {
+	stat, err := os.Lstat(lpath)
+	if err != nil {
+		return err
+	}
+
+	if stat.IsDir() {
+		if err := ftp.MkdirAll(rpath); err != nil {
+			return fmt.Errorf("mkdir %s: %w", rpath, err)
+		}
+
+		return ftp.Chmod(rpath, stat.Mode())
+	}
+
+	lf, err := os.Open(lpath)
+	if err != nil {
+		return err
+	}
+
+	defer lf.Close()
+
+	if err := ftp.MkdirAll(path.Dir(rpath)); err != nil {
+		return fmt.Errorf("mkdir %s: %w", path.Dir(rpath), err)
+	}
+
+	rf, err := ftp.OpenFile(rpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", rpath, err)
+	}
+
+	defer rf.Close()
+
+	o.ProgressWG.Add(1)
+
+	if err := o.ProgressPrinter(stat.Size(), io.TeeReader(common.CreateRateLimit(lf), rf), rpath); err != nil {
+		return fmt.Errorf("put %s: %w", rpath, err)
+	}
+
+	return ftp.Chmod(rpath, stat.Mode())
+}
+
+// getPaths gets remote paths (glob is available) into the local directory.
+func getPaths(o *output.Output, ftp *sftp.Client, remote []string, local string) error {
+	for _, r := range remote {
+		matches, err := ftp.Glob(r)
+		if err != nil {
+			return err
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: no such file or directory", r)
+		}
+
+		for _, m := range matches {
+			base := path.Dir(m)
+			walker := ftp.Walk(m)
+
+			for walker.Step() {
+				if err := walker.Err(); err != nil {
+					return err
+				}
+
+				p := walker.Path()
+				if common.IsHidden(base, p) {
+					continue // ignore hidden files.
+				}
+
+				rel, _ := filepath.Rel(base, p)
+				if err := getPath(o, ftp, p, walker.Stat(), filepath.Join(local, rel)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// getPath gets the remote file or directory to lpath.
+func getPath(o *output.Output, ftp *sftp.Client, rpath string, stat os.FileInfo, lpath string) error {
+	if stat.IsDir() {
+		if err := os.MkdirAll(lpath, 0o755); err != nil {
+			return err
+		}
+
+		return os.Chmod(lpath, stat.Mode())
+	}
+
+	rf, err := ftp.Open(rpath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", rpath, err)
+	}
+
+	defer rf.Close()
+
+	if err := os.MkdirAll(filepath.Dir(lpath), 0o755); err != nil {
+		return err
+	}
+
+	lf, err := os.OpenFile(lpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer lf.Close()
+
+	o.ProgressWG.Add(1)
+
+	if err := o.ProgressPrinter(stat.Size(), io.TeeReader(common.CreateRateLimit(rf), lf), rpath); err != nil {
+		return fmt.Errorf("get %s: %w", rpath, err)
+	}
+
+	return os.Chmod(lpath, stat.Mode())
+}
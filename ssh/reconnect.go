@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bingoohuang/bssh/conf"
+	"github.com/bingoohuang/bssh/sshlib"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// reconnectBackoffMin and reconnectBackoffMax are the wait range between reconnect attempts.
+	reconnectBackoffMin = 1 * time.Second
+	reconnectBackoffMax = 60 * time.Second
+
+	// reconnectCheckTimeout is the wait time for the keepalive reply, when checking the connection is dead.
+	reconnectCheckTimeout = 5 * time.Second
+)
+
+// printAutoReconnect is printout auto reconnect mode.
+// use ssh command run header. only use shell().
+func (r *Run) printAutoReconnect() {
+	if !r.AutoReconnect {
+		return
+	}
+
+	max := "unlimited"
+	if r.AutoReconnectMax > 0 {
+		max = fmt.Sprintf("%d", r.AutoReconnectMax)
+	}
+
+	fmt.Fprintf(os.Stderr, "AutoReconnect :on (max retry %s)\n", max)
+}
+
+// isDisconnected tells whether the shell ended because the connection is dead,
+// not because the remote shell exited.
+func isDisconnected(err error, connect *sshlib.Connect) bool {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+
+	return connect.CheckClientAliveTimeout(reconnectCheckTimeout) != nil
+}
+
+// reconnect re-creates the ssh.Client of connect through the same proxy route, with exponential backoff.
+// connect.Client is replaced by SetClient, so that the running local/dynamic forward listeners
+// keep serving over the new connection. Remote forwards are re-established.
+func (r *Run) reconnect(config *conf.ServerConfig, server string, connect *sshlib.Connect) error {
+	// the forwards fail to dial through the closed client until reconnected.
+	if client := connect.SSHClient(); client != nil {
+		_ = client.Close()
+	}
+
+	backoff := reconnectBackoffMin
+
+	for i := 1; r.AutoReconnectMax <= 0 || i <= r.AutoReconnectMax; i++ {
+		fmt.Fprintf(os.Stderr, "Reconnect     :%s (retry %d after %s)\n", server, i, backoff)
+		time.Sleep(backoff)
+
+		c, err := r.CreateSSHConnect(config, server)
+		if err == nil {
+			connect.SetClient(c.Client)
+			r.remotePortForwarding(config, connect)
+
+			go connect.SendClientKeepAlive()
+
+			fmt.Fprintf(os.Stderr, "Reconnect     :%s connected.\n", server)
+
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Reconnect     :%s error: %v\n", server, err)
+
+		if backoff *= 2; backoff > reconnectBackoffMax {
+			backoff = reconnectBackoffMax
+		}
+	}
+
+	return fmt.Errorf("reconnect %s: gave up after %d retries", server, r.AutoReconnectMax)
+}
@@ -2,14 +2,18 @@ package ssh
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bingoohuang/bssh/conf"
 	"github.com/bingoohuang/bssh/misc"
+	"github.com/bingoohuang/bssh/output"
 	"github.com/bingoohuang/bssh/sshlib"
 	"github.com/bingoohuang/ngg/ss"
 	"github.com/spf13/viper"
@@ -17,10 +21,6 @@ import (
 	"golang.org/x/term"
 )
 
-// TDXX(blacknon): 自動再接続機能の追加(v0.6.1)
-//     autosshのように、接続が切れた際に自動的に再接続を試みる動作をさせたい
-//     パラメータでの有効・無効指定が必要になる。
-
 // TDXX(blacknon): リバースでのsshfsの追加(v0.6.1以降？)
 //     lsshfs実装後になるか？ssh接続時に、指定したフォルダにローカルの内容をマウントさせて読み取らせる。
 //     うまくやれれば、ローカルのスクリプトなどをそのままマウントさせて実行させたりできるかもしれない。
@@ -51,9 +51,22 @@ type Run struct {
 	// not run (-N option)
 	IsNone bool
 
+	// ForwardID is the id of background port forwarding process (-f option),
+	// set only in the re-executed background process.
+	ForwardID string
+
+	// controlMaster is true in the control master process (bssh control master),
+	// it connects to the server directly.
+	controlMaster bool
+
 	// x11 forwarding (-X option)
 	X11 bool
 
+	// auto reconnect like autossh (-a option), only use shell().
+	// AutoReconnectMax is the max retry count at a time (-A option), 0 is unlimited.
+	AutoReconnect    bool
+	AutoReconnectMax int
+
 	// use or not-use local bashrc.
 	// IsNotBashrc takes precedence.
 	IsBashrc    bool
@@ -63,21 +76,55 @@ type Run struct {
 	EnableHeader  bool
 	DisableHeader bool
 
+	// OutputFormat is the output format in command mode (--output option),
+	// in `text`, `json` and `ndjson`.
+	OutputFormat string
+
+	// GroupOutput buffers the output of each server, and prints each distinct output once
+	// with the servers that produced it (--group-output option).
+	GroupOutput bool
+
+	// FailFast cancels the remaining servers after the first failure in command mode (--fail-fast option).
+	FailFast bool
+
+	// Concurrency is the max number of servers to connect and run at a time in command mode (--concurrency option),
+	// 0 is unlimited.
+	Concurrency int
+
+	// BatchSize is the number of servers in a rolling batch in command mode (--batch option), 0 is all at once.
+	// BatchPause is the pause, BatchCheck is the local health check command between batches.
+	// MaxFail halts further batches when the number of failed servers reaches it, 0 is unlimited.
+	BatchSize  int
+	BatchPause time.Duration
+	BatchCheck string
+	MaxFail    int
+
+	// Timeout is the deadline of command on each server (--timeout option),
+	// TotalTimeout is the deadline of all servers (--total-timeout option). 0 is unlimited.
+	// On expiry, SIGTERM is sent and then the session is closed.
+	Timeout      time.Duration
+	TotalTimeout time.Duration
+
+	// ExitCode is the exit code of command mode, set after Start().
+	ExitCode int
+
 	// StdinData from pipe flag
 	isStdinPipe bool
 
-	// local/remote Port Forwarding
-	PortForwardMode   string // L or R
-	PortForwardLocal  string
-	PortForwardRemote string
-
-	// Dynamic Port Forwarding
-	// set localhost port num (ex. 11080).
-	DynamicPortForward string
+	// local/remote/dynamic Port Forwarding (-L/-R/-D option, repeatable)
+	PortForwards []conf.PortForwardConfig
 
 	// Exec command
 	ExecCmd []string
 
+	// Script is the local script to upload and run on servers in command mode (--script option),
+	// ExecCmd is the arguments of it. ScriptFiles are the extra files uploaded with it (--script-file option),
+	// ScriptInterpreter runs it (--interpreter option), empty is by shebang or sh.
+	Script            string
+	ScriptFiles       []string
+	ScriptInterpreter string
+	script            *cmdScript
+
 	// Agent is ssh-agent.
 	// In agent.Agent or agent.ExtendedAgent.
 	agent interface{}
@@ -90,6 +137,16 @@ type Run struct {
 	// Map of AuthMethod used by target server
 	serverAuthMethodMap map[string][]ssh.AuthMethod
 
+	// recordPrinter prints structured output in command mode, nil in text format.
+	recordPrinter *output.RecordPrinter
+
+	// cmdResults collects the results of the command on all servers in command mode.
+	cmdResults *cmdResults
+
+	// serverConfigs caches the config of server names, connectMu guards it and the config on connecting.
+	serverConfigs map[string]conf.ServerConfig
+	connectMu     sync.Mutex
+
 	decodedPasswordMap map[string]bool
 	confFile           string
 	webPort            int
@@ -160,7 +217,7 @@ func (r *Run) Start() {
 	r.CreateAuthMethodMap()
 
 	switch {
-	case len(r.ExecCmd) > 0 && r.Mode == "cmd":
+	case (len(r.ExecCmd) > 0 || r.Script != "") && r.Mode == "cmd":
 		r.cmd()
 	case r.Mode == "shell":
 		err = r.shell()
@@ -186,9 +243,27 @@ func (r *Run) PrintSelectServer() {
 // use ssh command run header.
 func (r *Run) printRunCommand() {
 	runCmdStr := strings.Join(r.ExecCmd, " ")
+	if r.Script != "" {
+		fmt.Fprintf(os.Stderr, "Run Script    :%s\n", strings.TrimSpace(r.Script+" "+runCmdStr))
+		return
+	}
+
 	fmt.Fprintf(os.Stderr, "Run Command   :%s\n", runCmdStr)
 }
 
+// printPortForwards is printout all port forwarding.
+// use ssh command run header.
+func (r *Run) printPortForwards(forwards []conf.PortForwardConfig) {
+	for _, f := range forwards {
+		switch {
+		case f.IsDynamic():
+			r.printDynamicPortForward(f.Mode, net.JoinHostPort(f.DynamicAddr()))
+		default:
+			r.printPortForward(f.Mode, f.Local, f.Remote)
+		}
+	}
+}
+
 // printPortForward is printout port forwarding.
 // use ssh command run header. only use shell().
 func (r *Run) printPortForward(m, forwardLocal, forwardRemote string) {
@@ -209,12 +284,20 @@ func (r *Run) printPortForward(m, forwardLocal, forwardRemote string) {
 	}
 }
 
-// printPortForward is printout port forwarding.
-// use ssh command run header. only use shell().
-func (r *Run) printDynamicPortForward(port string) {
-	if port != "" {
-		fmt.Fprintf(os.Stderr, "DynamicForward:%s\n", port)
-		fmt.Fprintf(os.Stderr, "               %s\n", "connect Socks5.")
+// printDynamicPortForward is printout dynamic or reverse dynamic port forwarding.
+// use ssh command run header.
+func (r *Run) printDynamicPortForward(m, addr string) {
+	if addr == "" {
+		return
+	}
+
+	switch m {
+	case conf.PortForwardModeReverseDynamic:
+		fmt.Fprintf(os.Stderr, "DynamicForward:REMOTE\n")
+		fmt.Fprintf(os.Stderr, "               remote[%s] connect Socks5, dial from local.\n", addr)
+	default:
+		fmt.Fprintf(os.Stderr, "DynamicForward:LOCAL \n")
+		fmt.Fprintf(os.Stderr, "               local[%s] connect Socks5.\n", addr)
 	}
 }
 
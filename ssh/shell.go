@@ -46,9 +46,9 @@ func (r *Run) shell() (err error) {
 
 	// header
 	r.PrintSelectServer()
-	r.printPortForward(config.PortForwardMode, config.PortForwardLocal, config.PortForwardRemote)
-	r.printDynamicPortForward(config.DynamicPortForward)
+	r.printPortForwards(config.GetPortForwards())
 	r.printProxy(serverID)
+	r.printAutoReconnect()
 
 	if config.LocalRcUse == misc.Yes {
 		fmt.Fprintf(os.Stderr, "Information   :This connect use local bashrc.\n")
@@ -78,7 +78,8 @@ func (r *Run) shell() (err error) {
 		return err
 	}
 
-	if config.DirectServer {
+	// background process has no terminal to ask note of temp hosts.
+	if config.DirectServer && r.ForwardID == "" {
 		r.Conf.WriteTempHosts(config.ID, serverID, config.Pass)
 	}
 
@@ -86,19 +87,17 @@ func (r *Run) shell() (err error) {
 
 	err = r.portForwarding(&config, connect)
 
-	if config.DynamicPortForward != "" { // Dynamic Port Forwarding
-		go func() {
-			if err := connect.TCPDynamicForward("localhost", config.DynamicPortForward); err != nil {
-				fmt.Println(err)
-			}
-		}()
+	if r.AutoReconnect {
+		go connect.SendClientKeepAlive()
 	}
 
 	// switch check Not-execute flag
-	// TDXX(blacknon): Backgroundフラグを実装したら追加
 	switch {
+	case r.IsNone && r.ForwardID != "":
+		r.backgroundExecute(&config, serverID, connect)
+
 	case r.IsNone:
-		r.noneExecute()
+		r.noneExecute(&config, serverID, connect)
 
 	default:
 		// run pre local command
@@ -119,44 +118,64 @@ func (r *Run) shell() (err error) {
 			connect.SetLog(logPath, logConf.Timestamp)
 		}
 
-		// TDXX(blacknon): local rc file add
-		if config.LocalRcUse == misc.Yes {
-			err = localrcShell(connect, session, config.LocalRcPath, config.LocalRcDecodeCmd)
-		} else {
-			hostInfoAutoEnabled := r.Conf.HostInfoEnabled.Get()
-			hostInfoScript := readScriptFile(r.Conf.ConfPath, r.Conf.HostInfoScriptFile, defaultHostInfoScript)
-			processInfoScript := readScriptFile(r.Conf.ConfPath, r.Conf.ProcessInfoScriptFile, defaultProcessInfoScript)
+		for {
+			err = r.runShell(&config, serverID, connect, session)
+			if !r.AutoReconnect || !isDisconnected(err, connect) {
+				break
+			}
 
-			existsHostInfo := r.Conf.HostInfo[serverID]
+			if err = r.reconnect(&config, serverID, connect); err != nil {
+				break
+			}
 
-			if existsHostInfo.Info != "" {
-				hostInfoAutoEnabled = false
+			if session, err = connect.CreateSession(); err != nil {
+				break
 			}
 
-			err = connect.ShellInitial(session, ConvertKeys(config.InitialCmd), config.InitialCmdSleep.Duration,
-				r.webPort, hostInfoAutoEnabled, hostInfoScript,
-				func(hostInfo string) {
-					if existsHostInfo.Info == hostInfo {
-						return
-					}
-
-					r.Conf.HostInfo[serverID] = conf.HostInfo{
-						Info:   hostInfo,
-						Update: time.Now().Format("2006-01-02 15:04:05"),
-					}
-					hostInfoJson, _ := json.Marshal(r.Conf.HostInfo)
-					if len(hostInfoJson) > 0 {
-						if err := os.WriteFile(r.Conf.HostInfoJsonFile, hostInfoJson, os.ModePerm); err != nil {
-							log.Printf("write %q error: %v", r.Conf.HostInfoJsonFile, err)
-						}
-					}
-				}, processInfoScript)
+			r.sshAgent(&config, connect, session)
 		}
 	}
 
 	return err
 }
 
+// runShell runs login shell (or local rc shell) on session.
+func (r *Run) runShell(config *conf.ServerConfig, serverID string, connect *sshlib.Connect, session *ssh.Session) error {
+	// TDXX(blacknon): local rc file add
+	if config.LocalRcUse == misc.Yes {
+		return localrcShell(connect, session, config.LocalRcPath, config.LocalRcDecodeCmd)
+	}
+
+	hostInfoAutoEnabled := r.Conf.HostInfoEnabled.Get()
+	hostInfoScript := readScriptFile(r.Conf.ConfPath, r.Conf.HostInfoScriptFile, defaultHostInfoScript)
+	processInfoScript := readScriptFile(r.Conf.ConfPath, r.Conf.ProcessInfoScriptFile, defaultProcessInfoScript)
+
+	existsHostInfo := r.Conf.HostInfo[serverID]
+
+	if existsHostInfo.Info != "" {
+		hostInfoAutoEnabled = false
+	}
+
+	return connect.ShellInitial(session, ConvertKeys(config.InitialCmd), config.InitialCmdSleep.Duration,
+		r.webPort, hostInfoAutoEnabled, hostInfoScript,
+		func(hostInfo string) {
+			if existsHostInfo.Info == hostInfo {
+				return
+			}
+
+			r.Conf.HostInfo[serverID] = conf.HostInfo{
+				Info:   hostInfo,
+				Update: time.Now().Format("2006-01-02 15:04:05"),
+			}
+			hostInfoJson, _ := json.Marshal(r.Conf.HostInfo)
+			if len(hostInfoJson) > 0 {
+				if err := os.WriteFile(r.Conf.HostInfoJsonFile, hostInfoJson, os.ModePerm); err != nil {
+					log.Printf("write %q error: %v", r.Conf.HostInfoJsonFile, err)
+				}
+			}
+		}, processInfoScript)
+}
+
 func readScriptFile(confPath, scriptFile, defaultScript string) string {
 	script := []byte(defaultScript)
 	if scriptFile != "" {
@@ -289,43 +308,83 @@ func (r *Run) overwriteBashrcConfig(config *conf.ServerConfig) {
 	}
 }
 
+// overwritePortForwardConfig overwrites port forwarding of config by command line options.
+// The options replace the config port forwarding of the same mode.
 func (r *Run) overwritePortForwardConfig(config *conf.ServerConfig) {
-	// OverWrite port forward mode
-	if r.PortForwardMode != "" {
-		config.PortForwardMode = r.PortForwardMode
+	if len(r.PortForwards) == 0 {
+		return
 	}
 
-	// OverWrite port forwarding address
-	if r.PortForwardLocal != "" && r.PortForwardRemote != "" {
-		config.PortForwardLocal = r.PortForwardLocal
-		config.PortForwardRemote = r.PortForwardRemote
+	modes := map[string]bool{}
+	for _, f := range r.PortForwards {
+		modes[f.Mode] = true
 	}
 
-	// OverWrite dynamic port forwarding
-	if r.DynamicPortForward != "" {
-		config.DynamicPortForward = r.DynamicPortForward
+	var forwards []conf.PortForwardConfig
+
+	for _, f := range config.GetPortForwards() {
+		if !modes[f.Mode] {
+			forwards = append(forwards, f)
+		}
 	}
+
+	config.SetPortForwards(append(forwards, r.PortForwards...))
 }
 
+// portForwarding starts all local/remote/dynamic port forwarding of config on connect.
+// It returns the last error, and continues to start the others.
 func (r *Run) portForwarding(config *conf.ServerConfig, connect *sshlib.Connect) (err error) {
-	// Local/Remote Port Forwarding
-	if config.PortForwardLocal != "" && config.PortForwardRemote != "" {
-		// port forwarding
-		switch config.PortForwardMode {
-		case "L", "":
-			err = connect.TCPLocalForward(config.PortForwardLocal, config.PortForwardRemote)
-		case "R":
-			err = connect.TCPRemoteForward(config.PortForwardLocal, config.PortForwardRemote)
+	for _, f := range config.GetPortForwards() {
+		var e error
+
+		switch f.Mode {
+		case conf.PortForwardModeLocal:
+			e = connect.TCPLocalForward(f.Local, f.Remote)
+		case conf.PortForwardModeRemote:
+			e = connect.TCPRemoteForward(f.Local, f.Remote)
+		case conf.PortForwardModeDynamic:
+			go func(f conf.PortForwardConfig) {
+				if err := connect.TCPDynamicForward(f.DynamicAddr()); err != nil {
+					fmt.Println(err)
+				}
+			}(f)
+		case conf.PortForwardModeReverseDynamic:
+			r.reverseDynamicForwarding(f, connect)
 		}
 
-		if err != nil {
-			fmt.Println(err)
+		if e != nil {
+			fmt.Println(e)
+			err = e
 		}
 	}
 
 	return err
 }
 
+// reverseDynamicForwarding serves Socks5 at remote, and dials from local.
+func (r *Run) reverseDynamicForwarding(f conf.PortForwardConfig, connect *sshlib.Connect) {
+	go func() {
+		if err := connect.TCPReverseDynamicForward(f.DynamicAddr()); err != nil {
+			fmt.Println(err)
+		}
+	}()
+}
+
+// remotePortForwarding re-establishes remote and reverse dynamic port forwarding after reconnect.
+// Their listeners are on the server side, so they die with the old connection.
+func (r *Run) remotePortForwarding(config *conf.ServerConfig, connect *sshlib.Connect) {
+	for _, f := range config.GetPortForwards() {
+		switch f.Mode {
+		case conf.PortForwardModeRemote:
+			if err := connect.TCPRemoteForward(f.Local, f.Remote); err != nil {
+				fmt.Println(err)
+			}
+		case conf.PortForwardModeReverseDynamic:
+			r.reverseDynamicForwarding(f, connect)
+		}
+	}
+}
+
 // getLogPath return log file path.
 func (r *Run) getLogPath(server string) (logPath string) {
 	if idx := strings.Index(server, "@"); idx >= 0 {
@@ -403,7 +462,19 @@ func localrcShell(connect *sshlib.Connect, session *ssh.Session, localrcPath []s
 }
 
 // noneExecute is not execute command and shell.
-func (r *Run) noneExecute() {
-	for range time.After(500 * time.Millisecond) {
+// In auto reconnect mode, it waits for the connection to die and reconnects.
+func (r *Run) noneExecute(config *conf.ServerConfig, server string, connect *sshlib.Connect) {
+	if !r.AutoReconnect {
+		for range time.After(500 * time.Millisecond) {
+		}
+	}
+
+	for {
+		_ = connect.SSHClient().Wait()
+
+		if err := r.reconnect(config, server, connect); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
 	}
 }
@@ -0,0 +1,18 @@
+package ssh
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// timeoutGrace is the wait time from SIGTERM to closing the session on timeout.
+const timeoutGrace = 3 * time.Second
+
+// terminateSession sends SIGTERM to the remote command of session,
+// then closes the session after timeoutGrace, if it is still running.
+func terminateSession(session *ssh.Session) {
+	_ = session.Signal(ssh.SIGTERM)
+
+	time.AfterFunc(timeoutGrace, func() { _ = session.Close() })
+}
@@ -117,6 +117,22 @@ func CreateSignerPublicKeyPrompt(key, password string) (signer ssh.Signer, err e
 	return
 }
 
+// NeedPassphrase returns true if CreateSignerPublicKeyPrompt asks the passphrase of key on the terminal.
+func NeedPassphrase(key, password string) bool {
+	if password != "" {
+		return false
+	}
+
+	keyData, err := os.ReadFile(getAbsPath(key))
+	if err != nil {
+		return false
+	}
+
+	_, err = ssh.ParsePrivateKey(keyData)
+
+	return err != nil && regexp.MustCompile(`cannot decode`).MatchString(err.Error())
+}
+
 // CreateAuthMethodCertificate returns ssh.AuthMethod generated from Certificate.
 // To generate an AuthMethod from a certificate, you will need the certificate's private key Signer.
 // Signer should be generated from CreateSignerPublicKey() or CreateSignerPKCS11().
@@ -8,12 +8,15 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // Cmd connect and run command over ssh.
 // Output data is processed by channel because it is executed in parallel. If specification is troublesome, it is good to generate and process session from ssh package.
+// It returns the error of ssh.Session.Run (*ssh.ExitError if the command exits non-zero),
+// after all output is copied to Stdout and Stderr.
 func (c *Connect) Command(command string) (err error) {
 	// create session
 	if c.Session == nil {
@@ -39,22 +42,31 @@ func (c *Connect) Command(command string) (err error) {
 		c.Session.Stdin = stdin
 	}
 
+	// wait copying all output, before return.
+	var wg sync.WaitGroup
+
 	if c.Stdout != nil {
 		or, _ := c.Session.StdoutPipe()
-		go io.Copy(c.Stdout, or)
+		wg.Add(1)
+		go func() { defer wg.Done(); io.Copy(c.Stdout, or) }()
 	} else {
 		c.Session.Stdout = os.Stdout
 	}
 
 	if c.Stderr != nil {
 		er, _ := c.Session.StderrPipe()
-		go io.Copy(c.Stderr, er)
+		wg.Add(1)
+		go func() { defer wg.Done(); io.Copy(c.Stderr, er) }()
 	} else {
 		c.Session.Stderr = os.Stderr
 	}
 
 	// Run Command
-	c.Session.Run(command)
+	err = c.Session.Run(command)
+
+	// if failed to start, the channel is still open.
+	_ = c.Session.Close()
+	wg.Wait()
 
 	return
 }
@@ -1,12 +1,14 @@
 package sshlib
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -49,12 +51,22 @@ type Connect struct {
 	// CheckKnownHosts if true, check knownhosts.
 	CheckKnownHosts bool
 
+	// StrictHostKeyChecking is the policy of checking knownhosts,
+	// in `yes`, `accept-new`, `ask` and `no`. default is `ask`.
+	StrictHostKeyChecking string
+
+	// NonInteractive if true, never ask on the terminal. `ask` policy works as `yes`.
+	NonInteractive bool
+
 	// OverwriteKnownHosts if true, if the knownhost is different, check whether to overwrite.
 	OverwriteKnownHosts bool
 
 	// KnownHostsFiles is list of knownhosts files path.
 	KnownHostsFiles []string
 
+	// HostKey is the host key of the server accepted by CreateClient.
+	HostKey ssh.PublicKey
+
 	// TextAskWriteKnownHosts defines a confirmation message when writing a knownhost.
 	// We are using Go's template engine and have the following variables available.
 	// - Address ... ssh server hostname
@@ -91,6 +103,50 @@ type Connect struct {
 	LogKeepAnsiCode bool
 
 	toggleLogging *atomic.Bool
+
+	// clientMu guards Client, which is replaced by SetClient on reconnect
+	// while the forwarding goroutines dial through it.
+	clientMu sync.RWMutex
+
+	// keepAliveStop stops SendClientKeepAlive of the current Client, closed by SetClient. Guarded by clientMu.
+	keepAliveStop chan struct{}
+}
+
+// SSHClient returns c.Client, safe for the use concurrent with SetClient.
+func (c *Connect) SSHClient() *ssh.Client {
+	c.clientMu.RLock()
+	defer c.clientMu.RUnlock()
+
+	return c.Client
+}
+
+// SetClient replaces c.Client, e.g. by a reconnect. The running forwards dial through the new client,
+// and SendClientKeepAlive of the replaced client stops.
+func (c *Connect) SetClient(client *ssh.Client) {
+	c.clientMu.Lock()
+	defer c.clientMu.Unlock()
+
+	c.Client = client
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+}
+
+// keepAliveClient returns c.Client and the channel closed when it is replaced,
+// the previous SendClientKeepAlive stops, only one runs at a time.
+func (c *Connect) keepAliveClient() (*ssh.Client, <-chan struct{}) {
+	c.clientMu.Lock()
+	defer c.clientMu.Unlock()
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+	}
+
+	c.keepAliveStop = make(chan struct{})
+
+	return c.Client, c.keepAliveStop
 }
 
 func (c *Connect) Exit() {
@@ -113,7 +169,7 @@ func (c *Connect) CreateClient(host, port, user string, authMethods []ssh.AuthMe
 		Timeout: time.Duration(timeout) * time.Second,
 	}
 
-	if c.CheckKnownHosts {
+	if c.CheckKnownHosts && c.StrictHostKeyChecking != HostKeyCheckingNo {
 		if len(c.KnownHostsFiles) == 0 {
 			// append default files
 			c.KnownHostsFiles = append(c.KnownHostsFiles, "~/.ssh/known_hosts")
@@ -123,6 +179,17 @@ func (c *Connect) CreateClient(host, port, user string, authMethods []ssh.AuthMe
 		sc.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	}
 
+	hostKeyCallback := sc.HostKeyCallback
+	sc.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := hostKeyCallback(hostname, remote, key); err != nil {
+			return err
+		}
+
+		c.HostKey = key
+
+		return nil
+	}
+
 	if env := os.Getenv("SSH_CIPHER"); env != "" {
 		sc.Ciphers = strings.Split(env, ",")
 	}
@@ -164,6 +231,10 @@ func (c *Connect) CreateClient(host, port, user string, authMethods []ssh.AuthMe
 		}
 	}
 
+	if c.CheckKnownHosts && c.StrictHostKeyChecking != HostKeyCheckingNo {
+		sc.HostKeyAlgorithms = c.knownHostKeyAlgorithms(uri, netConn.RemoteAddr())
+	}
+
 	// Create new ssh connect
 	sshCon, channel, req, err := ssh.NewClientConn(netConn, uri, sc)
 	if err != nil {
@@ -179,7 +250,7 @@ func (c *Connect) CreateClient(host, port, user string, authMethods []ssh.AuthMe
 // CreateSession retrun ssh.Session
 func (c *Connect) CreateSession() (session *ssh.Session, err error) {
 	// Create session
-	session, err = c.Client.NewSession()
+	session, err = c.SSHClient().NewSession()
 
 	return
 }
@@ -224,13 +295,63 @@ func (c *Connect) SendKeepAlive(session *ssh.Session) {
 
 // CheckClientAlive check alive ssh.Client.
 func (c *Connect) CheckClientAlive() error {
-	_, _, err := c.Client.SendRequest("keepalive", true, nil)
+	_, _, err := c.SSHClient().SendRequest("keepalive", true, nil)
 	if err == nil || err.Error() == "request failed" {
 		return nil
 	}
 	return err
 }
 
+// CheckClientAliveTimeout is CheckClientAlive with a deadline.
+// A keepalive over a black-holed connection never gets its reply, so it is treated as dead after timeout.
+func (c *Connect) CheckClientAliveTimeout(timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.CheckClientAlive() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return errors.New("keepalive timeout")
+	}
+}
+
+// SendClientKeepAlive send keepalive packet to c.Client, not to a session.
+// After SendKeepAliveMax failures the client is closed, so that c.Client.Wait() returns.
+// It returns when the client is closed, or replaced by SetClient.
+func (c *Connect) SendClientKeepAlive() {
+	client, stop := c.keepAliveClient()
+
+	// keep alive interval (default 30 sec)
+	interval := 30
+	if c.SendKeepAliveInterval > 0 {
+		interval = c.SendKeepAliveInterval
+	}
+
+	// keep alive max (default 5)
+	max := 5
+	if c.SendKeepAliveMax > 0 {
+		max = c.SendKeepAliveMax
+	}
+
+	alive := &Connect{Client: client}
+	for i := 0; i < max; {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+
+		if err := alive.CheckClientAliveTimeout(time.Duration(interval) * time.Second); err != nil {
+			i++
+		} else {
+			i = 0
+		}
+	}
+
+	client.Close()
+}
+
 // RequestTty requests the association of a pty with the session on the remote
 // host. Terminal size is obtained from the currently connected terminal
 func RequestTty(session *ssh.Session) (err error) {
@@ -240,9 +240,11 @@ func (c *Connect) TCPLocalForward(localAddr, remoteAddr string) (err error) {
 			}
 
 			// remote (type net.Conn)
-			remote, err := c.Client.Dial("tcp", remoteAddr)
+			// keep listening on error, c.Client may be replaced by a reconnect.
+			remote, err := c.SSHClient().Dial("tcp", remoteAddr)
 			if err != nil {
-				return
+				local.Close()
+				continue
 			}
 
 			// forward
@@ -322,7 +324,7 @@ func (c *Connect) TCPDynamicForward(address, port string) (err error) {
 	// Create Socks5 config
 	conf := &socks5.Config{
 		Dial: func(ctx context.Context, n, addr string) (net.Conn, error) {
-			return c.Client.Dial(n, addr)
+			return c.SSHClient().Dial(n, addr)
 		},
 		Resolver: socks5Resolver{},
 	}
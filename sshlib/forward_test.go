@@ -0,0 +1,171 @@
+package sshlib_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/bssh/sshlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestTCPLocalForwardReconnect replaces the client while the local forward is dialing, like reconnect.
+// Run with -race.
+func TestTCPLocalForwardReconnect(t *testing.T) {
+	c := &sshlib.Connect{Client: newForwardRemote(t, "1")}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	require.Nil(t, c.TCPLocalForward(addr, "remote:80"))
+
+	get := func() string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return ""
+		}
+
+		defer conn.Close()
+
+		// the forwarder waits for both sides, read only the id.
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1)
+		n, _ := io.ReadFull(conn, b)
+
+		return string(b[:n])
+	}
+
+	assert.Equal(t, "1", get())
+
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				get()
+			}
+		}
+	}()
+
+	old := c.SSHClient()
+	_ = old.Close()
+	c.SetClient(newForwardRemote(t, "2"))
+
+	assert.Eventually(t, func() bool { return get() == "2" }, 5*time.Second, 10*time.Millisecond,
+		"forwarded over the new client")
+
+	close(stop)
+	wg.Wait()
+}
+
+// TestSendClientKeepAliveReplaced stops the keepalive of the client replaced by reconnect, not to close it later.
+func TestSendClientKeepAliveReplaced(t *testing.T) {
+	old := newForwardRemote(t, "1")
+	c := &sshlib.Connect{Client: old, SendKeepAliveInterval: 1}
+
+	done := make(chan struct{})
+
+	go func() {
+		c.SendClientKeepAlive()
+		close(done)
+	}()
+
+	replaced := newForwardRemote(t, "2")
+
+	assert.Eventually(t, func() bool {
+		c.SetClient(replaced)
+
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond, "stopped by SetClient")
+
+	_, _, err := old.SendRequest("keepalive@openssh.com", true, nil)
+	assert.Nil(t, err, "the replaced client is not closed by the keepalive")
+}
+
+// newForwardRemote returns the client of an in-memory ssh server, which writes id to each direct-tcpip channel.
+func newForwardRemote(t *testing.T, id string) *ssh.Client {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.Nil(t, err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// net.Pipe is not buffered, both sides of ssh write the version first.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+
+		go ssh.DiscardRequests(reqs)
+
+		for ch := range chans {
+			if ch.ChannelType() != "direct-tcpip" {
+				_ = ch.Reject(ssh.UnknownChannelType, "")
+				continue
+			}
+
+			go func(ch ssh.NewChannel) {
+				c, reqs, err := ch.Accept()
+				if err != nil {
+					return
+				}
+
+				go ssh.DiscardRequests(reqs)
+
+				_, _ = c.Write([]byte(id))
+				_ = c.Close()
+			}(ch)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, "remote",
+		&ssh.ClientConfig{User: "u", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	require.Nil(t, err)
+
+	client := ssh.NewClient(c, chans, reqs)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
@@ -6,11 +6,16 @@ package sshlib
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 
@@ -31,8 +36,41 @@ type OverwriteInventory struct {
 	OldKeyText  string
 }
 
-// verifyAndAppendNew checks knownhosts from the files stored in c.KnownHostsFiles.
-// If there is a problem with the known hosts check, it returns an error and the check content.
+// Host key checking policy, like OpenSSH `StrictHostKeyChecking`.
+const (
+	// HostKeyCheckingYes accepts only known hosts.
+	HostKeyCheckingYes = "yes"
+	// HostKeyCheckingAcceptNew adds unknown hosts to knownhosts without asking, refuses changed keys.
+	HostKeyCheckingAcceptNew = "accept-new"
+	// HostKeyCheckingAsk asks to add unknown hosts to knownhosts, refuses changed keys.
+	HostKeyCheckingAsk = "ask"
+	// HostKeyCheckingNo does not check host keys.
+	HostKeyCheckingNo = "no"
+)
+
+// NormalizeHostKeyChecking returns the host key checking policy from config value.
+// Empty value is `no`, unknown value is an error.
+func NormalizeHostKeyChecking(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "no", "off", "false":
+		return HostKeyCheckingNo, nil
+	case "yes", "on", "true":
+		return HostKeyCheckingYes, nil
+	case "accept-new":
+		return HostKeyCheckingAcceptNew, nil
+	case "ask":
+		return HostKeyCheckingAsk, nil
+	default:
+		return "", fmt.Errorf("unknown strict_host_key_checking %q, use yes, accept-new, ask or no", value)
+	}
+}
+
+// knownHostsMutex serializes writing knownhosts files from parallel connections.
+var knownHostsMutex sync.Mutex
+
+// verifyAndAppendNew checks knownhosts from the files stored in c.KnownHostsFiles,
+// by the policy c.StrictHostKeyChecking. Hashed hosts, `@cert-authority` and `@revoked` lines are supported.
+// New host key is appended to the first file of c.KnownHostsFiles.
 // If is no problem, error returns Nil.
 //
 // 【参考】: https://github.com/tatsushid/minssh/blob/57eae8c5bcf5d94639891f3267f05251f05face4/pkg/minssh/minssh.go#L190-L237
@@ -40,7 +78,7 @@ func (c *Connect) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.P
 	// set TextAskWriteKnownHosts default text
 	if len(c.TextAskWriteKnownHosts) == 0 {
 		c.TextAskWriteKnownHosts += "The authenticity of host '{{.Address}} ({{.RemoteAddr}})' can't be established.\n"
-		c.TextAskWriteKnownHosts += "RSA key fingerprint is {{.Fingerprint}}\n"
+		c.TextAskWriteKnownHosts += "Host key fingerprint is {{.Fingerprint}}\n"
 		c.TextAskWriteKnownHosts += "Are you sure you want to continue connecting (yes/no)?"
 	}
 
@@ -51,16 +89,24 @@ func (c *Connect) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.P
 		c.TextAskOverwriteKnownHosts += "Are you sure you want to overwrite {{.Fingerprint}}, continue connecting (yes/no)?"
 	}
 
+	policy := c.StrictHostKeyChecking
+	if policy == "" {
+		policy = HostKeyCheckingAsk
+	}
+
+	// can not ask, refuse unknown hosts.
+	if c.NonInteractive && policy == HostKeyCheckingAsk {
+		policy = HostKeyCheckingYes
+	}
+
 	// check count KnownHostsFiles
 	if len(c.KnownHostsFiles) == 0 {
 		return fmt.Errorf("there is no knownhosts file")
 	}
-	knownHostsFiles := c.KnownHostsFiles
 
-	// abspath
-	for i, file := range knownHostsFiles {
-		file = getAbsPath(file)
-		knownHostsFiles[i] = file
+	knownHostsFiles, err := existKnownHostsFiles(c.KnownHostsFiles)
+	if err != nil {
+		return err
 	}
 
 	// get hostKeyCallback
@@ -75,41 +121,143 @@ func (c *Connect) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.P
 		return nil
 	}
 
-	// var
-	filepath := knownHostsFiles[0]
-	var line int
+	// revoked key, or other errors.
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key verification failed: %w", err)
+	}
+
+	// host key is changed
+	if len(keyErr.Want) > 0 {
+		if policy != HostKeyCheckingAsk || !c.OverwriteKnownHosts {
+			w := keyErr.Want[0]
+			return fmt.Errorf("host key verification failed: host key for %s has changed, offending key in %s:%d",
+				hostname, w.Filename, w.Line)
+		}
 
-	// check error
-	keyErr, ok := err.(*knownhosts.KeyError)
-	if !ok || len(keyErr.Want) > 0 {
 		for _, w := range keyErr.Want {
-			oldkey := w.String()
-			line = w.Line
-
-			if answer, err := askOverwriteKnownHostKey(c.TextAskOverwriteKnownHosts, hostname, remote, key, oldkey); err != nil || !answer {
-				msg := "host key verification failed"
-				if err != nil {
-					msg += ": " + err.Error()
-				}
-				return fmt.Errorf(msg)
+			answer, err := askOverwriteKnownHostKey(c.TextAskOverwriteKnownHosts, hostname, remote, key, w.String())
+			if err != nil || !answer {
+				return hostKeyVerificationFailed(err)
+			}
+
+			if err := writeKnownHostsKey(w.Filename, w.Line, hostname, remote, key); err != nil {
+				log.Println(err)
 			}
 		}
-	} else {
+
+		return nil
+	}
+
+	// unknown host
+	switch policy {
+	case HostKeyCheckingYes:
+		return fmt.Errorf("host key verification failed: no host key is known for %s", hostname)
+	case HostKeyCheckingAsk:
 		if answer, err := askAddingUnknownHostKey(c.TextAskWriteKnownHosts, hostname, remote, key); err != nil || !answer {
-			msg := "host key verification failed"
-			if err != nil {
-				msg += ": " + err.Error()
-			}
-			return fmt.Errorf(msg)
+			return hostKeyVerificationFailed(err)
 		}
-		line = 0
 	}
 
-	err = writeKnownHostsKey(filepath, line, hostname, remote, key)
+	// OpenSSH also continues with a warning, when it can not add the key.
+	if err := writeKnownHostsKey(knownHostsFiles[0], 0, hostname, remote, key); err != nil {
+		log.Println(err)
+	}
 
 	return nil
 }
 
+// probeKey is a public key never known, to get the known keys of a host by the KeyError of knownhosts.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "bssh-probe" }
+func (probeKey) Marshal() []byte                     { return []byte("bssh-probe") }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("bssh-probe") }
+
+// knownHostKeyAlgorithms returns the host key algorithms of the keys known for the host in c.KnownHostsFiles.
+// The server may prefer another type of its keys, which knownhosts treats as a changed key.
+// It returns nil if no key is known, to use the default algorithms.
+func (c *Connect) knownHostKeyAlgorithms(hostname string, remote net.Addr) []string {
+	var files []string
+
+	for _, file := range c.KnownHostsFiles {
+		if file = getAbsPath(file); isFile(file) {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	hostKeyCallback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(hostKeyCallback(hostname, remote, probeKey{}), &keyErr) {
+		return nil
+	}
+
+	sort.Slice(keyErr.Want, func(i, j int) bool { return keyErr.Want[i].Key.Type() < keyErr.Want[j].Key.Type() })
+
+	var algos []string
+
+	for _, w := range keyErr.Want {
+		// a ssh-rsa key is signed by rsa-sha2-* too.
+		if typ := w.Key.Type(); typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		} else {
+			algos = append(algos, typ)
+		}
+	}
+
+	return algos
+}
+
+func hostKeyVerificationFailed(err error) error {
+	if err != nil {
+		return fmt.Errorf("host key verification failed: %w", err)
+	}
+
+	return errors.New("host key verification failed")
+}
+
+// existKnownHostsFiles returns absolute paths of existing files.
+// If none exists, the first file is created.
+func existKnownHostsFiles(files []string) ([]string, error) {
+	var result []string
+
+	for _, file := range files {
+		file = getAbsPath(file)
+		if _, err := os.Stat(file); err == nil {
+			result = append(result, file)
+		}
+	}
+
+	if len(result) > 0 {
+		return result, nil
+	}
+
+	file := getAbsPath(files[0])
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{file}, f.Close()
+}
+
+func isFile(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && !stat.IsDir()
+}
+
 // askAddingUnknownHostKey
 // 【参考】: https://github.com/tatsushid/minssh/blob/57eae8c5bcf5d94639891f3267f05251f05face4/pkg/minssh/minssh.go#L93-L128
 func askAddingUnknownHostKey(text string, address string, remote net.Addr, key ssh.PublicKey) (bool, error) {
@@ -209,7 +357,9 @@ func askOverwriteKnownHostKey(text string, address string, remote net.Addr, key
 }
 
 func writeKnownHostsKey(filepath string, linenum int, hostname string, remote net.Addr, key ssh.PublicKey) (err error) {
-	//
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
 	var addrs []string
 	if remote.String() == hostname {
 		addrs = []string{hostname}
@@ -221,7 +371,7 @@ func writeKnownHostsKey(filepath string, linenum int, hostname string, remote ne
 	entry := knownhosts.Line(addrs, key)
 	if linenum == 0 {
 		// open file
-		f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND, 0600)
+		f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
 		if err != nil {
 			return fmt.Errorf("failed to add new host key: %s", err)
 		}
@@ -231,38 +381,19 @@ func writeKnownHostsKey(filepath string, linenum int, hostname string, remote ne
 			return fmt.Errorf("failed to add new host key: %s", err)
 		}
 	} else {
-		// open file
-		fr, err := os.Open(filepath)
+		// read all lines, then replace the line of old key.
+		data, err := os.ReadFile(filepath)
 		if err != nil {
 			return fmt.Errorf("failed to add new host key: %s", err)
 		}
-		defer fr.Close()
 
-		fw, err := os.OpenFile(filepath, os.O_WRONLY, 0600)
-		if err != nil {
-			return fmt.Errorf("failed to add new host key: %s", err)
-		}
-		defer fw.Close()
-
-		scanner := bufio.NewScanner(fr)
-		writer := bufio.NewWriter(fw)
-		var line string
-
-		count := 1
-		for scanner.Scan() {
-			line = scanner.Text()
-
-			if count == linenum {
-				line = entry
-			}
-
-			writer.WriteString(line + "\n")
-			count += 1
+		lines := strings.Split(string(data), "\n")
+		if linenum <= len(lines) {
+			lines[linenum-1] = entry
 		}
 
-		err = writer.Flush()
-		if err != nil {
-			fmt.Println(err)
+		if err := os.WriteFile(filepath, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+			return fmt.Errorf("failed to add new host key: %s", err)
 		}
 	}
 
@@ -0,0 +1,139 @@
+package sshlib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestNormalizeHostKeyChecking(t *testing.T) {
+	type TestData struct {
+		desc   string
+		value  string
+		policy string
+		err    bool
+	}
+
+	tds := []TestData{
+		{desc: "Default", value: "", policy: HostKeyCheckingNo},
+		{desc: "No", value: "off", policy: HostKeyCheckingNo},
+		{desc: "Yes", value: " Yes ", policy: HostKeyCheckingYes},
+		{desc: "Yes by bool", value: "true", policy: HostKeyCheckingYes},
+		{desc: "Accept new", value: "accept-new", policy: HostKeyCheckingAcceptNew},
+		{desc: "Ask", value: "ask", policy: HostKeyCheckingAsk},
+		{desc: "Unknown value", value: "acept-new", err: true},
+	}
+
+	for _, v := range tds {
+		policy, err := NormalizeHostKeyChecking(v.value)
+		assert.Equal(t, v.policy, policy, v.desc)
+		assert.Equal(t, v.err, err != nil, v.desc)
+	}
+}
+
+func TestVerifyAndAppendNew(t *testing.T) {
+	known, other := newTestHostKey(t), newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+
+	type TestData struct {
+		desc           string
+		lines          []string
+		key            ssh.PublicKey
+		policy         string
+		nonInteractive bool
+		err            bool
+		appended       bool
+	}
+
+	tds := []TestData{
+		{
+			desc:  "Known host",
+			lines: []string{knownhosts.Line([]string{"host:2222"}, known)},
+			key:   known, policy: HostKeyCheckingYes,
+		},
+		{
+			desc: "Known by hashed host",
+			lines: []string{knownhosts.HashHostname(knownhosts.Normalize("host:2222")) + " " +
+				string(ssh.MarshalAuthorizedKey(known))},
+			key: known, policy: HostKeyCheckingYes,
+		},
+		{
+			desc: "Unknown host refused",
+			key:  known, policy: HostKeyCheckingYes, err: true,
+		},
+		{
+			desc: "Unknown host added",
+			key:  known, policy: HostKeyCheckingAcceptNew, appended: true,
+		},
+		{
+			desc: "Unknown host can not ask",
+			key:  known, policy: HostKeyCheckingAsk, nonInteractive: true, err: true,
+		},
+		{
+			desc:  "Changed key refused",
+			lines: []string{knownhosts.Line([]string{"host:2222"}, known)},
+			key:   other, policy: HostKeyCheckingAcceptNew, err: true,
+		},
+		{
+			desc:  "Revoked key refused",
+			lines: []string{"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(known)))},
+			key:   known, policy: HostKeyCheckingAcceptNew, err: true,
+		},
+	}
+
+	for _, v := range tds {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+		require.Nil(t, os.WriteFile(file, []byte(strings.Join(v.lines, "\n")), 0o600))
+
+		c := &Connect{KnownHostsFiles: []string{file}, StrictHostKeyChecking: v.policy, NonInteractive: v.nonInteractive}
+		err := c.verifyAndAppendNew("host:2222", remote, v.key)
+		assert.Equal(t, v.err, err != nil, v.desc)
+
+		data, err := os.ReadFile(file)
+		require.Nil(t, err)
+		assert.Equal(t, v.appended, strings.Contains(string(data), "[host]:2222,[127.0.0.1]:2222 "), v.desc)
+	}
+}
+
+func TestKnownHostKeyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	require.Nil(t, err)
+
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	lines := knownhosts.Line([]string{"host:2222"}, rsaPub) + "\n" +
+		knownhosts.Line([]string{"host:2222"}, newTestHostKey(t)) + "\n" +
+		knownhosts.Line([]string{"other"}, newTestHostKey(t)) + "\n"
+	require.Nil(t, os.WriteFile(file, []byte(lines), 0o600))
+
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	c := &Connect{KnownHostsFiles: []string{file, filepath.Join(t.TempDir(), "none")}}
+
+	assert.Equal(t, []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+		c.knownHostKeyAlgorithms("host:2222", remote), "algorithms of the known keys")
+	assert.Nil(t, c.knownHostKeyAlgorithms("unknown:2222", remote), "default algorithms")
+}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	require.Nil(t, err)
+
+	return key
+}
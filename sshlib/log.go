@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/lunixbochs/vtclean"
@@ -19,6 +20,8 @@ type logWriter struct {
 	// keep ansi code on terminal log.
 	logKeepAnsiCode bool
 
+	// mu guards buf, it is written by Write and read by cleanLog.
+	mu  sync.Mutex
 	buf bytes.Buffer
 }
 
@@ -43,6 +46,9 @@ func (l *logWriter) Write(p []byte) (n int, err error) {
 	}
 
 	if !l.logKeepAnsiCode || l.logTimestamp {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
 		return l.buf.Write(p)
 	}
 
@@ -52,10 +58,18 @@ func (l *logWriter) Write(p []byte) (n int, err error) {
 func (l *logWriter) cleanLog() {
 	var preLine []byte
 	for {
-		if l.buf.Len() > 0 {
+		l.mu.Lock()
+		size := l.buf.Len()
+
+		var line []byte
+		var err error
+		if size > 0 {
 			// get line
-			line, err := l.buf.ReadBytes('\n')
+			line, err = l.buf.ReadBytes('\n')
+		}
+		l.mu.Unlock()
 
+		if size > 0 {
 			if err == io.EOF {
 				preLine = append(preLine, line...)
 				continue
@@ -0,0 +1,47 @@
+package sshlib
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// TestLogWriter pins the terminal log of the shell, `.` is added at the line head for vtclean.Clean
+// and the timestamp is added before it.
+func TestLogWriter(t *testing.T) {
+	type TestData struct {
+		desc      string
+		timestamp bool
+		expect    string
+	}
+
+	// `\r` moves the cursor to the line head, `y` overwrites the first digit of the timestamp.
+	ts := `\d{4}/\d\d/\d\d \d\d:\d\d:\d\d `
+	overwritten := `\d{3}/\d\d/\d\d \d\d:\d\d:\d\d `
+
+	tds := []TestData{
+		{desc: "Without timestamp", expect: regexp.QuoteMeta("\nred text.plain\n\nybc")},
+		{desc: "With timestamp", timestamp: true, expect: "\n" + ts + "red text\\." + ts + "plain\n\ny" + overwritten + "abc"},
+	}
+
+	for _, v := range tds {
+		file := filepath.Join(t.TempDir(), "log")
+		f, err := os.Create(file)
+		require.Nil(t, err)
+
+		w := NewLogWrite(f, atomic.NewBool(true), v.timestamp, false)
+		_, err = w.Write([]byte("\x1b[31mred\x1b[0m text\r\nplain\nabc\rxy\r\n"))
+		require.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			data, _ := os.ReadFile(file)
+			return regexp.MustCompile("^" + v.expect + "$").Match(data)
+		}, 5*time.Second, 10*time.Millisecond, v.desc)
+	}
+}